@@ -2,56 +2,126 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"velocloud-cloudinit-builder/internal/builder"
+	"velocloud-cloudinit-builder/internal/config"
 	"velocloud-cloudinit-builder/internal/deps"
+	"velocloud-cloudinit-builder/internal/doctor"
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/inspect"
 	"velocloud-cloudinit-builder/internal/logutil"
 	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/redact"
+	"velocloud-cloudinit-builder/internal/secrets"
+	"velocloud-cloudinit-builder/internal/seeddiff"
+	"velocloud-cloudinit-builder/internal/sysutil"
+	"velocloud-cloudinit-builder/internal/validate"
 	"velocloud-cloudinit-builder/internal/vmtest"
 )
 
 func main() {
 	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		var code exitError
+		if errors.As(err, &code) {
+			os.Exit(int(code))
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", redact.String(err.Error()))
+		var coded *codedError
+		if errors.As(err, &coded) {
+			os.Exit(coded.code)
+		}
 		os.Exit(1)
 	}
 }
 
+// exitError makes main exit with the given code without printing an error,
+// for commands such as diff whose exit code is a result.
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// codedError makes main print err and exit with code instead of 1, for
+// commands such as diff that reserve 1 for a result.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
 func run() error {
-	args := stripGlobalFlags(os.Args[1:])
-	baseDir, err := os.Getwd()
+	args, globals, err := stripGlobalFlags(os.Args[1:])
 	if err != nil {
-		return fmt.Errorf("determine working directory: %w", err)
+		return err
+	}
+	baseDir, err := resolveBaseDir(globals.baseDir)
+	if err != nil {
+		return err
+	}
+
+	// Help, config init and doctor must work even when the configuration
+	// file is invalid.
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			return runConfig(baseDir, args[1:])
+		case "doctor":
+			return runDoctor(baseDir, globals, args[1:])
+		case "-h", "--help", "help":
+			printUsage(os.Stdout)
+			return nil
+		}
+	}
+	cfg, err := config.Load(baseDir)
+	if err == nil {
+		err = setSharedDirs(baseDir, cfg, globals)
+	}
+	if err != nil {
+		if len(args) > 0 && args[0] == "diff" {
+			// diff reserves exit status 1 for differences.
+			return &codedError{code: 2, err: err}
+		}
+		return err
 	}
 
 	if len(args) == 0 {
-		return runInteractive(baseDir)
+		return runInteractive(baseDir, cfg)
 	}
 
 	switch args[0] {
 	case "build":
-		return builder.Build(baseDir)
+		return runBuild(baseDir, cfg, args[1:])
 	case "test":
-		return runTest(baseDir, args[1:])
+		return runTest(baseDir, cfg, args[1:])
 	case "uninstall":
-		return runUninstall(baseDir, args[1:])
-	case "-h", "--help", "help":
-		printUsage(os.Stdout)
-		return nil
+		return runUninstall(baseDir, cfg, args[1:])
+	case "inspect":
+		return runInspect(args[1:])
+	case "diff":
+		return runDiff(baseDir, cfg, args[1:])
+	case "validate":
+		return runValidate(baseDir, cfg, args[1:])
+	case "secrets":
+		return runSecrets(baseDir, cfg, args[1:])
+	case "deps":
+		return runDeps(baseDir, cfg, args[1:])
 	default:
 		printUsage(os.Stderr)
 		return fmt.Errorf("unknown command: %s", args[0])
 	}
 }
 
-func runInteractive(baseDir string) error {
+func runInteractive(baseDir string, cfg *config.Config) error {
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Println()
@@ -66,26 +136,30 @@ func runInteractive(baseDir string) error {
 
 		switch choice {
 		case "1":
-			if err := builder.Build(baseDir); err != nil {
+			password, err := sysutil.ReadPassword(reader, "Password edge (enter untuk lewati): ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Gagal membaca password: %v\n", err)
+				continue
+			}
+			opts := builder.Options{Password: password, Config: cfg, SecretsPassphrase: secretsPassphrase(reader)}
+			if err := builder.Build(baseDir, opts); err != nil {
 				fmt.Fprintf(os.Stderr, "Gagal build ISO: %v\n", err)
 				continue
 			}
 			if promptYesNo(reader, "Tes VM sekarang? [Y/n]: ") {
-				vmPath := promptVMPath(reader)
-				if err := vmtest.Run(baseDir, vmPath, nil); err != nil {
+				if err := runVMTest(baseDir, cfg, promptVMPath(reader)); err != nil {
 					fmt.Fprintf(os.Stderr, "Gagal menjalankan VM: %v\n", err)
 				}
 			}
 		case "2":
-			vmPath := promptVMPath(reader)
-			if err := vmtest.Run(baseDir, vmPath, nil); err != nil {
+			if err := runVMTest(baseDir, cfg, promptVMPath(reader)); err != nil {
 				fmt.Fprintf(os.Stderr, "Gagal menjalankan VM: %v\n", err)
 			}
 		case "3":
 			if !promptYesNo(reader, "Uninstall akan menghapus semua file. Lanjut? [y/N]: ") {
 				continue
 			}
-			if err := runUninstall(baseDir, nil); err != nil {
+			if err := runUninstall(baseDir, cfg, nil); err != nil {
 				fmt.Fprintf(os.Stderr, "Uninstall gagal: %v\n", err)
 			}
 		case "4", "q", "Q", "exit", "keluar":
@@ -97,10 +171,69 @@ func runInteractive(baseDir string) error {
 	}
 }
 
-func runTest(baseDir string, args []string) error {
+func runBuild(baseDir string, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	backend := fs.String("backend", "", "ISO backend: "+strings.Join(builder.BackendNames(), ", "))
+	format := fs.String("format", "", "Seed image format: "+strings.Join(builder.FormatNames(), ", ")+" (default iso)")
+	out := fs.String("out", "", "Image file to write, or the output directory for --inventory (default images/)")
+	reproducible := fs.Bool("reproducible", false, "Pin timestamps for byte-identical output (also enabled by SOURCE_DATE_EPOCH)")
+	gzipUserData := fs.Bool("gzip-user-data", false, "Compress user-data with gzip")
+	tf := addTemplateFlags(fs)
+	inventory := fs.String("inventory", "", "CSV or YAML inventory; builds an image under images/<name>/ per row")
+	jobs := fs.Int("jobs", 0, fmt.Sprintf("Concurrent inventory builds (default %d)", cfg.Build.Jobs))
+	passwordPrompt := fs.Bool("password-prompt", false, "Prompt for the edge password (stored as a SHA-512 hash)")
+	passwordFile := fs.String("password-file", "", "Read the edge password from the first line of a file")
+	passwordUser := fs.String("password-user", "", "Set the password for this user via chpasswd instead of the default user")
+	var addFiles stringList
+	fs.Var(&addFiles, "add", "Graft a file or directory into the ISO as src=dest (repeatable)")
+	var sshKeys stringList
+	fs.Var(&sshKeys, "ssh-key", "Add the SSH public keys in this file to ssh_authorized_keys (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return nil
+		}
+		return err
+	}
+	stdin := bufio.NewReader(os.Stdin)
+	opts := tf.options()
+	opts.Config = cfg
+	opts.SecretsPassphrase = secretsPassphrase(stdin)
+	opts.Backend = *backend
+	opts.Format = *format
+	outPath, err := absFlagPath(*out)
+	if err != nil {
+		return err
+	}
+	opts.Out = outPath
+	opts.Reproducible = *reproducible
+	opts.GzipUserData = *gzipUserData
+	opts.Inventory = *inventory
+	opts.Jobs = *jobs
+	opts.PasswordFile = *passwordFile
+	opts.PasswordUser = *passwordUser
+	opts.SSHKeyFiles = sshKeys
+	opts.AddFiles = addFiles
+	if *passwordPrompt {
+		pw, err := promptPassword(stdin)
+		if err != nil {
+			return err
+		}
+		opts.Password = pw
+	}
+	return builder.Build(baseDir, opts)
+}
+
+func runTest(baseDir string, cfg *config.Config, args []string) error {
 	fs := flag.NewFlagSet("test", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 	vmPath := fs.String("vm", "", "Path to a portable VM executable (optional)")
+	isoPath := fs.String("iso", "", "cloud-init seed image to attach, ISO or vfat (default: the image build writes)")
+	diskPath := fs.String("disk", "", "Base qcow2 disk; the VM boots a temporary clone (default "+cfg.Test.Disk+")")
+	memory := fs.Int("memory", cfg.Test.MemoryMB, "Guest memory in MiB")
+	cpus := fs.Int("cpus", cfg.Test.CPUs, "Number of virtual CPUs")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			fs.SetOutput(os.Stdout)
@@ -109,10 +242,239 @@ func runTest(baseDir string, args []string) error {
 		}
 		return err
 	}
-	return vmtest.Run(baseDir, *vmPath, fs.Args())
+	// Flags override the configuration for this run only.
+	testCfg := *cfg
+	testCfg.Test.MemoryMB = *memory
+	testCfg.Test.CPUs = *cpus
+	if err := testCfg.Validate(); err != nil {
+		return err
+	}
+	opts, err := testOptions(baseDir, &testCfg, *vmPath)
+	if err != nil {
+		return err
+	}
+	if *isoPath != "" {
+		opts.ISOPath = *isoPath
+	}
+	if *diskPath != "" {
+		opts.DiskPath = *diskPath
+	}
+	return vmtest.Run(baseDir, opts, fs.Args())
+}
+
+// testOptions returns the VM test options for the ISO build writes and the
+// configured disk.
+func testOptions(baseDir string, cfg *config.Config, vmPath string) (vmtest.Options, error) {
+	isoPath, err := builder.ImagePath(baseDir, builder.Options{Config: cfg})
+	if err != nil {
+		return vmtest.Options{}, err
+	}
+	diskPath := filepath.FromSlash(cfg.Test.Disk)
+	if !filepath.IsAbs(diskPath) {
+		diskPath = filepath.Join(baseDir, diskPath)
+	}
+	return vmtest.Options{
+		VMPath:   vmPath,
+		ISOPath:  isoPath,
+		DiskPath: diskPath,
+		Config:   cfg,
+	}, nil
+}
+
+func runVMTest(baseDir string, cfg *config.Config, vmPath string) error {
+	opts, err := testOptions(baseDir, cfg, vmPath)
+	if err != nil {
+		return err
+	}
+	return vmtest.Run(baseDir, opts, nil)
+}
+
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	extractDir := fs.String("extract", "", "Extract all files from the ISO into this directory")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return nil
+		}
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("inspect requires exactly one ISO path")
+	}
+	return inspect.Run(positional[0], inspect.Options{ExtractDir: *extractDir}, os.Stdout)
+}
+
+// runDiff follows diff(1): it exits with 0 when the seeds are identical, 1
+// when they differ and 2 when the comparison fails.
+func runDiff(baseDir string, cfg *config.Config, args []string) (err error) {
+	defer func() {
+		var code exitError
+		if err != nil && !errors.As(err, &code) {
+			err = &codedError{code: 2, err: err}
+		}
+	}()
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	tf := addTemplateFlags(fs)
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Println("Usage: cloudinit-builder diff [--vars <file>] [--set key=value]... [--profile velocloud ...] <a.iso|templates-dir> <b.iso|templates-dir>")
+			fmt.Println("Exits with 0 when the seeds are identical, 1 when they differ and 2 on errors.")
+			fs.SetOutput(os.Stdout)
+			fs.PrintDefaults()
+			return nil
+		}
+		return err
+	}
+	if len(positional) != 2 {
+		return errors.New("diff requires two arguments: <a.iso|templates-dir> <b.iso|templates-dir>")
+	}
+	if *tf.templateDir != "" {
+		return errors.New("diff takes templates directories as arguments, not --templates")
+	}
+
+	// Only the diff goes to stdout; rendering stays silent.
+	output.SetQuiet(true)
+	reader := bufio.NewReader(os.Stdin)
+	render := func(dir, volumeID string) (map[string][]byte, error) {
+		opts := tf.options()
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		opts.TemplateDir = abs
+		opts.Format = builder.FormatForVolumeID(volumeID)
+		opts.Config = cfg
+		opts.SecretsPassphrase = secretsPassphrase(reader)
+		return builder.RenderFiles(baseDir, opts)
+	}
+	changed, err := seeddiff.Run(positional[0], positional[1], seeddiff.Options{Render: render}, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return exitError(1)
+	}
+	return nil
+}
+
+func runValidate(baseDir string, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	tf := addTemplateFlags(fs)
+	files, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return nil
+		}
+		return err
+	}
+	if len(files) == 0 {
+		opts := tf.options()
+		opts.Config = cfg
+		opts.SecretsPassphrase = secretsPassphrase(bufio.NewReader(os.Stdin))
+		return builder.Validate(baseDir, opts, os.Stdout)
+	}
+	var issues []validate.Issue
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, validate.Check(path, validate.SeedName(path), data)...)
+	}
+	return validate.Report(os.Stdout, issues)
+}
+
+func runSecrets(baseDir string, cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Println("Usage: cloudinit-builder secrets set <name> [--from-file <file>] | list | remove <name>")
+		return nil
+	}
+	path := filepath.Join(builder.TemplateDir(baseDir, builder.Options{Config: cfg}), secrets.FileName)
+	reader := bufio.NewReader(os.Stdin)
+	exists, err := fsutil.PathExists(path)
+	if err != nil {
+		return err
+	}
+	passphrase := os.Getenv(secrets.KeyEnvVar)
+	if passphrase == "" {
+		if exists {
+			passphrase, err = sysutil.ReadPassword(reader, "Secrets passphrase: ")
+		} else {
+			passphrase, err = promptNewPassphrase(reader)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	store := secrets.Store{}
+	if exists {
+		if store, err = secrets.ReadFile(path, passphrase); err != nil {
+			return err
+		}
+	}
+
+	switch args[0] {
+	case "list":
+		for _, name := range store.Names() {
+			fmt.Println(name)
+		}
+		return nil
+	case "set":
+		fs := flag.NewFlagSet("secrets set", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fromFile := fs.String("from-file", "", "Read the secret value from a file instead of prompting")
+		positional, err := parseInterspersed(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return errors.New("secrets set requires exactly one secret name")
+		}
+		var value string
+		if *fromFile != "" {
+			data, err := os.ReadFile(*fromFile)
+			if err != nil {
+				return err
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		} else if value, err = sysutil.ReadPassword(reader, "Value for "+positional[0]+": "); err != nil {
+			return err
+		}
+		if value == "" {
+			return errors.New("secret value is empty")
+		}
+		store[positional[0]] = value
+	case "remove":
+		if len(args) != 2 {
+			return errors.New("secrets remove requires exactly one secret name")
+		}
+		if _, ok := store[args[1]]; !ok {
+			return fmt.Errorf("secret %q is not defined", args[1])
+		}
+		delete(store, args[1])
+	default:
+		return fmt.Errorf("unknown secrets command: %s", args[0])
+	}
+	if err := fsutil.EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := secrets.WriteFile(path, passphrase, store); err != nil {
+		return err
+	}
+	output.Printf("[+] %s updated (%d secret(s)).\n", relPath(baseDir, path), len(store))
+	return nil
 }
 
-func runUninstall(baseDir string, args []string) error {
+func runUninstall(baseDir string, cfg *config.Config, args []string) error {
 	fs := flag.NewFlagSet("uninstall", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 	selfDelete := fs.Bool("self-delete", false, "Delete the executable after uninstall")
@@ -133,13 +495,17 @@ func runUninstall(baseDir string, args []string) error {
 
 	binaryPath, err := os.Executable()
 	if err != nil {
-		binaryPath = filepath.Join(baseDir, "cloudinit-builder.exe")
+		name := "cloudinit-builder"
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		binaryPath = filepath.Join(baseDir, name)
 	} else {
 		binaryPath, _ = filepath.Abs(binaryPath)
 	}
 
-	output.Println("[*] Removing tools/, images/, runtime/, cache/, templates/")
-	if err := deps.PerformUninstall(baseDir, *selfDelete, binaryPath, logger); err != nil {
+	output.Println("[*] Removing tools/, images/, runtime/, cache/, templates/ (shared tool and cache directories are kept)")
+	if err := deps.PerformUninstall(baseDir, *selfDelete, binaryPath, cfg.Deps, logger); err != nil {
 		logFile.Close()
 		return err
 	}
@@ -153,12 +519,140 @@ func runUninstall(baseDir string, args []string) error {
 		return fmt.Errorf("remove logs directory: %w", err)
 	}
 	if *selfDelete {
-		output.Println("[*] Deleting binary after exit...")
+		if runtime.GOOS == "windows" {
+			output.Println("[*] Deleting binary after exit...")
+		} else {
+			output.Printf("[*] Deleted %s\n", binaryPath)
+		}
 	}
 	output.Println("[+] Uninstall complete.")
 	return nil
 }
 
+func runDeps(baseDir string, cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Println("Usage: cloudinit-builder deps export <bundle.zip> | import <bundle.zip>")
+		return nil
+	}
+	if args[0] != "export" && args[0] != "import" {
+		return fmt.Errorf("unknown deps command: %s", args[0])
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("deps %s requires exactly one bundle path", args[0])
+	}
+	bundlePath, err := filepath.Abs(args[1])
+	if err != nil {
+		return err
+	}
+
+	logger, logFile, logPath, err := logutil.NewOperationLogger(baseDir, "deps")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	output.Printf("[*] Logging deps output to %s\n", relPath(baseDir, logPath))
+	if err := deps.EnsureBaseLayout(baseDir, logger); err != nil {
+		return err
+	}
+
+	if args[0] == "export" {
+		output.Println("[*] Collecting the Podman and QEMU archives and the builder image...")
+		if err := deps.ExportBundle(baseDir, bundlePath, cfg, logFile, logger); err != nil {
+			return err
+		}
+		output.Printf("[+] Wrote offline bundle %s\n", bundlePath)
+		return nil
+	}
+	output.Printf("[*] Importing offline bundle %s...\n", bundlePath)
+	image, err := deps.ImportBundle(baseDir, bundlePath, cfg, logFile, logger)
+	if err != nil {
+		return err
+	}
+	output.Printf("[+] Imported the Podman and QEMU archives and image %s.\n", image)
+	return nil
+}
+
+func runDoctor(baseDir string, globals globalFlags, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("doctor takes no arguments")
+	}
+
+	// An invalid configuration is reported as a failed check; the other
+	// checks run against the defaults.
+	cfg, cfgErr := config.Load(baseDir)
+	if cfgErr != nil {
+		cfg = config.Default()
+	}
+	if err := setSharedDirs(baseDir, cfg, globals); err != nil {
+		return err
+	}
+	report := doctor.Run(baseDir, cfg, cfgErr)
+	if *asJSON {
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			return err
+		}
+	} else if err := report.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("doctor found %d failing check(s)", report.Failed)
+	}
+	return nil
+}
+
+func runConfig(baseDir string, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Println("Usage: cloudinit-builder config show | init [--force]")
+		return nil
+	}
+	path := config.Path(baseDir)
+	switch args[0] {
+	case "show":
+		if len(args) != 1 {
+			return errors.New("config show takes no arguments")
+		}
+		cfg, err := config.Load(baseDir)
+		if err != nil {
+			return err
+		}
+		return cfg.Write(os.Stdout)
+	case "init":
+		fs := flag.NewFlagSet("config init", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		force := fs.Bool("force", false, "Overwrite an existing configuration file")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if exists, err := fsutil.PathExists(path); err != nil {
+			return err
+		} else if exists && !*force {
+			return fmt.Errorf("%s already exists; use --force to overwrite it", relPath(baseDir, path))
+		}
+		var buf bytes.Buffer
+		if err := config.Default().Write(&buf); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		output.Printf("[+] Wrote default settings to %s\n", relPath(baseDir, path))
+		return nil
+	default:
+		return fmt.Errorf("unknown config command: %s", args[0])
+	}
+}
+
 func relPath(baseDir, target string) string {
 	rel, err := filepath.Rel(baseDir, target)
 	if err != nil {
@@ -169,25 +663,225 @@ func relPath(baseDir, target string) string {
 
 func printUsage(w io.Writer) {
 	fmt.Fprintln(w, "Usage:")
-	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] build")
-	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] test [--vm <path-to-portable-vm>] [-- <vm-extra-args>]")
+	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] build [--backend auto|native|host-tool|container|podman-machine] [--format iso|vfat|configdrive|guestinfo|ovf] [--out <path>] [--templates <dir>] [--reproducible] [--gzip-user-data] [--vars <file>] [--set key=value]... [--profile velocloud [--vco <host>] [--activation-code <code>] [--vco-ignore-cert-errors] [--vco-update]] [--password-prompt|--password-file <file>] [--password-user <name>] [--ssh-key <file.pub>]... [--add <src>=<dest>]... [--inventory <edges.csv|yaml> [--jobs N]]")
+	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] test [--vm <path-to-portable-vm>] [--iso <path>] [--disk <path>] [--memory <MiB>] [--cpus N] [-- <vm-extra-args>]")
 	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] uninstall [--self-delete]")
+	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] inspect <iso> [--extract <dir>]")
+	fmt.Fprintln(w, "  cloudinit-builder diff [--vars <file>] [--set key=value]... [--profile velocloud ...] <a.iso|templates-dir> <b.iso|templates-dir>  (exit 0: identical, 1: different, 2: error)")
+	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] validate [--templates <dir>] [--vars <file>] [--set key=value]... [--profile velocloud ...] [<seed-file>...]")
+	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] secrets set <name> [--from-file <file>] | list | remove <name>")
+	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] config show | init [--force]")
+	fmt.Fprintln(w, "  cloudinit-builder [-q|--quiet] deps export <bundle.zip> | import <bundle.zip>")
+	fmt.Fprintln(w, "  cloudinit-builder doctor [--json]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Global flags (accepted anywhere before --):")
+	fmt.Fprintln(w, "  --base-dir <dir>   Workspace for templates, images, runtime and logs (default: working directory)")
+	fmt.Fprintln(w, "  --tools-dir <dir>  Portable Podman and QEMU builds, shareable between workspaces (default <base-dir>/tools)")
+	fmt.Fprintln(w, "  --cache-dir <dir>  Downloaded archives, shareable between workspaces (default <base-dir>/cache)")
 }
 
-func stripGlobalFlags(args []string) []string {
-	if len(args) == 0 {
-		return args
+// templateFlags holds the template and profile flags shared by build and validate.
+type templateFlags struct {
+	fs             *flag.FlagSet
+	templateDir    *string
+	varsFile       *string
+	set            stringList
+	profile        *string
+	vco            *string
+	activationCode *string
+	ignoreCert     *bool
+	vcoUpdate      *bool
+}
+
+func addTemplateFlags(fs *flag.FlagSet) *templateFlags {
+	tf := &templateFlags{fs: fs}
+	tf.templateDir = fs.String("templates", "", "Templates directory (default templates/)")
+	tf.varsFile = fs.String("vars", "", "YAML file with template variables (default templates/vars.yaml when present)")
+	fs.Var(&tf.set, "set", "Set a template variable as key=value (repeatable)")
+	tf.profile = fs.String("profile", "", "Generate extra user-data: "+builder.ProfileVeloCloud)
+	tf.vco = fs.String("vco", "", "VeloCloud Orchestrator host (implies --profile velocloud)")
+	tf.activationCode = fs.String("activation-code", "", "VeloCloud Edge activation code (implies --profile velocloud)")
+	tf.ignoreCert = fs.Bool("vco-ignore-cert-errors", false, "Skip VCO certificate validation (implies --profile velocloud)")
+	tf.vcoUpdate = fs.Bool("vco-update", false, "Set vco_update in the activation block (implies --profile velocloud)")
+	return tf
+}
+
+// options converts the parsed flags into build options. The VeloCloud flags
+// become velocloud.* template variables, so they combine with vars files and
+// inventory rows.
+func (tf *templateFlags) options() builder.Options {
+	// Relative --templates paths are taken from the working directory, not
+	// the base directory.
+	templateDir, err := absFlagPath(*tf.templateDir)
+	if err != nil {
+		templateDir = *tf.templateDir
 	}
+	opts := builder.Options{TemplateDir: templateDir, VarsFile: *tf.varsFile, Set: tf.set, Profile: *tf.profile}
+	tf.fs.Visit(func(f *flag.Flag) {
+		var key string
+		switch f.Name {
+		case "vco":
+			key = "vco"
+		case "activation-code":
+			key = "activation_code"
+		case "vco-ignore-cert-errors":
+			key = "vco_ignore_cert_errors"
+		case "vco-update":
+			key = "vco_update"
+		default:
+			return
+		}
+		opts.Set = append(opts.Set, "velocloud."+key+"="+f.Value.String())
+		if opts.Profile == "" {
+			opts.Profile = builder.ProfileVeloCloud
+		}
+	})
+	return opts
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// parseInterspersed parses fs from args while allowing flags after positional
+// arguments, and returns the positional arguments in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// globalFlags holds the directory flags accepted anywhere before "--".
+type globalFlags struct {
+	baseDir  string
+	toolsDir string
+	cacheDir string
+}
+
+func stripGlobalFlags(args []string) ([]string, globalFlags, error) {
+	var g globalFlags
 	filtered := make([]string, 0, len(args))
-	for _, a := range args {
-		switch a {
-		case "-q", "--quiet":
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			// Everything after -- belongs to the VM executable.
+			filtered = append(filtered, args[i:]...)
+			break
+		}
+		if a == "-q" || a == "--quiet" {
 			output.SetQuiet(true)
+			continue
+		}
+		name, value, hasValue := strings.Cut(a, "=")
+		var target *string
+		switch name {
+		case "--base-dir":
+			target = &g.baseDir
+		case "--tools-dir":
+			target = &g.toolsDir
+		case "--cache-dir":
+			target = &g.cacheDir
 		default:
 			filtered = append(filtered, a)
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return nil, g, fmt.Errorf("%s requires a directory", name)
+			}
+			i++
+			value = args[i]
+		}
+		if value == "" {
+			return nil, g, fmt.Errorf("%s requires a directory", name)
+		}
+		*target = value
+	}
+	return filtered, g, nil
+}
+
+// resolveBaseDir returns the absolute workspace directory, creating it when
+// --base-dir names a new one. Without --base-dir the working directory is
+// used.
+func resolveBaseDir(dir string) (string, error) {
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("determine working directory: %w", err)
+		}
+		return wd, nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolve base dir: %w", err)
+	}
+	if err := fsutil.EnsureDir(abs); err != nil {
+		return "", fmt.Errorf("create base dir: %w", err)
+	}
+	return abs, nil
+}
+
+// setSharedDirs points deps at the tool and cache directories from the
+// flags, or from the configuration file relative to baseDir.
+func setSharedDirs(baseDir string, cfg *config.Config, g globalFlags) error {
+	dirs := []struct {
+		flag, configured string
+	}{
+		{g.toolsDir, cfg.Deps.ToolsDir},
+		{g.cacheDir, cfg.Deps.CacheDir},
+	}
+	resolved := make([]string, len(dirs))
+	for i, d := range dirs {
+		switch {
+		case d.flag != "":
+			abs, err := filepath.Abs(d.flag)
+			if err != nil {
+				return err
+			}
+			resolved[i] = abs
+		case d.configured != "":
+			resolved[i] = filepath.FromSlash(d.configured)
+			if !filepath.IsAbs(resolved[i]) {
+				resolved[i] = filepath.Join(baseDir, resolved[i])
+			}
 		}
 	}
-	return filtered
+	deps.SetSharedDirs(resolved[0], resolved[1])
+	return nil
+}
+
+// absFlagPath makes a relative path flag absolute against the working
+// directory, keeping a trailing separator, which marks a directory.
+func absFlagPath(p string) (string, error) {
+	if p == "" || filepath.IsAbs(p) {
+		return p, nil
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(p, "/") || strings.HasSuffix(p, string(filepath.Separator)) {
+		abs += string(filepath.Separator)
+	}
+	return abs, nil
 }
 
 func promptYesNo(reader *bufio.Reader, label string) bool {
@@ -201,6 +895,48 @@ func promptYesNo(reader *bufio.Reader, label string) bool {
 	return resp == "y" || resp == "yes"
 }
 
+// promptPassword asks for the password twice and fails when the entries differ.
+func promptPassword(reader *bufio.Reader) (string, error) {
+	pw, err := sysutil.ReadPassword(reader, "Edge password: ")
+	if err != nil {
+		return "", err
+	}
+	if pw == "" {
+		return "", errors.New("empty password")
+	}
+	confirm, err := sysutil.ReadPassword(reader, "Confirm password: ")
+	if err != nil {
+		return "", err
+	}
+	if pw != confirm {
+		return "", errors.New("passwords do not match")
+	}
+	return pw, nil
+}
+
+func promptNewPassphrase(reader *bufio.Reader) (string, error) {
+	pw, err := sysutil.ReadPassword(reader, "New secrets passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := sysutil.ReadPassword(reader, "Confirm passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pw != confirm {
+		return "", errors.New("passphrases do not match")
+	}
+	return pw, nil
+}
+
+// secretsPassphrase prompts for the passphrase of templates/secrets.enc when
+// a build needs it.
+func secretsPassphrase(reader *bufio.Reader) func() (string, error) {
+	return func() (string, error) {
+		return sysutil.ReadPassword(reader, "Secrets passphrase: ")
+	}
+}
+
 func promptVMPath(reader *bufio.Reader) string {
 	fmt.Print("Path VM portable (enter untuk gunakan QEMU bawaan): ")
 	resp, _ := reader.ReadString('\n')
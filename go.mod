@@ -2,3 +2,4 @@ module velocloud-cloudinit-builder
 
 go 1.21
 
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,241 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/deps"
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// Backend names accepted by Options.Backend and CLOUDINIT_BUILDER_BACKEND.
+const (
+	BackendAuto          = "auto"
+	BackendNative        = "native"
+	BackendHostTool      = "host-tool"
+	BackendContainer     = "container"
+	BackendPodmanMachine = "podman-machine"
+)
+
+const backendEnvVar = "CLOUDINIT_BUILDER_BACKEND"
+
+// backend produces an ISO image from a prepared request.
+type backend interface {
+	Name() string
+	// Available returns nil when the backend can run on this host, or the reason it cannot.
+	Available() error
+	// Reproducible reports whether the backend honours buildRequest.timestamp.
+	Reproducible() bool
+	Build(req *buildRequest) error
+}
+
+// buildRequest carries everything a backend needs to write one ISO.
+type buildRequest struct {
+	baseDir  string
+	isoPath  string
+	volumeID string
+	files    []isoEntry
+	// reproducible pins every timestamp to timestamp so that identical
+	// inputs yield byte-identical images.
+	reproducible bool
+	timestamp    time.Time
+	// cfg supplies the container image, timeouts and podman machine.
+	cfg *config.Config
+	// logOut receives the output of the commands a backend runs, with
+	// registered secrets masked.
+	logOut io.Writer
+	logger sysutil.Logger
+}
+
+// isoEntry is a single file placed into the ISO.
+type isoEntry struct {
+	// name is the slash separated path inside the ISO.
+	name string
+	// source is the absolute path of the file on the host.
+	source string
+}
+
+// fallbackOrder lists the backends tried, in order, when BackendAuto is selected.
+var fallbackOrder = []string{BackendNative, BackendHostTool, BackendContainer, BackendPodmanMachine}
+
+// BackendNames returns every selectable backend name.
+func BackendNames() []string {
+	return append([]string{BackendAuto}, fallbackOrder...)
+}
+
+func newBackend(name string) (backend, error) {
+	switch name {
+	case BackendNative:
+		return nativeBackend{}, nil
+	case BackendHostTool:
+		return hostToolBackend{}, nil
+	case BackendContainer:
+		return containerBackend{}, nil
+	case BackendPodmanMachine:
+		return podmanMachineBackend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown build backend %q (expected one of %s)", name, strings.Join(BackendNames(), ", "))
+	}
+}
+
+// CheckBackend resolves the backend that build would use without --backend
+// and reports whether it can run on this host. Auto is always usable, since
+// the native backend needs nothing.
+func CheckBackend(cfg *config.Config) (string, error) {
+	name := resolveBackendName("", cfg.Build.Backend)
+	if name == BackendAuto {
+		return name, nil
+	}
+	b, err := newBackend(name)
+	if err != nil {
+		return name, err
+	}
+	return name, b.Available()
+}
+
+// resolveBackendName applies the environment override, the configured
+// backend and the auto default.
+func resolveBackendName(name, configured string) string {
+	if name == "" {
+		name = strings.TrimSpace(os.Getenv(backendEnvVar))
+	}
+	if name == "" {
+		name = strings.TrimSpace(configured)
+	}
+	if name == "" {
+		name = BackendAuto
+	}
+	return strings.ToLower(name)
+}
+
+// runBackends builds req with the named backend, or walks fallbackOrder for BackendAuto.
+func runBackends(name string, req *buildRequest) error {
+	if name != BackendAuto {
+		b, err := newBackend(name)
+		if err != nil {
+			return err
+		}
+		if err := b.Available(); err != nil {
+			return fmt.Errorf("%s backend unavailable: %w", b.Name(), err)
+		}
+		if req.reproducible && !b.Reproducible() {
+			return fmt.Errorf("%s backend cannot produce reproducible images; use %s", b.Name(), BackendNative)
+		}
+		return runBackend(b, req)
+	}
+
+	var aggregate error
+	for _, candidate := range fallbackOrder {
+		b, _ := newBackend(candidate)
+		if err := b.Available(); err != nil {
+			req.logger.Printf("skipping %s backend: %v", b.Name(), err)
+			continue
+		}
+		if req.reproducible && !b.Reproducible() {
+			req.logger.Printf("skipping %s backend: not reproducible", b.Name())
+			continue
+		}
+		err := runBackend(b, req)
+		if err == nil {
+			return nil
+		}
+		req.logger.Printf("%s backend failed, trying next: %v", b.Name(), err)
+		output.Printf("[!] %s backend failed, falling back...\n", b.Name())
+		aggregate = errors.Join(aggregate, fmt.Errorf("%s: %w", b.Name(), err))
+	}
+	if aggregate == nil {
+		return errors.New("no build backend available")
+	}
+	return aggregate
+}
+
+func runBackend(b backend, req *buildRequest) error {
+	output.Printf("[*] ISO backend: %s\n", b.Name())
+	req.logger.Printf("using %s build backend", b.Name())
+	return b.Build(req)
+}
+
+// mkisofsArgs returns the genisoimage-compatible arguments for req, with
+// every source path passed through mapPath first.
+func mkisofsArgs(req *buildRequest, isoPath string, mapPath func(string) (string, error)) ([]string, error) {
+	args := []string{"-output", isoPath, "-volid", req.volumeID, "-joliet", "-rock", "-graft-points"}
+	for _, f := range req.files {
+		src, err := mapPath(f.source)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, f.name+"="+src)
+	}
+	return args, nil
+}
+
+// containerPath maps a host path below baseDir onto the /work mount used by container backends.
+func containerPath(baseDir string) func(string) (string, error) {
+	return func(hostPath string) (string, error) {
+		rel, err := filepath.Rel(baseDir, hostPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return "", fmt.Errorf("%s is outside %s and cannot be mounted", hostPath, baseDir)
+		}
+		return "/work/" + filepath.ToSlash(rel), nil
+	}
+}
+
+// containerOutput returns the host path a container backend writes the ISO
+// to. Only baseDir is mounted, so an ISO destined outside it is written to a
+// directory below runtime/ instead, and publish moves it into place once the
+// build succeeded. publish must be called either way; it also cleans up.
+func containerOutput(req *buildRequest) (out string, publish func(ok bool) error, err error) {
+	if _, err := containerPath(req.baseDir)(req.isoPath); err == nil {
+		return req.isoPath, func(bool) error { return nil }, nil
+	}
+	runtimeDir := filepath.Join(req.baseDir, "runtime")
+	if err := fsutil.EnsureDir(runtimeDir); err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp(runtimeDir, "out-")
+	if err != nil {
+		return "", nil, err
+	}
+	out = filepath.Join(dir, filepath.Base(req.isoPath))
+	req.logger.Printf("%s is outside %s; building it in %s", req.isoPath, req.baseDir, dir)
+	return out, func(ok bool) error {
+		defer os.RemoveAll(dir)
+		if !ok {
+			return nil
+		}
+		if err := os.Rename(out, req.isoPath); err == nil {
+			return nil
+		}
+		return fsutil.CopyFile(out, req.isoPath)
+	}, nil
+}
+
+// genisoimageScript returns the shell script run inside the Debian builder
+// image. genisoimage is only installed when the image lacks it, so images
+// imported with deps import work offline.
+func genisoimageScript(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, shellQuote(a))
+	}
+	return strings.Join([]string{
+		"set -euo pipefail",
+		deps.GenisoimageInstall,
+		"genisoimage " + strings.Join(quoted, " "),
+	}, " && ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`&|;<>()*?[]{}!#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
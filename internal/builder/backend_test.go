@@ -0,0 +1,36 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+
+	"velocloud-cloudinit-builder/internal/config"
+)
+
+// config cannot import builder, so it keeps its own copy of the names it
+// validates build.backend and build.format against.
+func TestConfigNames(t *testing.T) {
+	if got := config.BackendNames; !reflect.DeepEqual(got, BackendNames()) {
+		t.Errorf("config.BackendNames = %q, want %q", got, BackendNames())
+	}
+	if got := config.FormatNames; !reflect.DeepEqual(got, FormatNames()) {
+		t.Errorf("config.FormatNames = %q, want %q", got, FormatNames())
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	for _, name := range BackendNames() {
+		if name == BackendAuto {
+			continue
+		}
+		b, err := newBackend(name)
+		if err != nil || b.Name() != name {
+			t.Errorf("newBackend(%q) = %v, %v", name, b, err)
+		}
+	}
+	for _, name := range []string{"podman", "docker", ""} {
+		if _, err := newBackend(name); err == nil {
+			t.Errorf("newBackend(%q) succeeded", name)
+		}
+	}
+}
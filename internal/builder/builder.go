@@ -4,129 +4,459 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"velocloud-cloudinit-builder/internal/config"
 	"velocloud-cloudinit-builder/internal/deps"
 	"velocloud-cloudinit-builder/internal/fsutil"
 	"velocloud-cloudinit-builder/internal/logutil"
 	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/redact"
+	"velocloud-cloudinit-builder/internal/render"
+	"velocloud-cloudinit-builder/internal/secrets"
 	"velocloud-cloudinit-builder/internal/sysutil"
+	"velocloud-cloudinit-builder/internal/validate"
 )
 
 const (
-	imageName         = "docker.io/library/debian:bookworm"
-	podmanPullTimeout = 10 * time.Minute
-	podmanRunTimeout  = 15 * time.Minute
-	buildLogPrefix    = "build"
+	buildLogPrefix = "build"
+	volumeID       = "cidata"
+	// defaultVarsFile is loaded from the templates directory when no vars
+	// file is given explicitly.
+	defaultVarsFile = "vars.yaml"
 )
 
-// Build orchestrates the ISO creation flow.
-func Build(baseDir string) (err error) {
-	logger, logFile, logPath, err := logutil.NewOperationLogger(baseDir, buildLogPrefix)
+// seedFiles maps the NoCloud file names inside the ISO to their templates.
+// Optional seeds are only packed when their template exists.
+var seedFiles = []struct {
+	name     string
+	template string
+	optional bool
+}{
+	{name: "user-data", template: "user-data.txt"},
+	{name: "meta-data", template: "meta-data.txt"},
+	{name: "network-config", template: "network-config.txt", optional: true},
+	{name: "vendor-data", template: "vendor-data.txt", optional: true},
+}
+
+// Options tunes a single build run.
+type Options struct {
+	// Backend selects how the ISO is produced. Empty falls back to
+	// CLOUDINIT_BUILDER_BACKEND, the configured backend and then to
+	// BackendAuto.
+	Backend string
+	// Format selects the seed image: FormatISO (default), FormatVFAT,
+	// FormatConfigDrive, FormatGuestinfo or FormatOVF.
+	Format string
+	// Reproducible pins timestamps so identical templates give a byte-identical
+	// ISO. Setting SOURCE_DATE_EPOCH enables it as well.
+	Reproducible bool
+	// VarsFile is a YAML file of template variables. Empty uses
+	// templates/vars.yaml when it exists.
+	VarsFile string
+	// Set holds key=value template variables that override VarsFile and the
+	// environment.
+	Set []string
+	// Inventory is a CSV or YAML file with one row per edge. When set, an ISO
+	// is built for every row under images/<name>/.
+	Inventory string
+	// Profile generates extra seed content from template variables.
+	// ProfileVeloCloud appends the velocloud: vce: activation block built
+	// from the velocloud.* variables.
+	Profile string
+	// Password is hashed with SHA-512 crypt and written to user-data, replacing
+	// any plaintext password. Empty falls back to PasswordFile and then to
+	// CLOUDINIT_BUILDER_PASSWORD. Values that are already crypt hashes are
+	// used unchanged.
+	Password string
+	// PasswordFile holds the password on its first line.
+	PasswordFile string
+	// PasswordUser receives the password through chpasswd.users. Empty sets
+	// the top-level password of cloud-init's default user.
+	PasswordUser string
+	// SSHKeyFiles lists public key files whose keys are added to
+	// ssh_authorized_keys.
+	SSHKeyFiles []string
+	// Jobs limits how many inventory builds run concurrently. Zero or less
+	// uses the configured number of jobs.
+	Jobs int
+	// AddFiles lists extra src=dest files grafted into the ISO, in addition
+	// to those below templates/extra. A directory source adds its contents.
+	AddFiles []string
+	// GzipUserData compresses user-data, which cloud-init accepts and which
+	// keeps large multipart payloads small.
+	GzipUserData bool
+	// Out is the image file written by a single build, or the directory
+	// that receives images/<name>/ for an inventory build. Empty falls back
+	// to CLOUDINIT_BUILDER_OUT, the configured path and then to images/. A
+	// path ending in a separator or naming an existing directory gets the
+	// format's default file name.
+	Out string
+	// TemplateDir holds the seed templates. Empty falls back to
+	// CLOUDINIT_BUILDER_TEMPLATES, the configured directory and then to
+	// templates/.
+	TemplateDir string
+	// Config supplies the defaults below flags and environment variables,
+	// the container image, timeouts and podman machine. Nil uses
+	// config.Default().
+	Config *config.Config
+	// SecretsPassphrase asks for the passphrase of templates/secrets.enc
+	// when CLOUDINIT_BUILDER_SECRETS_KEY is unset. Nil fails instead.
+	SecretsPassphrase func() (string, error)
+}
+
+// config returns opts.Config, or the defaults when it is nil.
+func (opts Options) config() *config.Config {
+	if opts.Config != nil {
+		return opts.Config
+	}
+	return config.Default()
+}
+
+// Build orchestrates the ISO creation flow. When opts.Inventory is set, one
+// ISO is built per inventory row instead.
+func Build(baseDir string, opts Options) error {
+	s, err := newSession(baseDir, opts)
+	if err != nil {
+		return err
+	}
+	defer s.logFile.Close()
+
+	if opts.Inventory != "" {
+		return s.buildInventory(opts.Inventory, opts.Jobs, inventoryDir(baseDir, outPath(opts)))
+	}
+
+	path := imagePath(baseDir, outPath(opts), s.format)
+	stageDir := filepath.Join(baseDir, "runtime", "render")
+	sum, err := s.buildImage(path, stageDir, s.vars, s.logger)
 	if err != nil {
 		return err
 	}
-	defer logFile.Close()
+	output.Printf("[*] SHA-256: %s\n", sum)
+
+	output.Printf("[+] Done: %s created.\n", filepath.ToSlash(pathRelative(baseDir, path)))
+	return nil
+}
+
+// session holds the state shared by every ISO produced in one build run.
+type session struct {
+	baseDir      string
+	templateDir  string
+	backendName  string
+	format       string
+	profile      string
+	credentials  credentials
+	secrets      secrets.Store
+	extras       []extraFile
+	gzipUserData bool
+	reproducible bool
+	cfg          *config.Config
+	timestamp    time.Time
+	vars         render.Vars
+	logFile      *os.File
+	logger       sysutil.Logger
+}
+
+// newSession validates opts, opens the build log and prepares the workspace.
+// The caller must close s.logFile.
+func newSession(baseDir string, opts Options) (*session, error) {
+	cfg := opts.config()
+	backendName := resolveBackendName(opts.Backend, cfg.Build.Backend)
+	if backendName != BackendAuto {
+		if _, err := newBackend(backendName); err != nil {
+			return nil, err
+		}
+	}
+	format, err := checkFormat(defaultIfEmpty(opts.Format, cfg.Build.Format), backendName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProfile(opts.Profile); err != nil {
+		return nil, err
+	}
+	timestamp, reproducible, err := buildTimestamp(opts.Reproducible || cfg.Build.Reproducible)
+	if err != nil {
+		return nil, err
+	}
+	templateDir := TemplateDir(baseDir, opts)
+	vars, err := loadVars(templateDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	store, err := loadSecrets(templateDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Password == "" && opts.PasswordFile == "" && os.Getenv(passwordEnvVar) == "" {
+		opts.Password = store[passwordSecret]
+	}
+	creds, err := loadCredentials(opts)
+	if err != nil {
+		return nil, err
+	}
+	extras, err := collectExtras(templateDir, opts.AddFiles)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatGuestinfo && len(extras) > 0 {
+		return nil, fmt.Errorf("extra files cannot be delivered through %s; use an image format", FormatGuestinfo)
+	}
+
+	logger, logFile, logPath, err := logutil.NewOperationLogger(baseDir, buildLogPrefix)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		baseDir:      baseDir,
+		templateDir:  templateDir,
+		backendName:  backendName,
+		format:       format,
+		profile:      opts.Profile,
+		credentials:  creds,
+		secrets:      store,
+		extras:       extras,
+		gzipUserData: opts.GzipUserData || cfg.Build.GzipUserData,
+		reproducible: reproducible,
+		cfg:          cfg,
+		timestamp:    timestamp,
+		vars:         vars,
+		logFile:      logFile,
+		logger:       logger,
+	}
 
 	output.Printf("[*] Logging build output to %s\n", pathRelative(baseDir, logPath))
 	output.Println("[*] Checking dependencies...")
 	if err := deps.EnsureBaseLayout(baseDir, logger); err != nil {
-		return fmt.Errorf("ensure base layout: %w", err)
+		logFile.Close()
+		return nil, fmt.Errorf("ensure base layout: %w", err)
 	}
 
-	if err := deps.EnsureTemplates(baseDir, logger); err != nil {
-		return fmt.Errorf("ensure templates: %w", err)
+	if err := deps.EnsureTemplates(templateDir, logger); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("ensure templates: %w", err)
 	}
+	if reproducible {
+		logger.Printf("reproducible build pinned to %s", timestamp.Format(time.RFC3339))
+	}
+	if len(store) > 0 {
+		logger.Printf("secrets available to templates: %s", strings.Join(store.Names(), ", "))
+	}
+	if creds.passwordHash != "" {
+		logger.Printf("injecting SHA-512 password hash (user: %s)", defaultIfEmpty(creds.user, "cloud-init default user"))
+	}
+	if len(creds.sshKeys) > 0 {
+		logger.Printf("injecting %d SSH public key(s)", len(creds.sshKeys))
+	}
+	warnExtraSize(extras, logger)
+	return s, nil
+}
 
-	var podmanPath string
-	var machineName string
-	var podmanEnv []string
+// buildImage renders the templates with vars into stageDir, writes the seed
+// image in the session's format to imagePath together with its checksum
+// file, and returns the SHA-256.
+func (s *session) buildImage(imagePath, stageDir string, vars render.Vars, logger sysutil.Logger) (sum string, err error) {
+	req := &buildRequest{
+		baseDir:  s.baseDir,
+		isoPath:  imagePath,
+		volumeID: imageVolumeID(s.format),
+		logOut:   redact.Writer(s.logFile),
+		logger:   logger,
 
+		reproducible: s.reproducible,
+		timestamp:    s.timestamp,
+		cfg:          s.cfg,
+	}
+	logger.Printf("template variables: %s", strings.Join(vars.Keys(), ", "))
+	r := render.New(s.templateDir, vars).WithSecrets(s.secrets)
+	if s.reproducible {
+		r.WithReproducibleSalt(strconv.FormatInt(s.timestamp.Unix(), 10))
+	}
+	seeds, err := renderSeeds(s.templateDir, r, logger)
+	if err != nil {
+		return "", err
+	}
+	parts, err := renderParts(s.templateDir, r, logger)
+	if err != nil {
+		return "", err
+	}
+	if err := applyProfile(s.profile, vars, s.secrets, seeds); err != nil {
+		return "", err
+	}
+	if err := applyCredentials(s.credentials, seeds); err != nil {
+		return "", err
+	}
+	// The staged copies may hold secrets, activation codes or passwords in
+	// plain text, so they are removed however the build ends.
 	defer func() {
-		if podmanPath == "" || machineName == "" || len(podmanEnv) == 0 {
+		if rmErr := os.RemoveAll(stageDir); rmErr != nil {
+			logger.Printf("remove staged seeds: %v", rmErr)
+			if err == nil {
+				sum, err = "", fmt.Errorf("remove staged seeds: %w", rmErr)
+			}
 			return
 		}
-		if stopErr := deps.StopPodmanMachine(baseDir, podmanPath, machineName, podmanEnv, logFile, logger); stopErr != nil {
-			fmt.Fprintf(os.Stderr, "warning: failed to stop podman machine: %v\n", stopErr)
-		} else if err == nil {
-			output.Println("[*] Podman machine stopped.")
-		}
+		logger.Printf("removed staged seeds from %s", stageDir)
 	}()
-
-	podmanPath, err = deps.EnsurePodman(baseDir, logger)
+	files, err := prepareSeeds(seeds, parts, s.gzipUserData, stageDir, logger)
 	if err != nil {
-		return fmt.Errorf("ensure podman: %w", err)
+		return "", err
+	}
+	switch s.format {
+	case FormatConfigDrive:
+		files, err = configDriveFiles(files, stageDir)
+	case FormatOVF:
+		files, err = ovfEnvFiles(files, stageDir, logger)
 	}
-	output.Println("[*] Podman ready.")
-
-	machineName, podmanEnv, err = deps.EnsurePodmanMachine(baseDir, podmanPath, logFile, logger)
 	if err != nil {
-		return fmt.Errorf("ensure podman machine: %w", err)
+		return "", err
 	}
-
-	output.Println("[*] Pulling Debian image...")
-	if err := runPodman(baseDir, podmanPath, machineName, podmanEnv, []string{"pull", imageName}, logFile, logger, podmanPullTimeout); err != nil {
-		return fmt.Errorf("podman pull: %w", err)
+	extraFiles, err := stageExtras(s.extras, stageDir)
+	if err != nil {
+		return "", err
+	}
+	req.files = append(files, extraFiles...)
+	if err := fsutil.EnsureDir(filepath.Dir(req.isoPath)); err != nil {
+		return "", err
+	}
+	switch s.format {
+	case FormatVFAT:
+		err = writeVFAT(req)
+	case FormatGuestinfo:
+		err = writeGuestinfo(req)
+	default:
+		if err := runBackends(s.backendName, req); err != nil {
+			return "", fmt.Errorf("build iso: %w", err)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("build image: %w", err)
 	}
+	sum, err = writeChecksum(req.isoPath)
+	if err != nil {
+		return "", err
+	}
+	logger.Printf("sha256 %s  %s", sum, req.isoPath)
+	return sum, nil
+}
 
-	output.Println("[*] Building cloud-init.iso with genisoimage...")
-	if err := runPodmanRun(baseDir, podmanPath, machineName, podmanEnv, logFile, logger); err != nil {
-		return fmt.Errorf("podman run: %w", err)
+// renderedSeed is a seed template after rendering.
+type renderedSeed struct {
+	name     string
+	template string
+	data     []byte
+}
+
+// renderSeeds renders every seed template in templateDir, skipping optional
+// seeds that have no template.
+func renderSeeds(templateDir string, r *render.Renderer, logger sysutil.Logger) ([]renderedSeed, error) {
+	var seeds []renderedSeed
+	for _, seed := range seedFiles {
+		data, err := os.ReadFile(filepath.Join(templateDir, seed.template))
+		if err != nil {
+			if seed.optional && os.IsNotExist(err) {
+				logger.Printf("no %s template, skipping %s", seed.template, seed.name)
+				continue
+			}
+			return nil, fmt.Errorf("read %s: %w", seed.template, err)
+		}
+		rendered, err := r.Render(seed.template, data)
+		if err != nil {
+			return nil, fmt.Errorf("render %s: %w", seed.template, err)
+		}
+		seeds = append(seeds, renderedSeed{name: seed.name, template: seed.template, data: rendered})
 	}
+	return seeds, nil
+}
 
-	output.Println("[+] Done: images/cloud-init.iso created.")
-	return nil
+// checkSeeds validates every rendered seed and user-data part and returns
+// all issues found.
+func checkSeeds(seeds, parts []renderedSeed) []validate.Issue {
+	var issues []validate.Issue
+	for _, group := range [][]renderedSeed{seeds, parts} {
+		for _, seed := range group {
+			issues = append(issues, validate.Check(seed.template, seed.name, seed.data)...)
+		}
+	}
+	return issues
 }
 
-func runPodman(baseDir, podmanPath, machineName string, env []string, args []string, logFile *os.File, logger sysutil.Logger, timeout time.Duration) error {
-	allArgs := append([]string{"--connection", machineName}, args...)
-	_, err := sysutil.RunCommand(sysutil.RunOptions{
-		Timeout: timeout,
-		Dir:     baseDir,
-		Logger:  logger,
-		Stdout:  logFile,
-		Stderr:  logFile,
-		Env:     env,
-	}, podmanPath, allArgs...)
-	return err
+// prepareSeeds validates the rendered seeds and parts, assembles user-data
+// and writes the seeds to stageDir. Validation warnings are logged and
+// printed; errors abort the build. The returned entries point at the staged
+// files.
+func prepareSeeds(seeds, parts []renderedSeed, gzipUserData bool, stageDir string, logger sysutil.Logger) ([]isoEntry, error) {
+	issues := checkSeeds(seeds, parts)
+	for _, issue := range issues {
+		logger.Printf("validate: %s", issue)
+		if issue.Warning {
+			output.Printf("[!] %s\n", issue)
+		}
+	}
+	if err := validate.Errors(issues); err != nil {
+		return nil, fmt.Errorf("validation failed:\n%w", err)
+	}
+	if err := assembleUserData(seeds, parts, gzipUserData, logger); err != nil {
+		return nil, err
+	}
+
+	if err := fsutil.RemoveIfExists(stageDir); err != nil {
+		return nil, err
+	}
+	if err := fsutil.EnsureDir(filepath.Dir(stageDir)); err != nil {
+		return nil, err
+	}
+	// Only the current user may read the rendered seeds.
+	if err := os.Mkdir(stageDir, 0o700); err != nil {
+		return nil, err
+	}
+	var files []isoEntry
+	for _, seed := range seeds {
+		staged := filepath.Join(stageDir, seed.name)
+		if err := os.WriteFile(staged, seed.data, 0o644); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", seed.name, err)
+		}
+		output.Printf("[*] Including %s\n", seed.name)
+		files = append(files, isoEntry{name: seed.name, source: staged})
+	}
+	return files, nil
 }
 
-func runPodmanRun(baseDir, podmanPath, machineName string, env []string, logFile *os.File, logger sysutil.Logger) error {
-	isoPath := filepath.Join(baseDir, "images", "cloud-init.iso")
-	if err := os.Remove(isoPath); err != nil && !os.IsNotExist(err) {
-		return err
+// loadVars merges template variables from the vars file, the environment and
+// opts.Set, in increasing order of precedence.
+func loadVars(templateDir string, opts Options) (render.Vars, error) {
+	vars := render.Vars{}
+	varsFile := opts.VarsFile
+	if varsFile == "" {
+		candidate := filepath.Join(templateDir, defaultVarsFile)
+		if exists, err := fsutil.PathExists(candidate); err != nil {
+			return nil, err
+		} else if exists {
+			varsFile = candidate
+		}
 	}
+	if varsFile != "" {
+		fileVars, err := render.LoadFile(varsFile)
+		if err != nil {
+			return nil, fmt.Errorf("load vars: %w", err)
+		}
+		vars.Merge(fileVars)
+	}
+	vars.Merge(render.FromEnv(os.Environ()))
+	for _, assignment := range opts.Set {
+		if err := vars.Set(assignment); err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
 
-	mountArg := fmt.Sprintf("%s:/work", filepath.Clean(baseDir))
-	buildScript := strings.Join([]string{
-		"set -euo pipefail",
-		"apt-get update -qq",
-		"apt-get install -y genisoimage",
-		"genisoimage -output /work/images/cloud-init.iso -volid cidata -joliet -rock -graft-points user-data=/work/templates/user-data.txt meta-data=/work/templates/meta-data.txt",
-	}, " && ")
-	podmanArgs := []string{
-		"run",
-		"--rm",
-		"-v", mountArg,
-		"-w", "/work",
-		imageName,
-		"bash",
-		"-c",
-		buildScript,
-	}
-	if err := fsutil.EnsureDir(filepath.Dir(isoPath)); err != nil {
-		return err
+func defaultIfEmpty(v, def string) string {
+	if v == "" {
+		return def
 	}
-	_, err := sysutil.RunCommand(sysutil.RunOptions{
-		Timeout: podmanRunTimeout,
-		Dir:     baseDir,
-		Logger:  logger,
-		Stdout:  logFile,
-		Stderr:  logFile,
-		Env:     env,
-	}, podmanPath, append([]string{"--connection", machineName}, podmanArgs...)...)
-	return err
+	return v
 }
 
 func pathRelative(baseDir, target string) string {
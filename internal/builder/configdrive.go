@@ -0,0 +1,316 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configDriveDir is where cloud-init's ConfigDrive datasource reads the
+// OpenStack metadata.
+const configDriveDir = "openstack/latest"
+
+// configDriveFiles maps the staged NoCloud seeds onto the OpenStack config
+// drive layout. meta-data becomes meta_data.json, network-config is
+// converted to network_data.json and vendor-data is wrapped in
+// vendor_data.json. The JSON files are written to stageDir.
+func configDriveFiles(seeds []isoEntry, stageDir string) ([]isoEntry, error) {
+	var files []isoEntry
+	for _, seed := range seeds {
+		data, err := os.ReadFile(seed.source)
+		if err != nil {
+			return nil, err
+		}
+		var name string
+		var doc interface{}
+		switch seed.name {
+		case "user-data":
+			files = append(files, isoEntry{name: configDriveDir + "/user_data", source: seed.source})
+			continue
+		case "meta-data":
+			name = "meta_data.json"
+			doc, err = configDriveMetaData(data)
+		case "network-config":
+			name = "network_data.json"
+			doc, err = configDriveNetworkData(data)
+		case "vendor-data":
+			name = "vendor_data.json"
+			doc = map[string]string{"cloud-init": string(data)}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("config drive %s: %w", name, err)
+		}
+		out, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		staged := filepath.Join(stageDir, name)
+		if err := os.WriteFile(staged, append(out, '\n'), 0o644); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", name, err)
+		}
+		files = append(files, isoEntry{name: configDriveDir + "/" + name, source: staged})
+	}
+	return files, nil
+}
+
+// configDriveMetaData converts NoCloud meta-data to meta_data.json. Keys
+// without an OpenStack equivalent are passed through.
+func configDriveMetaData(data []byte) (map[string]interface{}, error) {
+	md := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &md); err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	for k, v := range md {
+		switch k {
+		case "instance-id":
+			out["uuid"] = fmt.Sprint(v)
+		case "local-hostname":
+			out["hostname"] = fmt.Sprint(v)
+			out["name"] = fmt.Sprint(v)
+		case "public-keys":
+			keys := map[string]string{}
+			switch t := v.(type) {
+			case string:
+				keys["key-0"] = t
+			case []interface{}:
+				for i, k := range t {
+					keys[fmt.Sprintf("key-%d", i)] = fmt.Sprint(k)
+				}
+			default:
+				return nil, errors.New("public-keys: expected a string or a list")
+			}
+			out["public_keys"] = keys
+		default:
+			out[k] = v
+		}
+	}
+	if out["uuid"] == nil {
+		return nil, errors.New("meta-data must set instance-id")
+	}
+	return out, nil
+}
+
+// networkData is the OpenStack network_data.json document.
+type networkData struct {
+	Links    []map[string]interface{} `json:"links"`
+	Networks []map[string]interface{} `json:"networks"`
+	Services []map[string]interface{} `json:"services"`
+}
+
+func (nd *networkData) addLink(name, mac string, mtu interface{}) {
+	link := map[string]interface{}{"id": name, "name": name, "type": "phy"}
+	if mac != "" {
+		link["ethernet_mac_address"] = strings.ToLower(mac)
+	}
+	if mtu != nil {
+		link["mtu"] = mtu
+	}
+	nd.Links = append(nd.Links, link)
+}
+
+func (nd *networkData) addNetwork(link, typ string, extra map[string]interface{}) {
+	n := map[string]interface{}{"id": fmt.Sprintf("network%d", len(nd.Networks)), "link": link, "type": typ}
+	for k, v := range extra {
+		n[k] = v
+	}
+	nd.Networks = append(nd.Networks, n)
+}
+
+// addStatic adds a static network for address, in CIDR notation or bare
+// with netmask, and the default route through gateway when set.
+func (nd *networkData) addStatic(link, address, netmask, gateway string) error {
+	var prefix netip.Prefix
+	if p, err := netip.ParsePrefix(address); err == nil {
+		prefix = p
+	} else {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid address", address)
+		}
+		bits := addr.BitLen()
+		if netmask != "" {
+			ip := net.ParseIP(netmask)
+			if ip == nil {
+				return fmt.Errorf("%q is not a valid netmask", netmask)
+			}
+			if addr.Is4() {
+				ip = ip.To4()
+			}
+			bits, _ = net.IPMask(ip).Size()
+		}
+		prefix = netip.PrefixFrom(addr, bits)
+	}
+	typ, defaultNet := "ipv4", "0.0.0.0"
+	if prefix.Addr().Is6() {
+		typ, defaultNet = "ipv6", "::"
+	}
+	mask := net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())
+	extra := map[string]interface{}{
+		"ip_address": prefix.Addr().String(),
+		"netmask":    net.IP(mask).String(),
+	}
+	if gateway != "" {
+		gw, err := netip.ParseAddr(gateway)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid gateway", gateway)
+		}
+		if gw.Is6() != prefix.Addr().Is6() {
+			return fmt.Errorf("gateway %s does not match address family of %s", gateway, address)
+		}
+		extra["routes"] = []map[string]string{{"network": defaultNet, "netmask": defaultNet, "gateway": gateway}}
+	}
+	nd.addNetwork(link, typ, extra)
+	return nil
+}
+
+func (nd *networkData) addDNS(servers []interface{}) {
+	for _, s := range servers {
+		nd.Services = append(nd.Services, map[string]interface{}{"type": "dns", "address": fmt.Sprint(s)})
+	}
+}
+
+// configDriveNetworkData converts a NoCloud network-config to
+// network_data.json. Physical interfaces with DHCP or static addresses,
+// default gateways and name servers are supported; bonds, bridges and
+// VLANs are rejected.
+func configDriveNetworkData(data []byte) (*networkData, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if inner, ok := doc["network"].(map[string]interface{}); ok {
+		doc = inner
+	}
+	nd := &networkData{Links: []map[string]interface{}{}, Networks: []map[string]interface{}{}, Services: []map[string]interface{}{}}
+	var err error
+	switch fmt.Sprint(doc["version"]) {
+	case "1":
+		err = nd.fromV1(doc)
+	case "2":
+		err = nd.fromV2(doc)
+	default:
+		err = fmt.Errorf("unsupported network-config version %v", doc["version"])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func (nd *networkData) fromV1(doc map[string]interface{}) error {
+	items, _ := doc["config"].([]interface{})
+	for i, raw := range items {
+		item, _ := raw.(map[string]interface{})
+		switch item["type"] {
+		case "physical":
+		case "nameserver":
+			addrs, _ := item["address"].([]interface{})
+			nd.addDNS(addrs)
+			continue
+		default:
+			return fmt.Errorf("config[%d]: %v interfaces cannot be converted; only physical and nameserver entries are supported", i, item["type"])
+		}
+		name := fmt.Sprint(item["name"])
+		mac, _ := item["mac_address"].(string)
+		nd.addLink(name, mac, item["mtu"])
+		subnets, _ := item["subnets"].([]interface{})
+		for j, rawSubnet := range subnets {
+			subnet, _ := rawSubnet.(map[string]interface{})
+			switch subnet["type"] {
+			case "dhcp", "dhcp4":
+				nd.addNetwork(name, "ipv4_dhcp", nil)
+			case "dhcp6":
+				nd.addNetwork(name, "ipv6_dhcp", nil)
+			case "static", "static6":
+				addr, _ := subnet["address"].(string)
+				netmask, _ := subnet["netmask"].(string)
+				gateway, _ := subnet["gateway"].(string)
+				if err := nd.addStatic(name, addr, netmask, gateway); err != nil {
+					return fmt.Errorf("config[%d].subnets[%d]: %w", i, j, err)
+				}
+				dns, _ := subnet["dns_nameservers"].([]interface{})
+				nd.addDNS(dns)
+			default:
+				return fmt.Errorf("config[%d].subnets[%d]: unsupported subnet type %v", i, j, subnet["type"])
+			}
+		}
+	}
+	return nil
+}
+
+func (nd *networkData) fromV2(doc map[string]interface{}) error {
+	for _, section := range []string{"bonds", "bridges", "vlans", "wifis"} {
+		if _, ok := doc[section]; ok {
+			return fmt.Errorf("%s cannot be converted; only ethernets are supported", section)
+		}
+	}
+	ethernets, _ := doc["ethernets"].(map[string]interface{})
+	names := make([]string, 0, len(ethernets))
+	for name := range ethernets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, id := range names {
+		dev, _ := ethernets[id].(map[string]interface{})
+		name := id
+		if setName, ok := dev["set-name"].(string); ok {
+			name = setName
+		}
+		var mac string
+		if match, ok := dev["match"].(map[string]interface{}); ok {
+			mac, _ = match["macaddress"].(string)
+		}
+		nd.addLink(name, mac, dev["mtu"])
+		if dev["dhcp4"] == true {
+			nd.addNetwork(name, "ipv4_dhcp", nil)
+		}
+		if dev["dhcp6"] == true {
+			nd.addNetwork(name, "ipv6_dhcp", nil)
+		}
+		gateway4, _ := dev["gateway4"].(string)
+		gateway6, _ := dev["gateway6"].(string)
+		if routes, ok := dev["routes"].([]interface{}); ok {
+			for _, raw := range routes {
+				route, _ := raw.(map[string]interface{})
+				via, _ := route["via"].(string)
+				switch route["to"] {
+				case "default", "0.0.0.0/0":
+					gateway4 = via
+				case "::/0":
+					gateway6 = via
+				default:
+					return fmt.Errorf("ethernets.%s.routes: only default routes can be converted", id)
+				}
+			}
+		}
+		addrs, _ := dev["addresses"].([]interface{})
+		for i, raw := range addrs {
+			addr := fmt.Sprint(raw)
+			gateway := gateway4
+			if strings.Contains(addr, ":") {
+				gateway, gateway6 = gateway6, ""
+			} else {
+				gateway4 = ""
+			}
+			if err := nd.addStatic(name, addr, "", gateway); err != nil {
+				return fmt.Errorf("ethernets.%s.addresses[%d]: %w", id, i, err)
+			}
+		}
+		if ns, ok := dev["nameservers"].(map[string]interface{}); ok {
+			dns, _ := ns["addresses"].([]interface{})
+			nd.addDNS(dns)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// containerEngines lists the host container CLIs tried by containerBackend, in order.
+var containerEngines = []string{"podman", "docker"}
+
+// containerBackend runs genisoimage in a Debian container through a host-native
+// podman or docker installation. It is only offered on Linux, where no
+// podman machine is required.
+type containerBackend struct{}
+
+func (containerBackend) Name() string { return BackendContainer }
+
+func (containerBackend) Available() error {
+	_, err := findContainerEngine()
+	return err
+}
+
+func (containerBackend) Reproducible() bool { return false }
+
+func (containerBackend) Build(req *buildRequest) error {
+	engine, err := findContainerEngine()
+	if err != nil {
+		return err
+	}
+	opts := sysutil.RunOptions{
+		Timeout: req.cfg.Build.PullTimeout,
+		Dir:     req.baseDir,
+		Logger:  req.logger,
+		Stdout:  req.logOut,
+		Stderr:  req.logOut,
+	}
+	// podman and docker both support image inspect, unlike image exists.
+	if _, err := sysutil.RunCommand(opts, engine, "image", "inspect", req.cfg.Build.ContainerImage); err == nil {
+		output.Println("[*] Debian image already present, skipping pull.")
+	} else {
+		output.Printf("[*] Pulling Debian image with host %s...\n", filepath.Base(engine))
+		if _, err := sysutil.RunCommand(opts, engine, "pull", req.cfg.Build.ContainerImage); err != nil {
+			return fmt.Errorf("%s pull: %w", filepath.Base(engine), err)
+		}
+	}
+
+	output.Printf("[*] Building %s with genisoimage...\n", filepath.Base(req.isoPath))
+	if err := os.Remove(req.isoPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	isoOut, publish, err := containerOutput(req)
+	if err != nil {
+		return err
+	}
+	toWork := containerPath(req.baseDir)
+	isoInContainer, err := toWork(isoOut)
+	if err != nil {
+		publish(false)
+		return err
+	}
+	args, err := mkisofsArgs(req, isoInContainer, toWork)
+	if err != nil {
+		publish(false)
+		return err
+	}
+	runArgs := []string{
+		"run",
+		"--rm",
+		"-v", filepath.Clean(req.baseDir) + ":/work:Z",
+		"-w", "/work",
+		req.cfg.Build.ContainerImage,
+		"bash",
+		"-c",
+		genisoimageScript(args),
+	}
+	if _, err := sysutil.RunCommand(sysutil.RunOptions{
+		Timeout: req.cfg.Build.RunTimeout,
+		Dir:     req.baseDir,
+		Logger:  req.logger,
+		Stdout:  req.logOut,
+		Stderr:  req.logOut,
+	}, engine, runArgs...); err != nil {
+		publish(false)
+		return fmt.Errorf("%s run: %w", filepath.Base(engine), err)
+	}
+	return publish(true)
+}
+
+func findContainerEngine() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", errors.New("host container engines are only used on Linux")
+	}
+	for _, name := range containerEngines {
+		if p, err := exec.LookPath(name); err == nil {
+			return p, nil
+		}
+	}
+	return "", errors.New("neither podman nor docker found on PATH")
+}
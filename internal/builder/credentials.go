@@ -0,0 +1,293 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/crypt"
+	"velocloud-cloudinit-builder/internal/redact"
+	"velocloud-cloudinit-builder/internal/validate"
+)
+
+const passwordEnvVar = "CLOUDINIT_BUILDER_PASSWORD"
+
+// sshKeyTypes are the key algorithms accepted in authorized_keys lines.
+var sshKeyTypes = []string{"ssh-rsa", "ssh-dss", "ssh-ed25519", "ecdsa-sha2-", "sk-ssh-ed25519@openssh.com", "sk-ecdsa-sha2-"}
+
+// credentials are merged into the rendered user-data.
+type credentials struct {
+	// user receives the password through chpasswd.users; empty sets the
+	// top-level password of cloud-init's default user.
+	user         string
+	passwordHash string
+	sshKeys      []string
+}
+
+func (c credentials) empty() bool {
+	return c.passwordHash == "" && len(c.sshKeys) == 0
+}
+
+// loadCredentials resolves the password from opts, the password file or
+// CLOUDINIT_BUILDER_PASSWORD, hashes it, and reads the SSH public keys.
+func loadCredentials(opts Options) (credentials, error) {
+	c := credentials{user: strings.TrimSpace(opts.PasswordUser)}
+	password := opts.Password
+	if password == "" && opts.PasswordFile != "" {
+		data, err := os.ReadFile(opts.PasswordFile)
+		if err != nil {
+			return c, fmt.Errorf("read password file: %w", err)
+		}
+		password, _, _ = strings.Cut(string(data), "\n")
+		password = strings.TrimRight(password, "\r")
+		if password == "" {
+			return c, fmt.Errorf("password file %s is empty", opts.PasswordFile)
+		}
+	}
+	if password == "" {
+		password = os.Getenv(passwordEnvVar)
+	}
+	if password != "" {
+		if validate.IsPasswordHash(password) {
+			c.passwordHash = password
+		} else {
+			redact.Add(password)
+			hash, err := crypt.SHA512(password)
+			if err != nil {
+				return c, err
+			}
+			c.passwordHash = hash
+		}
+	}
+	if c.user != "" && c.passwordHash == "" {
+		return c, errors.New("a password user was given without a password")
+	}
+
+	seen := map[string]bool{}
+	for _, path := range opts.SSHKeyFiles {
+		keys, err := readPublicKeys(path)
+		if err != nil {
+			return c, err
+		}
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				c.sshKeys = append(c.sshKeys, k)
+			}
+		}
+	}
+	return c, nil
+}
+
+// readPublicKeys returns the authorized_keys style lines in path.
+func readPublicKeys(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ssh key: %w", err)
+	}
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		return nil, fmt.Errorf("%s is a private key; pass the .pub file instead", path)
+	}
+	var keys []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isPublicKey(line) {
+			return nil, fmt.Errorf("%s:%d: not an SSH public key", path, n)
+		}
+		keys = append(keys, line)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s contains no SSH public keys", path)
+	}
+	return keys, nil
+}
+
+func isPublicKey(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	for _, t := range sshKeyTypes {
+		if strings.HasPrefix(fields[0], t) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCredentials merges the password hash and SSH keys into the rendered
+// user-data. A plaintext password already in the template is replaced.
+func applyCredentials(c credentials, seeds []renderedSeed) error {
+	if c.empty() {
+		return nil
+	}
+	for i := range seeds {
+		if seeds[i].name != "user-data" {
+			continue
+		}
+		data, err := mergeCredentials(seeds[i].data, c)
+		if err != nil {
+			return fmt.Errorf("inject credentials into %s: %w", seeds[i].template, err)
+		}
+		seeds[i].data = data
+		return nil
+	}
+	return errors.New("inject credentials: no user-data template")
+}
+
+func mergeCredentials(data []byte, c credentials) ([]byte, error) {
+	if !strings.HasPrefix(strings.TrimSpace(string(data)), "#cloud-config") {
+		return nil, errors.New("user-data must be a #cloud-config document")
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("user-data is not a YAML mapping")
+	}
+
+	if c.passwordHash != "" {
+		if c.user == "" {
+			setKey(root, "password", scalar(c.passwordHash))
+		} else {
+			chpasswd := mappingKey(root, "chpasswd")
+			if chpasswd == nil || chpasswd.Kind != yaml.MappingNode {
+				chpasswd = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				setKey(root, "chpasswd", chpasswd)
+			}
+			chpasswd.Style = 0
+			users := mappingKey(chpasswd, "users")
+			if users == nil || users.Kind != yaml.SequenceNode {
+				users = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+				setKey(chpasswd, "users", users)
+			}
+			users.Style = 0
+			kept := users.Content[:0]
+			for _, u := range users.Content {
+				if name := mappingKey(u, "name"); name == nil || name.Value != c.user {
+					kept = append(kept, u)
+				}
+			}
+			users.Content = append(kept, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+				scalar("name"), scalar(c.user),
+				scalar("password"), scalar(c.passwordHash),
+				scalar("type"), scalar("hash"),
+			}})
+			// The deprecated chpasswd.list may still name the user from an
+			// older template; drop that line so it cannot override the hash.
+			if list := mappingKey(chpasswd, "list"); list != nil && list.Kind == yaml.ScalarNode {
+				var lines []string
+				for _, line := range strings.Split(list.Value, "\n") {
+					if u, _, _ := strings.Cut(line, ":"); line != "" && u != c.user {
+						lines = append(lines, line)
+					}
+				}
+				if len(lines) == 0 {
+					deleteKey(chpasswd, "list")
+				} else {
+					list.Value = strings.Join(lines, "\n") + "\n"
+				}
+			}
+			// cloud-init ignores the top-level password once chpasswd.users is
+			// set, so a plaintext one would only leak into the image.
+			if pw := mappingKey(root, "password"); pw != nil && !validate.IsPasswordHash(pw.Value) {
+				deleteKey(root, "password")
+			}
+		}
+		// The password is chosen at build time, so it is not expired at
+		// first login unless the template asks for that.
+		chpasswd := mappingKey(root, "chpasswd")
+		if chpasswd == nil {
+			chpasswd = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			setKey(root, "chpasswd", chpasswd)
+		}
+		if chpasswd.Kind == yaml.MappingNode && mappingKey(chpasswd, "expire") == nil {
+			setKey(chpasswd, "expire", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"})
+		}
+		// The default template leaves ssh_pwauth unset, so password logins
+		// over SSH are only enabled once there is a password.
+		if mappingKey(root, "ssh_pwauth") == nil {
+			setKey(root, "ssh_pwauth", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+		}
+	}
+
+	if len(c.sshKeys) > 0 {
+		keys := mappingKey(root, "ssh_authorized_keys")
+		if keys == nil || keys.Kind != yaml.SequenceNode {
+			keys = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			setKey(root, "ssh_authorized_keys", keys)
+		}
+		keys.Style = 0
+		present := map[string]bool{}
+		for _, k := range keys.Content {
+			present[k.Value] = true
+		}
+		for _, k := range c.sshKeys {
+			if !present[k] {
+				keys.Content = append(keys.Content, scalar(k))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	out := buf.Bytes()
+	if !bytes.HasPrefix(out, []byte("#cloud-config")) {
+		out = append([]byte("#cloud-config\n"), out...)
+	}
+	return out, nil
+}
+
+func scalar(v string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+}
+
+func mappingKey(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setKey(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, scalar(key), value)
+}
+
+func deleteKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeCredentials(t *testing.T) {
+	const hash = "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"
+	const key = "ssh-ed25519 AAAAC3Nza user@host"
+	tests := []struct {
+		name    string
+		in      string
+		c       credentials
+		want    string
+		wantErr string
+	}{
+		{
+			name: "default user password enables ssh_pwauth",
+			in:   "#cloud-config\nhostname: vce\npassword: Velocloud123\n",
+			c:    credentials{passwordHash: hash},
+			want: "#cloud-config\nhostname: vce\npassword: " + hash + "\nchpasswd:\n  expire: false\nssh_pwauth: true\n",
+		},
+		{
+			name: "explicit ssh_pwauth is kept",
+			in:   "#cloud-config\nssh_pwauth: false\n",
+			c:    credentials{passwordHash: hash},
+			want: "#cloud-config\nssh_pwauth: false\npassword: " + hash + "\nchpasswd:\n  expire: false\n",
+		},
+		{
+			name: "password user goes to chpasswd.users",
+			in:   "#cloud-config\nchpasswd: {expire: False}\npassword: plain\n",
+			c:    credentials{user: "admin", passwordHash: hash},
+			want: "#cloud-config\nchpasswd:\n  expire: False\n  users:\n    - name: admin\n      password: " + hash + "\n      type: hash\nssh_pwauth: true\n",
+		},
+		{
+			name: "existing entries for the user are replaced",
+			in:   "#cloud-config\nchpasswd:\n  users:\n    - {name: ops, type: RANDOM}\n    - {name: admin, password: old}\n  list: |\n    admin:old\n    root:RANDOM\n",
+			c:    credentials{user: "admin", passwordHash: hash},
+			want: "#cloud-config\nchpasswd:\n  users:\n    - {name: ops, type: RANDOM}\n    - name: admin\n      password: " + hash + "\n      type: hash\n  list: |\n    root:RANDOM\n  expire: false\nssh_pwauth: true\n",
+		},
+		{
+			name: "a list naming only the user is dropped",
+			in:   "#cloud-config\nchpasswd:\n  list: |\n    admin:old\n",
+			c:    credentials{user: "admin", passwordHash: hash},
+			want: "#cloud-config\nchpasswd:\n  users:\n    - name: admin\n      password: " + hash + "\n      type: hash\n  expire: false\nssh_pwauth: true\n",
+		},
+		{
+			name: "an explicit expire is kept",
+			in:   "#cloud-config\nchpasswd:\n  expire: true\n",
+			c:    credentials{passwordHash: hash},
+			want: "#cloud-config\nchpasswd:\n  expire: true\npassword: " + hash + "\nssh_pwauth: true\n",
+		},
+		{
+			name: "ssh keys are appended once",
+			in:   "#cloud-config\nssh_authorized_keys: [" + key + "]\n",
+			c:    credentials{sshKeys: []string{key, "ssh-rsa AAAAB3 other"}},
+			want: "#cloud-config\nssh_authorized_keys:\n  - " + key + "\n  - ssh-rsa AAAAB3 other\n",
+		},
+		{
+			name: "empty document",
+			in:   "#cloud-config\n",
+			c:    credentials{sshKeys: []string{key}},
+			want: "#cloud-config\nssh_authorized_keys:\n  - " + key + "\n",
+		},
+		{
+			name:    "not cloud-config",
+			in:      "#!/bin/sh\n",
+			c:       credentials{passwordHash: hash},
+			wantErr: "must be a #cloud-config document",
+		},
+		{
+			name:    "not a mapping",
+			in:      "#cloud-config\n- a\n",
+			c:       credentials{passwordHash: hash},
+			wantErr: "not a YAML mapping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeCredentials([]byte(tt.in), tt.c)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeCredentials() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
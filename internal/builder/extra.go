@@ -0,0 +1,177 @@
+package builder
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+const (
+	// extraDir mirrors its contents into the root of the ISO.
+	extraDir = "extra"
+	// extraStageDir receives copies of the extra files below the stage
+	// directory, so container backends can reach sources outside baseDir.
+	extraStageDir = "extra"
+	// extraSizeWarning is the total size of extra files above which a
+	// warning is printed; cloud-init reads the whole seed disk at boot.
+	extraSizeWarning = 32 << 20
+)
+
+// extraFile is a file grafted into the ISO next to the seed files.
+type extraFile struct {
+	// dest is the slash separated path inside the ISO.
+	dest   string
+	source string
+	size   int64
+}
+
+// collectExtras lists the files below templates/extra and those given as
+// src=dest pairs in adds. A directory source adds every file below it.
+func collectExtras(templateDir string, adds []string) ([]extraFile, error) {
+	var extras []extraFile
+	root := filepath.Join(templateDir, extraDir)
+	if exists, err := fsutil.PathExists(root); err != nil {
+		return nil, err
+	} else if exists {
+		files, err := walkExtra(root, "")
+		if err != nil {
+			return nil, err
+		}
+		extras = append(extras, files...)
+	}
+	for _, add := range adds {
+		src, dest, ok := strings.Cut(add, "=")
+		if !ok || src == "" {
+			return nil, fmt.Errorf("invalid --add %q, expected src=dest", add)
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, fmt.Errorf("--add %s: %w", src, err)
+		}
+		if info.IsDir() {
+			files, err := walkExtra(src, dest)
+			if err != nil {
+				return nil, err
+			}
+			extras = append(extras, files...)
+			continue
+		}
+		if dest == "" || strings.HasSuffix(dest, "/") {
+			dest += filepath.Base(src)
+		}
+		extras = append(extras, extraFile{dest: dest, source: src, size: info.Size()})
+	}
+
+	reserved := map[string]bool{}
+	for _, seed := range seedFiles {
+		reserved[seed.name] = true
+	}
+	seen := map[string]string{}
+	for i := range extras {
+		dest, err := cleanExtraDest(extras[i].dest)
+		if err != nil {
+			return nil, fmt.Errorf("extra file %s: %w", extras[i].source, err)
+		}
+		if first, _, _ := strings.Cut(dest, "/"); reserved[first] {
+			return nil, fmt.Errorf("extra file %s: %s would replace the %s seed", extras[i].source, dest, first)
+		}
+		if other, ok := seen[dest]; ok {
+			return nil, fmt.Errorf("extra file %s is added twice (%s and %s)", dest, other, extras[i].source)
+		}
+		seen[dest] = extras[i].source
+		extras[i].dest = dest
+	}
+	sort.SliceStable(extras, func(i, j int) bool { return extras[i].dest < extras[j].dest })
+	return extras, nil
+}
+
+// walkExtra returns every regular file below root, placed under prefix.
+func walkExtra(root, prefix string) ([]extraFile, error) {
+	var files []extraFile
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("extra file %s is not a regular file", p)
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, extraFile{dest: path.Join(prefix, filepath.ToSlash(rel)), source: p, size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read extra files: %w", err)
+	}
+	return files, nil
+}
+
+// cleanExtraDest normalises dest to a relative slash path and rejects paths
+// that would leave the ISO root.
+func cleanExtraDest(dest string) (string, error) {
+	dest = strings.TrimLeft(filepath.ToSlash(dest), "/")
+	if dest == "" {
+		return "", fmt.Errorf("empty destination path")
+	}
+	const root = "/iso"
+	joined, err := fsutil.SafeJoin(root, filepath.FromSlash(dest))
+	if err != nil {
+		return "", fmt.Errorf("destination %q: %w", dest, err)
+	}
+	rel, err := filepath.Rel(root, joined)
+	if err != nil || rel == "." {
+		return "", fmt.Errorf("destination %q is not a file path", dest)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// warnExtraSize logs the extra files and warns when they are large.
+func warnExtraSize(extras []extraFile, logger sysutil.Logger) {
+	var total int64
+	for _, e := range extras {
+		logger.Printf("extra file %s from %s (%d bytes)", e.dest, e.source, e.size)
+		total += e.size
+	}
+	if total > extraSizeWarning {
+		output.Printf("[!] Extra files add %.1f MiB to the seed ISO; large seed disks slow down boot\n", float64(total)/(1<<20))
+		logger.Printf("warning: extra files total %d bytes (limit %d)", total, extraSizeWarning)
+	}
+}
+
+// stageExtras copies the extra files below stageDir and returns their ISO
+// entries.
+func stageExtras(extras []extraFile, stageDir string) ([]isoEntry, error) {
+	var entries []isoEntry
+	root := filepath.Join(stageDir, extraStageDir)
+	for _, e := range extras {
+		staged, err := fsutil.SafeJoin(root, filepath.FromSlash(e.dest))
+		if err != nil {
+			return nil, fmt.Errorf("stage %s: %w", e.dest, err)
+		}
+		if err := fsutil.CopyFile(e.source, staged); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", e.dest, err)
+		}
+		entries = append(entries, isoEntry{name: e.dest, source: staged})
+	}
+	if len(extras) > 0 {
+		output.Printf("[*] Including %d extra file(s)\n", len(extras))
+	}
+	return entries, nil
+}
@@ -0,0 +1,116 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/fat"
+	"velocloud-cloudinit-builder/internal/output"
+)
+
+// Seed image formats accepted by Options.Format.
+const (
+	// FormatISO is a NoCloud ISO9660 image labelled cidata.
+	FormatISO = "iso"
+	// FormatVFAT is a NoCloud FAT disk image labelled cidata.
+	FormatVFAT = "vfat"
+	// FormatConfigDrive is an OpenStack config drive ISO labelled config-2.
+	FormatConfigDrive = "configdrive"
+	// FormatGuestinfo is a .vmx snippet setting the VMware guestinfo
+	// variables.
+	FormatGuestinfo = "guestinfo"
+	// FormatOVF is an ISO holding an OVF environment document.
+	FormatOVF = "ovf"
+)
+
+const configDriveVolumeID = "config-2"
+
+// FormatNames returns every selectable format name.
+func FormatNames() []string {
+	return []string{FormatISO, FormatVFAT, FormatConfigDrive, FormatGuestinfo, FormatOVF}
+}
+
+// checkFormat normalises format and rejects combinations the backends
+// cannot produce.
+func checkFormat(format, backendName string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "":
+		return FormatISO, nil
+	case FormatISO, FormatConfigDrive, FormatOVF:
+		return format, nil
+	case FormatVFAT, FormatGuestinfo:
+		if backendName != BackendAuto && backendName != BackendNative {
+			return "", fmt.Errorf("the %s backend only builds ISO images; %s output is always written natively", backendName, format)
+		}
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %q (expected one of %s)", format, strings.Join(FormatNames(), ", "))
+	}
+}
+
+// imageFileName returns the file name of the image produced for format.
+func imageFileName(format string) string {
+	switch format {
+	case FormatVFAT:
+		return "cloud-init.img"
+	case FormatConfigDrive:
+		return "config-drive.iso"
+	case FormatGuestinfo:
+		return "cloud-init.vmx"
+	case FormatOVF:
+		return "ovf-env.iso"
+	default:
+		return "cloud-init.iso"
+	}
+}
+
+// imageVolumeID returns the volume label cloud-init looks for in format.
+func imageVolumeID(format string) string {
+	switch format {
+	case FormatConfigDrive:
+		return configDriveVolumeID
+	case FormatOVF:
+		return ovfEnvVolumeID
+	default:
+		return volumeID
+	}
+}
+
+// FormatForVolumeID returns the ISO format cloud-init recognises by label,
+// falling back to FormatISO.
+func FormatForVolumeID(label string) string {
+	for _, format := range []string{FormatConfigDrive, FormatOVF} {
+		if strings.EqualFold(label, imageVolumeID(format)) {
+			return format
+		}
+	}
+	return FormatISO
+}
+
+// writeVFAT writes req as a FAT disk image with the built-in writer.
+func writeVFAT(req *buildRequest) error {
+	output.Printf("[*] Building %s with the built-in FAT writer...\n", filepath.Base(req.isoPath))
+	req.logger.Printf("using built-in FAT writer")
+	img, err := fat.New(fat.Options{Label: req.volumeID, Created: req.timestamp})
+	if err != nil {
+		return err
+	}
+	for _, f := range req.files {
+		// A zero ModTime makes the writer fall back to the source file's mtime.
+		entry := fat.File{Path: f.name, Source: f.source}
+		if req.reproducible {
+			entry.ModTime = req.timestamp
+		}
+		if err := img.AddFile(entry); err != nil {
+			return fmt.Errorf("add %s: %w", f.name, err)
+		}
+		req.logger.Printf("added %s from %s", f.name, f.source)
+	}
+	if err := img.WriteFile(req.isoPath); err != nil {
+		return fmt.Errorf("write vfat image: %w", err)
+	}
+	req.logger.Printf("wrote %s", req.isoPath)
+	return nil
+}
@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// hostISOTools lists the ISO authoring tools looked up on PATH, in order of preference.
+var hostISOTools = []string{"genisoimage", "xorriso", "mkisofs"}
+
+// hostToolBackend runs an ISO authoring tool installed on the host.
+type hostToolBackend struct{}
+
+func (hostToolBackend) Name() string { return BackendHostTool }
+
+func (hostToolBackend) Available() error {
+	_, _, err := findHostISOTool()
+	return err
+}
+
+func (hostToolBackend) Reproducible() bool { return false }
+
+func (hostToolBackend) Build(req *buildRequest) error {
+	name, toolPath, err := findHostISOTool()
+	if err != nil {
+		return err
+	}
+	output.Printf("[*] Building %s with %s...\n", filepath.Base(req.isoPath), name)
+	args, err := mkisofsArgs(req, req.isoPath, func(p string) (string, error) { return p, nil })
+	if err != nil {
+		return err
+	}
+	if name == "xorriso" {
+		args = append([]string{"-as", "mkisofs"}, args...)
+	}
+	if _, err := sysutil.RunCommand(sysutil.RunOptions{
+		Timeout: req.cfg.Build.HostToolTimeout,
+		Dir:     req.baseDir,
+		Logger:  req.logger,
+		Stdout:  req.logOut,
+		Stderr:  req.logOut,
+	}, toolPath, args...); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func findHostISOTool() (string, string, error) {
+	for _, name := range hostISOTools {
+		if p, err := exec.LookPath(name); err == nil {
+			abs, absErr := filepath.Abs(p)
+			if absErr != nil {
+				abs = p
+			}
+			return name, abs, nil
+		}
+	}
+	return "", "", errors.New("none of " + strings.Join(hostISOTools, ", ") + " found on PATH")
+}
@@ -0,0 +1,269 @@
+package builder
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/render"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+const (
+	// inventoryNameKey is the column or key naming each edge. It is also
+	// available to templates as {{ .name }}.
+	inventoryNameKey = "name"
+)
+
+var edgeNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// edge is one inventory row.
+type edge struct {
+	name string
+	vars render.Vars
+}
+
+// edgeResult records the outcome of building one edge.
+type edgeResult struct {
+	name    string
+	isoPath string
+	sum     string
+	err     error
+}
+
+// buildInventory builds one ISO per inventory row under outDir/<name>/,
+// running up to jobs builds at a time, and prints a summary table.
+func (s *session) buildInventory(path string, jobs int, outDir string) error {
+	edges, err := loadInventory(path)
+	if err != nil {
+		return fmt.Errorf("load inventory: %w", err)
+	}
+	if err := s.checkEdges(edges); err != nil {
+		return err
+	}
+	if jobs <= 0 {
+		jobs = s.cfg.Build.Jobs
+	}
+	if s.backendName == BackendPodmanMachine && jobs > 1 {
+		// The managed podman machine is started and stopped by every build.
+		s.logger.Printf("%s backend does not support concurrent builds, using 1 job", s.backendName)
+		jobs = 1
+	}
+	if jobs > len(edges) {
+		jobs = len(edges)
+	}
+	s.logger.Printf("building %d edge(s) from %s with %d job(s)", len(edges), path, jobs)
+	output.Printf("[*] Building %d ISO(s) from %s with %d job(s)...\n", len(edges), pathRelative(s.baseDir, path), jobs)
+
+	// Per-edge progress only goes to the log; the summary replaces it on the console.
+	wasQuiet := output.Quiet()
+	output.SetQuiet(true)
+	results := make([]edgeResult, len(edges))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = s.buildEdge(edges[i], outDir)
+			}
+		}()
+	}
+	for i := range edges {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	output.SetQuiet(wasQuiet)
+
+	failed := printSummary(s.baseDir, results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d inventory builds failed", failed, len(results))
+	}
+	output.Printf("[+] Done: %d ISO(s) created.\n", len(results))
+	return nil
+}
+
+// edgeVars returns the shared variables overlaid with the row of e.
+func (s *session) edgeVars(e edge) render.Vars {
+	vars := s.vars.Clone()
+	vars.Merge(e.vars)
+	vars[inventoryNameKey] = e.name
+	return vars
+}
+
+// checkEdges validates the profile parameters of every row up front, so a
+// missing activation code fails the run before any ISO is built.
+func (s *session) checkEdges(edges []edge) error {
+	if s.profile != ProfileVeloCloud {
+		return nil
+	}
+	var errs []error
+	for _, e := range edges {
+		if _, err := profileActivation(s.edgeVars(e), s.secrets); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s profile:\n%w", s.profile, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (s *session) buildEdge(e edge, outDir string) edgeResult {
+	logger := prefixLogger{logger: s.logger, prefix: "[" + e.name + "] "}
+	vars := s.edgeVars(e)
+
+	isoPath := filepath.Join(outDir, e.name, imageFileName(s.format))
+	stageDir := filepath.Join(s.baseDir, "runtime", "render", "inventory", e.name)
+	sum, err := s.buildImage(isoPath, stageDir, vars, logger)
+	if err != nil {
+		logger.Printf("build failed: %v", err)
+	}
+	return edgeResult{name: e.name, isoPath: isoPath, sum: sum, err: err}
+}
+
+// printSummary writes one line per edge and returns the number of failures.
+func printSummary(baseDir string, results []edgeResult) int {
+	failed := 0
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tISO / ERROR")
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(tw, "%s\tFAILED\t%v\n", r.name, r.err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tok\t%s\n", r.name, filepath.ToSlash(pathRelative(baseDir, r.isoPath)))
+	}
+	tw.Flush()
+	output.Println("")
+	output.Printf("%s\n", b.String())
+	output.Printf("[*] %d succeeded, %d failed\n", len(results)-failed, failed)
+	return failed
+}
+
+// loadInventory reads edges from a CSV file with a header row or from a YAML
+// list of mappings. Every row needs a unique "name".
+func loadInventory(path string) ([]edge, error) {
+	var (
+		edges []edge
+		err   error
+	)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		edges, err = loadInventoryCSV(path)
+	case ".yaml", ".yml", ".json":
+		edges, err = loadInventoryYAML(path)
+	default:
+		return nil, fmt.Errorf("unsupported inventory format %q (expected .csv, .yaml or .yml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(edges) == 0 {
+		return nil, fmt.Errorf("%s lists no edges", path)
+	}
+	seen := map[string]bool{}
+	for i, e := range edges {
+		if e.name == "" {
+			return nil, fmt.Errorf("row %d: missing %q", i+1, inventoryNameKey)
+		}
+		if !edgeNamePattern.MatchString(e.name) {
+			return nil, fmt.Errorf("row %d: name %q may only contain letters, digits, '.', '_' and '-'", i+1, e.name)
+		}
+		if seen[e.name] {
+			return nil, fmt.Errorf("row %d: duplicate name %q", i+1, e.name)
+		}
+		seen[e.name] = true
+	}
+	return edges, nil
+}
+
+func loadInventoryCSV(path string) ([]edge, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	header, err := r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	var edges []edge
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return edges, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		vars := render.Vars{}
+		for i, column := range header {
+			if column == "" {
+				continue
+			}
+			if err := vars.Set(column + "=" + record[i]); err != nil {
+				return nil, err
+			}
+		}
+		edges = append(edges, edge{name: edgeName(vars), vars: vars})
+	}
+}
+
+func loadInventoryYAML(path string) ([]edge, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rows []render.Vars
+	if err := yaml.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("parse %s: expected a list of mappings: %w", path, err)
+	}
+	edges := make([]edge, 0, len(rows))
+	for _, row := range rows {
+		edges = append(edges, edge{name: edgeName(row), vars: row})
+	}
+	return edges, nil
+}
+
+func edgeName(vars render.Vars) string {
+	v, ok := vars[inventoryNameKey]
+	if !ok || v == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(v))
+}
+
+// prefixLogger tags every line with the edge it belongs to, so concurrent
+// builds stay readable in the shared log.
+type prefixLogger struct {
+	logger sysutil.Logger
+	prefix string
+}
+
+func (l prefixLogger) Printf(format string, v ...interface{}) {
+	l.logger.Printf(l.prefix+format, v...)
+}
@@ -0,0 +1,103 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"velocloud-cloudinit-builder/internal/render"
+)
+
+func writeInventory(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []edge
+		wantErr string
+	}{
+		{
+			name:    "csv",
+			file:    "edges.csv",
+			content: "\ufeffname, wan.address ,site\n# comment\nedge1, 192.0.2.1,paris\nedge2,192.0.2.2,\n",
+			want: []edge{
+				{name: "edge1", vars: render.Vars{"name": "edge1", "wan": map[string]interface{}{"address": "192.0.2.1"}, "site": "paris"}},
+				{name: "edge2", vars: render.Vars{"name": "edge2", "wan": map[string]interface{}{"address": "192.0.2.2"}, "site": ""}},
+			},
+		},
+		{
+			name:    "csv blank column header is skipped",
+			file:    "edges.csv",
+			content: "name,,site\nedge1,x,paris\n",
+			want:    []edge{{name: "edge1", vars: render.Vars{"name": "edge1", "site": "paris"}}},
+		},
+		{
+			name:    "yaml",
+			file:    "edges.yaml",
+			content: "- name: edge1\n  wan:\n    address: 192.0.2.1\n- name: 42\n",
+			want: []edge{
+				{name: "edge1", vars: render.Vars{"name": "edge1", "wan": render.Vars{"address": "192.0.2.1"}}},
+				{name: "42", vars: render.Vars{"name": 42}},
+			},
+		},
+		{
+			name:    "json",
+			file:    "edges.json",
+			content: `[{"name": "edge1"}]`,
+			want:    []edge{{name: "edge1", vars: render.Vars{"name": "edge1"}}},
+		},
+		{name: "unsupported extension", file: "edges.txt", content: "name\n", wantErr: "unsupported inventory format"},
+		{name: "empty csv", file: "edges.csv", content: "", wantErr: "lists no edges"},
+		{name: "header only", file: "edges.csv", content: "name,site\n", wantErr: "lists no edges"},
+		{name: "ragged csv row", file: "edges.csv", content: "name,site\nedge1\n", wantErr: "wrong number of fields"},
+		{name: "missing name", file: "edges.csv", content: "site\nparis\n", wantErr: `row 1: missing "name"`},
+		{name: "invalid name", file: "edges.yaml", content: "- name: edge1\n- name: ../evil\n", wantErr: `row 2: name "../evil"`},
+		{name: "duplicate name", file: "edges.yml", content: "- name: a\n- name: a\n", wantErr: `row 2: duplicate name "a"`},
+		{name: "yaml mapping", file: "edges.yaml", content: "name: a\n", wantErr: "expected a list of mappings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadInventory(writeInventory(t, tt.file, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadInventory() =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEdgeVars(t *testing.T) {
+	s := &session{vars: render.Vars{"site": "default", "wan": map[string]interface{}{"gateway": "192.0.2.254"}}}
+	e := edge{name: "edge1", vars: render.Vars{"name": "ignored", "wan": map[string]interface{}{"address": "192.0.2.1"}}}
+	got := s.edgeVars(e)
+	want := render.Vars{
+		"name": "edge1",
+		"site": "default",
+		"wan":  map[string]interface{}{"address": "192.0.2.1", "gateway": "192.0.2.254"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("edgeVars() = %#v, want %#v", got, want)
+	}
+	if _, ok := s.vars["wan"].(map[string]interface{})["address"]; ok {
+		t.Error("edge variables leaked into the shared variables")
+	}
+}
@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"velocloud-cloudinit-builder/internal/iso9660"
+	"velocloud-cloudinit-builder/internal/output"
+)
+
+// nativeBackend writes the ISO in-process without any external tooling.
+type nativeBackend struct{}
+
+func (nativeBackend) Name() string { return BackendNative }
+
+func (nativeBackend) Available() error { return nil }
+
+func (nativeBackend) Build(req *buildRequest) error {
+	output.Printf("[*] Building %s with the built-in ISO writer...\n", filepath.Base(req.isoPath))
+	img := iso9660.New(iso9660.Options{
+		VolumeID:  req.volumeID,
+		Joliet:    true,
+		RockRidge: true,
+		Created:   req.timestamp,
+	})
+	for _, f := range req.files {
+		// A zero ModTime makes the writer fall back to the source file's mtime.
+		entry := iso9660.File{Path: f.name, Source: f.source}
+		if req.reproducible {
+			entry.ModTime = req.timestamp
+		}
+		// Keep scripts grafted in as extra files executable.
+		if info, err := os.Stat(f.source); err == nil && info.Mode()&0o111 != 0 {
+			entry.Mode = 0o755
+		}
+		if err := img.AddFile(entry); err != nil {
+			return fmt.Errorf("add %s: %w", f.name, err)
+		}
+		req.logger.Printf("added %s from %s", f.name, f.source)
+	}
+	if err := img.WriteFile(req.isoPath); err != nil {
+		return fmt.Errorf("write iso: %w", err)
+	}
+	req.logger.Printf("wrote %s", req.isoPath)
+	return nil
+}
+
+func (nativeBackend) Reproducible() bool { return true }
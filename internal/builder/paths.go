@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// outEnvVar sets the output path when Options.Out is empty.
+	outEnvVar = "CLOUDINIT_BUILDER_OUT"
+	// templatesEnvVar sets the templates directory when
+	// Options.TemplateDir is empty.
+	templatesEnvVar = "CLOUDINIT_BUILDER_TEMPLATES"
+)
+
+// TemplateDir returns the templates directory for opts, falling back to
+// CLOUDINIT_BUILDER_TEMPLATES, the configured directory and then to
+// templates/ in baseDir. Relative paths are resolved against baseDir.
+func TemplateDir(baseDir string, opts Options) string {
+	dir := opts.TemplateDir
+	if dir == "" {
+		dir = strings.TrimSpace(os.Getenv(templatesEnvVar))
+	}
+	if dir == "" {
+		dir = opts.config().Build.Templates
+	}
+	if dir == "" {
+		return filepath.Join(baseDir, "templates")
+	}
+	return resolvePath(baseDir, dir)
+}
+
+// ImagePath returns the file a single build with opts writes.
+func ImagePath(baseDir string, opts Options) (string, error) {
+	cfg := opts.config()
+	format, err := checkFormat(defaultIfEmpty(opts.Format, cfg.Build.Format), resolveBackendName(opts.Backend, cfg.Build.Backend))
+	if err != nil {
+		return "", err
+	}
+	return imagePath(baseDir, outPath(opts), format), nil
+}
+
+// imagePath resolves out to the image file for format. An empty out writes
+// to images/; an out that ends in a path separator or names an existing
+// directory receives the default file name of format.
+func imagePath(baseDir, out, format string) string {
+	if out == "" {
+		return filepath.Join(baseDir, "images", imageFileName(format))
+	}
+	path := resolvePath(baseDir, out)
+	if strings.HasSuffix(out, "/") || strings.HasSuffix(out, string(filepath.Separator)) {
+		return filepath.Join(path, imageFileName(format))
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return filepath.Join(path, imageFileName(format))
+	}
+	return path
+}
+
+// inventoryDir returns the directory that receives one subdirectory per
+// edge. out, when set, must be a directory.
+func inventoryDir(baseDir, out string) string {
+	if out == "" {
+		return filepath.Join(baseDir, "images")
+	}
+	return resolvePath(baseDir, out)
+}
+
+// outPath returns opts.Out, falling back to CLOUDINIT_BUILDER_OUT and then
+// to the configured output path.
+func outPath(opts Options) string {
+	out := opts.Out
+	if out == "" {
+		out = strings.TrimSpace(os.Getenv(outEnvVar))
+	}
+	if out == "" {
+		out = opts.config().Build.Out
+	}
+	return out
+}
+
+func resolvePath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(baseDir, path)
+}
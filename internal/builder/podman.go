@@ -0,0 +1,125 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/deps"
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// podmanMachineBackend runs genisoimage inside a Debian container on the
+// portable podman machine managed under tools/ and runtime/.
+type podmanMachineBackend struct{}
+
+func (podmanMachineBackend) Name() string { return BackendPodmanMachine }
+
+func (podmanMachineBackend) Available() error {
+	if runtime.GOOS != "windows" {
+		return errors.New("the managed podman machine is only supported on Windows")
+	}
+	return nil
+}
+
+func (podmanMachineBackend) Reproducible() bool { return false }
+
+func (podmanMachineBackend) Build(req *buildRequest) (err error) {
+	baseDir, logOut, logger := req.baseDir, req.logOut, req.logger
+	var podmanPath string
+	var machineName string
+	var podmanEnv []string
+
+	defer func() {
+		if podmanPath == "" || machineName == "" || len(podmanEnv) == 0 {
+			return
+		}
+		if stopErr := deps.StopPodmanMachine(baseDir, podmanPath, req.cfg.Deps, podmanEnv, logOut, logger); stopErr != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to stop podman machine: %v\n", stopErr)
+		} else if err == nil {
+			output.Println("[*] Podman machine stopped.")
+		}
+	}()
+
+	podmanPath, err = deps.EnsurePodman(baseDir, req.cfg.Deps, logger)
+	if err != nil {
+		return fmt.Errorf("ensure podman: %w", err)
+	}
+	output.Println("[*] Podman ready.")
+
+	machineName, podmanEnv, err = deps.EnsurePodmanMachine(baseDir, podmanPath, req.cfg.Deps, logOut, logger)
+	if err != nil {
+		return fmt.Errorf("ensure podman machine: %w", err)
+	}
+
+	if err := runPodman(baseDir, podmanPath, machineName, podmanEnv, []string{"image", "exists", req.cfg.Build.ContainerImage}, logOut, logger, req.cfg.Build.PullTimeout); err == nil {
+		output.Println("[*] Debian image already present, skipping pull.")
+	} else {
+		output.Println("[*] Pulling Debian image...")
+		if err := runPodman(baseDir, podmanPath, machineName, podmanEnv, []string{"pull", req.cfg.Build.ContainerImage}, logOut, logger, req.cfg.Build.PullTimeout); err != nil {
+			return fmt.Errorf("podman pull: %w", err)
+		}
+	}
+
+	output.Printf("[*] Building %s with genisoimage...\n", filepath.Base(req.isoPath))
+	if err := runPodmanRun(req, podmanPath, machineName, podmanEnv); err != nil {
+		return fmt.Errorf("podman run: %w", err)
+	}
+	return nil
+}
+
+func runPodman(baseDir, podmanPath, machineName string, env []string, args []string, logOut io.Writer, logger sysutil.Logger, timeout time.Duration) error {
+	allArgs := append([]string{"--connection", machineName}, args...)
+	_, err := sysutil.RunCommand(sysutil.RunOptions{
+		Timeout: timeout,
+		Dir:     baseDir,
+		Logger:  logger,
+		Stdout:  logOut,
+		Stderr:  logOut,
+		Env:     env,
+	}, podmanPath, allArgs...)
+	return err
+}
+
+func runPodmanRun(req *buildRequest, podmanPath, machineName string, env []string) error {
+	if err := os.Remove(req.isoPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	isoOut, publish, err := containerOutput(req)
+	if err != nil {
+		return err
+	}
+	toWork := containerPath(req.baseDir)
+	isoInContainer, err := toWork(isoOut)
+	if err != nil {
+		publish(false)
+		return err
+	}
+	args, err := mkisofsArgs(req, isoInContainer, toWork)
+	if err != nil {
+		publish(false)
+		return err
+	}
+	mountArg := fmt.Sprintf("%s:/work", filepath.Clean(req.baseDir))
+	podmanArgs := []string{
+		"run",
+		"--rm",
+		"-v", mountArg,
+		"-w", "/work",
+		req.cfg.Build.ContainerImage,
+		"bash",
+		"-c",
+		genisoimageScript(args),
+	}
+	if err := runPodman(req.baseDir, podmanPath, machineName, env, podmanArgs, req.logOut, req.logger, req.cfg.Build.RunTimeout); err != nil {
+		publish(false)
+		return err
+	}
+	return publish(true)
+}
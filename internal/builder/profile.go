@@ -0,0 +1,82 @@
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/redact"
+	"velocloud-cloudinit-builder/internal/render"
+	"velocloud-cloudinit-builder/internal/secrets"
+	"velocloud-cloudinit-builder/internal/velocloud"
+)
+
+// ProfileVeloCloud appends a velocloud: vce: activation block to user-data.
+const ProfileVeloCloud = "velocloud"
+
+func checkProfile(name string) error {
+	switch name {
+	case "", ProfileVeloCloud:
+		return nil
+	default:
+		return fmt.Errorf("unknown profile %q (expected %s)", name, ProfileVeloCloud)
+	}
+}
+
+// profileActivation returns the validated activation parameters for vars.
+// The activation code falls back to the velocloud.activation_code secret.
+// Wherever it comes from (--activation-code, --set, a vars file or an
+// inventory row), the code is registered for redaction like a secret.
+func profileActivation(vars render.Vars, store secrets.Store) (velocloud.Activation, error) {
+	a, err := velocloud.FromVars(vars)
+	if err != nil {
+		return a, err
+	}
+	if a.ActivationCode == "" {
+		a.ActivationCode = store[activationCodeSecret]
+	}
+	redact.Add(a.ActivationCode, strings.ToUpper(a.ActivationCode))
+	if err := a.Validate(); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// applyProfile adds the content generated by profile to the rendered seeds.
+func applyProfile(profile string, vars render.Vars, store secrets.Store, seeds []renderedSeed) error {
+	if profile != ProfileVeloCloud {
+		return nil
+	}
+	a, err := profileActivation(vars, store)
+	if err != nil {
+		return fmt.Errorf("%s profile: %w", profile, err)
+	}
+	block, err := a.Block()
+	if err != nil {
+		return err
+	}
+	for i := range seeds {
+		if seeds[i].name != "user-data" {
+			continue
+		}
+		data := seeds[i].data
+		if !strings.HasPrefix(strings.TrimSpace(string(data)), "#cloud-config") {
+			return fmt.Errorf("%s profile: %s must be a #cloud-config document", profile, seeds[i].template)
+		}
+		var doc map[string]interface{}
+		if yaml.Unmarshal(data, &doc) == nil {
+			if _, ok := doc[velocloud.VarsKey]; ok {
+				return fmt.Errorf("%s profile: %s already defines %s:, remove it or drop the profile", profile, seeds[i].template, velocloud.VarsKey)
+			}
+		}
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			data = append(data, '\n')
+		}
+		seeds[i].data = append(data, block...)
+		return nil
+	}
+	return errors.New(profile + " profile: no user-data template")
+}
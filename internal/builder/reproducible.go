@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/fsutil"
+)
+
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// buildTimestamp returns the timestamp stamped on every ISO entry in a
+// reproducible build. SOURCE_DATE_EPOCH wins when set and implies
+// reproducible mode; otherwise the Unix epoch is used.
+func buildTimestamp(reproducible bool) (time.Time, bool, error) {
+	raw := strings.TrimSpace(os.Getenv(sourceDateEpochEnv))
+	if raw == "" {
+		if !reproducible {
+			return time.Time{}, false, nil
+		}
+		return time.Unix(0, 0).UTC(), true, nil
+	}
+	secs, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || secs < 0 {
+		return time.Time{}, false, fmt.Errorf("invalid %s %q: expected a non-negative integer", sourceDateEpochEnv, raw)
+	}
+	return time.Unix(secs, 0).UTC(), true, nil
+}
+
+// writeChecksum stores the SHA-256 of isoPath next to it in sha256sum format.
+func writeChecksum(isoPath string) (string, error) {
+	sum, err := fsutil.SHA256File(isoPath)
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", isoPath, err)
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(isoPath))
+	if err := os.WriteFile(isoPath+".sha256", []byte(line), 0o644); err != nil {
+		return "", fmt.Errorf("write checksum: %w", err)
+	}
+	return sum, nil
+}
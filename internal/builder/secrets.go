@@ -0,0 +1,29 @@
+package builder
+
+import (
+	"errors"
+	"path/filepath"
+
+	"velocloud-cloudinit-builder/internal/secrets"
+)
+
+const (
+	// passwordSecret is used as the password when no other source sets one.
+	passwordSecret = "password"
+	// activationCodeSecret fills velocloud.activation_code when the
+	// variable is not set.
+	activationCodeSecret = "velocloud.activation_code"
+)
+
+// loadSecrets reads templates/secrets.enc and the secret environment
+// variables. The passphrase comes from CLOUDINIT_BUILDER_SECRETS_KEY or
+// opts.SecretsPassphrase.
+func loadSecrets(templateDir string, opts Options) (secrets.Store, error) {
+	prompt := opts.SecretsPassphrase
+	if prompt == nil {
+		prompt = func() (string, error) {
+			return "", errors.New("templates/" + secrets.FileName + " is encrypted; set " + secrets.KeyEnvVar)
+		}
+	}
+	return secrets.Load(filepath.Join(templateDir, secrets.FileName), prompt)
+}
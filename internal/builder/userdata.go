@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/render"
+	"velocloud-cloudinit-builder/internal/sysutil"
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+// userDataDir holds extra user-data parts: scripts, #include lists,
+// boothooks or further cloud-config documents. They are assembled together
+// with user-data.txt into a MIME multipart user-data.
+const userDataDir = "user-data.d"
+
+// renderParts renders the files in templates/user-data.d in name order.
+// Hidden files and *.example files are skipped, as are parts that render
+// to nothing.
+func renderParts(templateDir string, r *render.Renderer, logger sysutil.Logger) ([]renderedSeed, error) {
+	entries, err := os.ReadDir(filepath.Join(templateDir, userDataDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", userDataDir, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	var parts []renderedSeed
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".example") {
+			continue
+		}
+		template := path.Join(userDataDir, name)
+		data, err := os.ReadFile(filepath.Join(templateDir, userDataDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", template, err)
+		}
+		rendered, err := r.Render(template, data)
+		if err != nil {
+			return nil, fmt.Errorf("render %s: %w", template, err)
+		}
+		if strings.TrimSpace(string(rendered)) == "" {
+			logger.Printf("%s rendered empty, skipping", template)
+			continue
+		}
+		parts = append(parts, renderedSeed{name: "user-data", template: template, data: rendered})
+	}
+	return parts, nil
+}
+
+// assembleUserData replaces the user-data seed with a MIME multipart
+// document of the seed followed by parts, when there are any, and gzips the
+// result when compress is set.
+func assembleUserData(seeds, parts []renderedSeed, compress bool, logger sysutil.Logger) error {
+	for i := range seeds {
+		if seeds[i].name != "user-data" {
+			continue
+		}
+		if len(parts) > 0 {
+			var mimeParts []userdata.Part
+			if strings.TrimSpace(string(seeds[i].data)) != "" {
+				mimeParts = append(mimeParts, userdata.Part{Filename: seeds[i].template, Data: seeds[i].data})
+			}
+			for _, p := range parts {
+				mimeParts = append(mimeParts, userdata.Part{Filename: path.Base(p.template), Data: p.data})
+			}
+			data, err := userdata.Assemble(mimeParts)
+			if err != nil {
+				return fmt.Errorf("assemble user-data: %w", err)
+			}
+			seeds[i].data = data
+			logger.Printf("assembled multipart user-data from %d part(s)", len(mimeParts))
+			output.Printf("[*] Assembled user-data from %d part(s)\n", len(mimeParts))
+		}
+		if compress {
+			data, err := userdata.Gzip(seeds[i].data)
+			if err != nil {
+				return fmt.Errorf("compress user-data: %w", err)
+			}
+			logger.Printf("gzipped user-data: %d -> %d bytes", len(seeds[i].data), len(data))
+			seeds[i].data = data
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/render"
+	"velocloud-cloudinit-builder/internal/validate"
+)
+
+// Validate renders the templates in baseDir with the variables selected by
+// opts and reports every validation issue to w without building an ISO.
+func Validate(baseDir string, opts Options, w io.Writer) error {
+	_, seeds, parts, err := renderTemplates(baseDir, opts)
+	if err != nil {
+		return err
+	}
+	return validate.Report(w, checkSeeds(seeds, parts))
+}
+
+// RenderFiles renders the templates selected by opts as Build does and
+// returns the files an image in opts.Format would hold, keyed by their path
+// inside the image. No image is written. Passwords and SSH keys are not
+// injected, since their hashes are salted, and user-data is not gzipped.
+func RenderFiles(baseDir string, opts Options) (map[string][]byte, error) {
+	format, err := checkFormat(defaultIfEmpty(opts.Format, opts.config().Build.Format), BackendAuto)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatGuestinfo {
+		return nil, fmt.Errorf("%s output is not an image", FormatGuestinfo)
+	}
+	templateDir, seeds, parts, err := renderTemplates(baseDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate.Errors(checkSeeds(seeds, parts)); err != nil {
+		return nil, fmt.Errorf("validation failed:\n%w", err)
+	}
+	logger := log.New(io.Discard, "", 0)
+	if err := assembleUserData(seeds, parts, false, logger); err != nil {
+		return nil, err
+	}
+
+	// The config drive and OVF conversions read the seeds from disk.
+	runtimeDir := filepath.Join(baseDir, "runtime")
+	if err := fsutil.EnsureDir(runtimeDir); err != nil {
+		return nil, err
+	}
+	stageDir, err := os.MkdirTemp(runtimeDir, "render-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stageDir)
+	var entries []isoEntry
+	for _, seed := range seeds {
+		staged := filepath.Join(stageDir, seed.name)
+		if err := os.WriteFile(staged, seed.data, 0o600); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", seed.name, err)
+		}
+		entries = append(entries, isoEntry{name: seed.name, source: staged})
+	}
+	switch format {
+	case FormatConfigDrive:
+		entries, err = configDriveFiles(entries, stageDir)
+	case FormatOVF:
+		entries, err = ovfEnvFiles(entries, stageDir, logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+	extras, err := collectExtras(templateDir, opts.AddFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range extras {
+		entries = append(entries, isoEntry{name: e.dest, source: e.source})
+	}
+
+	files := map[string][]byte{}
+	for _, e := range entries {
+		data, err := os.ReadFile(e.source)
+		if err != nil {
+			return nil, err
+		}
+		files[e.name] = data
+	}
+	return files, nil
+}
+
+// renderTemplates renders the seeds and user-data parts selected by opts
+// and applies the profile.
+func renderTemplates(baseDir string, opts Options) (string, []renderedSeed, []renderedSeed, error) {
+	templateDir := TemplateDir(baseDir, opts)
+	vars, err := loadVars(templateDir, opts)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := checkProfile(opts.Profile); err != nil {
+		return "", nil, nil, err
+	}
+	store, err := loadSecrets(templateDir, opts)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	r := render.New(templateDir, vars).WithSecrets(store)
+	logger := log.New(io.Discard, "", 0)
+	seeds, err := renderSeeds(templateDir, r, logger)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	parts, err := renderParts(templateDir, r, logger)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := applyProfile(opts.Profile, vars, store, seeds); err != nil {
+		return "", nil, nil, err
+	}
+	return templateDir, seeds, parts, nil
+}
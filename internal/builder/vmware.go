@@ -0,0 +1,215 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+const (
+	// ovfEnvFile is the file cloud-init's OVF datasource looks for on
+	// attached CD-ROMs.
+	ovfEnvFile = "ovf-env.xml"
+	// ovfEnvVolumeID matches the label vSphere gives its own OVF
+	// environment ISOs.
+	ovfEnvVolumeID = "OVF ENV"
+	// guestinfoEncoding is used for every guestinfo value that is not
+	// already gzip compressed.
+	guestinfoEncoding = "gzip+base64"
+)
+
+// guestinfoKeys maps the seeds to the guestinfo keys read by cloud-init's
+// VMware datasource. network-config is carried inside the metadata.
+var guestinfoKeys = []struct {
+	seed string
+	key  string
+}{
+	{seed: "meta-data", key: "guestinfo.metadata"},
+	{seed: "user-data", key: "guestinfo.userdata"},
+	{seed: "vendor-data", key: "guestinfo.vendordata"},
+}
+
+// writeGuestinfo writes the staged seeds in req as a .vmx snippet that sets
+// the guestinfo variables read by cloud-init's VMware datasource.
+func writeGuestinfo(req *buildRequest) error {
+	output.Printf("[*] Writing %s...\n", filepath.Base(req.isoPath))
+	seeds := map[string][]byte{}
+	for _, f := range req.files {
+		data, err := os.ReadFile(f.source)
+		if err != nil {
+			return err
+		}
+		seeds[f.name] = data
+	}
+	if network, ok := seeds["network-config"]; ok {
+		metadata, err := guestinfoMetadata(seeds["meta-data"], network)
+		if err != nil {
+			return err
+		}
+		seeds["meta-data"] = metadata
+		req.logger.Printf("embedded network-config in guestinfo.metadata")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# cloud-init guestinfo generated by cloudinit-builder.\n")
+	buf.WriteString("# Append these lines to the VM's .vmx file or set them with govc vm.change -e.\n")
+	for _, k := range guestinfoKeys {
+		data, ok := seeds[k.seed]
+		if !ok {
+			continue
+		}
+		value, encoding, err := guestinfoValue(data)
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", k.seed, err)
+		}
+		fmt.Fprintf(&buf, "%s = %q\n", k.key, value)
+		fmt.Fprintf(&buf, "%s.encoding = %q\n", k.key, encoding)
+		req.logger.Printf("set %s from %s (%s, %d bytes)", k.key, k.seed, encoding, len(value))
+	}
+	if err := os.WriteFile(req.isoPath, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write guestinfo: %w", err)
+	}
+	req.logger.Printf("wrote %s", req.isoPath)
+	return nil
+}
+
+// guestinfoValue encodes data for a guestinfo variable. Data that is already
+// gzip compressed is only base64 encoded.
+func guestinfoValue(data []byte) (string, string, error) {
+	if userdata.IsGzip(data) {
+		return base64.StdEncoding.EncodeToString(data), "base64", nil
+	}
+	compressed, err := userdata.Gzip(data)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(compressed), guestinfoEncoding, nil
+}
+
+// guestinfoMetadata adds network to the meta-data document under the
+// network key, which is where the VMware datasource expects it.
+func guestinfoMetadata(metadata, network []byte) ([]byte, error) {
+	md := map[string]interface{}{}
+	if err := yaml.Unmarshal(metadata, &md); err != nil {
+		return nil, fmt.Errorf("parse meta-data: %w", err)
+	}
+	value, encoding, err := guestinfoValue(network)
+	if err != nil {
+		return nil, err
+	}
+	md["network"] = value
+	md["network.encoding"] = encoding
+	return yaml.Marshal(md)
+}
+
+// ovfProperties maps meta-data keys to the OVF properties cloud-init's OVF
+// datasource reads.
+var ovfProperties = []struct {
+	metaData string
+	property string
+}{
+	{metaData: "instance-id", property: "instance-id"},
+	{metaData: "local-hostname", property: "hostname"},
+	{metaData: "public-keys", property: "public-keys"},
+	{metaData: "seedfrom", property: "seedfrom"},
+}
+
+// ovfEnvFiles converts the staged seeds into an OVF environment document in
+// stageDir. user-data and network-config are carried base64 encoded;
+// vendor-data and meta-data keys without an OVF property are dropped with a
+// warning.
+func ovfEnvFiles(seeds []isoEntry, stageDir string, logger sysutil.Logger) ([]isoEntry, error) {
+	var props [][2]string
+	data := map[string][]byte{}
+	for _, seed := range seeds {
+		b, err := os.ReadFile(seed.source)
+		if err != nil {
+			return nil, err
+		}
+		data[seed.name] = b
+	}
+	md := map[string]interface{}{}
+	if err := yaml.Unmarshal(data["meta-data"], &md); err != nil {
+		return nil, fmt.Errorf("ovf environment: parse meta-data: %w", err)
+	}
+	for _, p := range ovfProperties {
+		v, ok := md[p.metaData]
+		if !ok {
+			continue
+		}
+		delete(md, p.metaData)
+		value, err := ovfPropertyValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("ovf environment: meta-data %s: %w", p.metaData, err)
+		}
+		props = append(props, [2]string{p.property, value})
+	}
+	dropped := make([]string, 0, len(md))
+	for k := range md {
+		dropped = append(dropped, k)
+	}
+	sort.Strings(dropped)
+	for _, k := range dropped {
+		output.Printf("[!] meta-data key %s has no OVF property and is not included\n", k)
+		logger.Printf("ovf environment: dropped meta-data key %s", k)
+	}
+	if network, ok := data["network-config"]; ok {
+		props = append(props, [2]string{"network-config", base64.StdEncoding.EncodeToString(network)})
+	}
+	if ud, ok := data["user-data"]; ok {
+		props = append(props, [2]string{"user-data", base64.StdEncoding.EncodeToString(ud)})
+	}
+	if _, ok := data["vendor-data"]; ok {
+		output.Println("[!] vendor-data is not supported by the OVF datasource and is not included")
+		logger.Printf("ovf environment: dropped vendor-data")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<Environment xmlns="http://schemas.dmtf.org/ovf/environment/1" xmlns:oe="http://schemas.dmtf.org/ovf/environment/1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` + "\n")
+	buf.WriteString("  <PropertySection>\n")
+	for _, p := range props {
+		buf.WriteString(`    <Property oe:key="`)
+		xml.EscapeText(&buf, []byte(p[0]))
+		buf.WriteString(`" oe:value="`)
+		xml.EscapeText(&buf, []byte(p[1]))
+		buf.WriteString("\"/>\n")
+	}
+	buf.WriteString("  </PropertySection>\n")
+	buf.WriteString("</Environment>\n")
+
+	staged := filepath.Join(stageDir, ovfEnvFile)
+	if err := os.WriteFile(staged, buf.Bytes(), 0o644); err != nil {
+		return nil, fmt.Errorf("stage %s: %w", ovfEnvFile, err)
+	}
+	return []isoEntry{{name: ovfEnvFile, source: staged}}, nil
+}
+
+// ovfPropertyValue flattens a meta-data value into a property string. Lists,
+// such as public-keys, are joined with newlines.
+func ovfPropertyValue(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case []interface{}:
+		items := make([]string, len(t))
+		for i, item := range t {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, "\n"), nil
+	case map[string]interface{}:
+		return "", errors.New("expected a string or a list")
+	default:
+		return fmt.Sprint(t), nil
+	}
+}
@@ -0,0 +1,240 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+// stageSeeds writes seeds to a temporary directory and returns them as
+// staged entries.
+func stageSeeds(t *testing.T, seeds map[string]string) []isoEntry {
+	t.Helper()
+	dir := t.TempDir()
+	var files []isoEntry
+	for name, data := range seeds {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, isoEntry{name: name, source: path})
+	}
+	return files
+}
+
+func discardLogger() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
+
+// decodeGuestinfo reverses the encoding of a guestinfo value.
+func decodeGuestinfo(t *testing.T, value, encoding string) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("decode %q: %v", value, err)
+	}
+	if encoding == guestinfoEncoding || userdata.IsGzip(data) {
+		if data, err = userdata.Gunzip(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return string(data)
+}
+
+func TestWriteGuestinfo(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+	gzipped, err := userdata.Gzip([]byte("#cloud-config\nhostname: edge1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		seeds map[string]string
+		// want maps guestinfo keys to their decoded value and encoding.
+		want map[string][2]string
+		// wantMetadata is the decoded meta-data when it embeds the network.
+		wantMetadata map[string]string
+	}{
+		{
+			name:  "seeds",
+			seeds: map[string]string{"user-data": "#cloud-config\nhostname: edge1\n", "meta-data": "instance-id: edge1\n", "vendor-data": "#cloud-config\ntimezone: UTC\n"},
+			want: map[string][2]string{
+				"guestinfo.userdata":   {"#cloud-config\nhostname: edge1\n", guestinfoEncoding},
+				"guestinfo.metadata":   {"instance-id: edge1\n", guestinfoEncoding},
+				"guestinfo.vendordata": {"#cloud-config\ntimezone: UTC\n", guestinfoEncoding},
+			},
+		},
+		{
+			name:  "gzipped user-data is only base64 encoded",
+			seeds: map[string]string{"user-data": string(gzipped), "meta-data": "instance-id: edge1\n"},
+			want: map[string][2]string{
+				"guestinfo.userdata": {"#cloud-config\nhostname: edge1\n", "base64"},
+				"guestinfo.metadata": {"instance-id: edge1\n", guestinfoEncoding},
+			},
+		},
+		{
+			name:  "network-config is embedded in the metadata",
+			seeds: map[string]string{"user-data": "#cloud-config\n", "meta-data": "instance-id: edge1\n", "network-config": "version: 2\n"},
+			want: map[string][2]string{
+				"guestinfo.userdata": {"#cloud-config\n", guestinfoEncoding},
+			},
+			wantMetadata: map[string]string{"instance-id": "edge1", "network.encoding": guestinfoEncoding, "network": "version: 2\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &buildRequest{
+				isoPath: filepath.Join(t.TempDir(), "cloud-init.vmx"),
+				files:   stageSeeds(t, tt.seeds),
+				logger:  discardLogger(),
+			}
+			if err := writeGuestinfo(req); err != nil {
+				t.Fatal(err)
+			}
+			vmx, err := os.ReadFile(req.isoPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := map[string]string{}
+			sc := bufio.NewScanner(bytes.NewReader(vmx))
+			for sc.Scan() {
+				line := sc.Text()
+				if strings.HasPrefix(line, "#") {
+					continue
+				}
+				key, quoted, ok := strings.Cut(line, " = ")
+				value, err := strconv.Unquote(quoted)
+				if !ok || err != nil {
+					t.Fatalf("malformed line %q", line)
+				}
+				values[key] = value
+			}
+			got := map[string][2]string{}
+			for key, value := range values {
+				if strings.HasSuffix(key, ".encoding") || (tt.wantMetadata != nil && key == "guestinfo.metadata") {
+					continue
+				}
+				encoding := values[key+".encoding"]
+				got[key] = [2]string{decodeGuestinfo(t, value, encoding), encoding}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("guestinfo =\n%q\nwant\n%q", got, tt.want)
+			}
+			if tt.wantMetadata == nil {
+				return
+			}
+			var md map[string]string
+			if err := yaml.Unmarshal([]byte(decodeGuestinfo(t, values["guestinfo.metadata"], values["guestinfo.metadata.encoding"])), &md); err != nil {
+				t.Fatal(err)
+			}
+			md["network"] = decodeGuestinfo(t, md["network"], md["network.encoding"])
+			if !reflect.DeepEqual(md, tt.wantMetadata) {
+				t.Errorf("metadata = %q, want %q", md, tt.wantMetadata)
+			}
+		})
+	}
+}
+
+func TestOVFEnvFiles(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+	tests := []struct {
+		name    string
+		seeds   map[string]string
+		want    [][2]string
+		wantErr string
+	}{
+		{
+			name: "seeds",
+			seeds: map[string]string{
+				"meta-data":      "instance-id: edge1\nlocal-hostname: edge1\npublic-keys:\n  - ssh-ed25519 AAAA a@b\n  - ssh-rsa BBBB c@d\nplatform: x\n",
+				"user-data":      "#cloud-config\nruncmd: [\"echo <&>\"]\n",
+				"network-config": "version: 2\n",
+				"vendor-data":    "#cloud-config\n",
+			},
+			want: [][2]string{
+				{"instance-id", "edge1"},
+				{"hostname", "edge1"},
+				{"public-keys", "ssh-ed25519 AAAA a@b\nssh-rsa BBBB c@d"},
+				{"network-config", "version: 2\n"},
+				{"user-data", "#cloud-config\nruncmd: [\"echo <&>\"]\n"},
+			},
+		},
+		{
+			name:  "seedfrom only",
+			seeds: map[string]string{"meta-data": "seedfrom: http://192.0.2.1/\n"},
+			want:  [][2]string{{"seedfrom", "http://192.0.2.1/"}},
+		},
+		{
+			name:    "mapping value",
+			seeds:   map[string]string{"meta-data": "instance-id: {a: b}\n"},
+			wantErr: "meta-data instance-id: expected a string or a list",
+		},
+		{
+			name:    "invalid meta-data",
+			seeds:   map[string]string{"meta-data": "[unterminated\n"},
+			wantErr: "parse meta-data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := ovfEnvFiles(stageSeeds(t, tt.seeds), t.TempDir(), discardLogger())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0].name != ovfEnvFile {
+				t.Fatalf("ovfEnvFiles() = %v, want a single %s", files, ovfEnvFile)
+			}
+			data, err := os.ReadFile(files[0].source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var env struct {
+				XMLName    xml.Name `xml:"http://schemas.dmtf.org/ovf/environment/1 Environment"`
+				Properties []struct {
+					Key   string `xml:"http://schemas.dmtf.org/ovf/environment/1 key,attr"`
+					Value string `xml:"http://schemas.dmtf.org/ovf/environment/1 value,attr"`
+				} `xml:"PropertySection>Property"`
+			}
+			if err := xml.Unmarshal(data, &env); err != nil {
+				t.Fatalf("parse %s: %v\n%s", ovfEnvFile, err, data)
+			}
+			var got [][2]string
+			for _, p := range env.Properties {
+				value := p.Value
+				if p.Key == "user-data" || p.Key == "network-config" {
+					decoded, err := base64.StdEncoding.DecodeString(value)
+					if err != nil {
+						t.Fatalf("%s: %v", p.Key, err)
+					}
+					value = string(decoded)
+				}
+				got = append(got, [2]string{p.Key, value})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("properties =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
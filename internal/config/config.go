@@ -0,0 +1,319 @@
+// Package config loads cloudinit-builder.yaml, the optional project file
+// that overrides the built-in build, dependency and VM test settings.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the configuration file looked up in the base directory.
+const FileName = "cloudinit-builder.yaml"
+
+// Config is the contents of cloudinit-builder.yaml. Unset fields take the
+// values of Default.
+type Config struct {
+	Build Build `yaml:"build"`
+	Deps  Deps  `yaml:"deps"`
+	Test  Test  `yaml:"test"`
+}
+
+// Build holds the build settings. Command-line flags and environment
+// variables take precedence over them.
+type Build struct {
+	// Backend is the default for build --backend.
+	Backend string `yaml:"backend"`
+	// Format is the default for build --format.
+	Format string `yaml:"format"`
+	// Out is the default for build --out.
+	Out string `yaml:"out"`
+	// Templates is the default for --templates.
+	Templates string `yaml:"templates"`
+	// Jobs is the default for build --jobs.
+	Jobs int `yaml:"jobs"`
+	// Reproducible enables build --reproducible.
+	Reproducible bool `yaml:"reproducible"`
+	// GzipUserData enables build --gzip-user-data.
+	GzipUserData bool `yaml:"gzip_user_data"`
+	// ContainerImage runs genisoimage for the container and podman-machine
+	// backends.
+	ContainerImage string `yaml:"container_image"`
+	// PullTimeout bounds pulling ContainerImage.
+	PullTimeout time.Duration `yaml:"pull_timeout"`
+	// RunTimeout bounds the genisoimage container run.
+	RunTimeout time.Duration `yaml:"run_timeout"`
+	// HostToolTimeout bounds the host-tool backend.
+	HostToolTimeout time.Duration `yaml:"host_tool_timeout"`
+}
+
+// Deps holds the settings of the managed dependencies.
+type Deps struct {
+	// ToolsDir holds the portable Podman and QEMU builds. Empty uses tools/
+	// in the base directory; --tools-dir takes precedence.
+	ToolsDir string `yaml:"tools_dir"`
+	// CacheDir holds the downloaded archives. Empty uses cache/ in the base
+	// directory; --cache-dir takes precedence.
+	CacheDir string `yaml:"cache_dir"`
+	// QEMU is the qemu-system-x86_64 executable used by test, relative to the
+	// base directory. Empty uses the portable build on Windows and PATH
+	// elsewhere; test --vm takes precedence.
+	QEMU string `yaml:"qemu"`
+	// DownloadAttempts is how often a dependency download is tried before
+	// giving up; interrupted attempts resume where they stopped.
+	DownloadAttempts int `yaml:"download_attempts"`
+	// DownloadTimeout aborts a download attempt that receives no data for
+	// this long.
+	DownloadTimeout time.Duration `yaml:"download_timeout"`
+	// CABundles are PEM files, relative to the base directory, trusted for
+	// downloads in addition to the system roots.
+	CABundles []string `yaml:"ca_bundles,omitempty"`
+	// Mirrors moves the download of a dependency (podman, qemu) to
+	// <base URL>/<archive file>, for internal mirrors of the releases.
+	Mirrors map[string]string `yaml:"mirrors,omitempty"`
+	// Manifest overrides fields of the embedded dependency manifest, keyed
+	// by dependency name (podman, qemu).
+	Manifest map[string]Artifact `yaml:"manifest,omitempty"`
+	// PodmanMachine names the managed podman machine.
+	PodmanMachine string `yaml:"podman_machine"`
+	// MachineInitTimeout bounds podman machine init.
+	MachineInitTimeout time.Duration `yaml:"machine_init_timeout"`
+	// MachineStartTimeout bounds starting, stopping and removing the machine.
+	MachineStartTimeout time.Duration `yaml:"machine_start_timeout"`
+}
+
+// Artifact pins a downloaded dependency archive. Zero fields keep the value
+// of the embedded manifest.
+type Artifact struct {
+	// Version is the upstream release, for logs only.
+	Version string `yaml:"version,omitempty"`
+	// URL is where the archive is downloaded from.
+	URL string `yaml:"url,omitempty"`
+	// File is the archive name in the cache directory.
+	File string `yaml:"file,omitempty"`
+	// Size is the archive size in bytes; zero skips the size check.
+	Size int64 `yaml:"size,omitempty"`
+	// SHA256 is the hex-encoded digest the archive must match.
+	SHA256 string `yaml:"sha256,omitempty"`
+}
+
+// ManifestNames lists the dependencies that deps.manifest may override.
+var ManifestNames = []string{"podman", "qemu"}
+
+// BackendNames and FormatNames list the values build.backend and
+// build.format accept. They mirror builder.BackendNames and
+// builder.FormatNames, which cannot be imported from here.
+var (
+	BackendNames = []string{"auto", "native", "host-tool", "container", "podman-machine"}
+	FormatNames  = []string{"iso", "vfat", "configdrive", "guestinfo", "ovf"}
+)
+
+// Test holds the VM test settings.
+type Test struct {
+	// Disk is the default for test --disk.
+	Disk string `yaml:"disk"`
+	// MemoryMB is the guest memory in MiB.
+	MemoryMB int `yaml:"memory_mb"`
+	// CPUs is the number of virtual CPUs.
+	CPUs int `yaml:"cpus"`
+	// MAC is the address of the virtio NIC.
+	MAC string `yaml:"mac"`
+	// Accel is the QEMU accelerator, below CLOUDINIT_BUILDER_QEMU_ACCEL.
+	Accel string `yaml:"accel"`
+	// Timeout bounds the VM run.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Default returns the built-in settings.
+func Default() *Config {
+	return &Config{
+		Build: Build{
+			Backend:         "auto",
+			Format:          "iso",
+			Templates:       "templates",
+			Jobs:            4,
+			ContainerImage:  "docker.io/library/debian:bookworm",
+			PullTimeout:     10 * time.Minute,
+			RunTimeout:      15 * time.Minute,
+			HostToolTimeout: 5 * time.Minute,
+		},
+		Deps: Deps{
+			DownloadAttempts:    5,
+			DownloadTimeout:     time.Minute,
+			PodmanMachine:       "cloudinit-builder",
+			MachineInitTimeout:  10 * time.Minute,
+			MachineStartTimeout: 3 * time.Minute,
+		},
+		Test: Test{
+			Disk:     "images/velocloud.qcow2",
+			MemoryMB: 4096,
+			CPUs:     2,
+			MAC:      "52:54:00:00:00:01",
+			Accel:    "tcg",
+			Timeout:  30 * time.Minute,
+		},
+	}
+}
+
+// Path returns the configuration file of baseDir.
+func Path(baseDir string) string {
+	return filepath.Join(baseDir, FileName)
+}
+
+// Load reads the configuration file of baseDir over Default. A missing file
+// gives the defaults.
+func Load(baseDir string) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(Path(baseDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err := cfg.decode(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	return cfg, nil
+}
+
+// decode merges data into c, rejecting unknown keys and invalid values.
+func (c *Config) decode(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+	check(knownName(BackendNames, c.Build.Backend), "build.backend", "%q is not a known backend (%s)", c.Build.Backend, strings.Join(BackendNames, ", "))
+	check(knownName(FormatNames, c.Build.Format), "build.format", "%q is not a known format (%s)", c.Build.Format, strings.Join(FormatNames, ", "))
+	check(c.Build.Jobs >= 1, "build.jobs", "must be at least 1")
+	check(strings.TrimSpace(c.Build.ContainerImage) != "", "build.container_image", "must not be empty")
+	check(c.Build.PullTimeout > 0, "build.pull_timeout", "must be positive")
+	check(c.Build.RunTimeout > 0, "build.run_timeout", "must be positive")
+	check(c.Build.HostToolTimeout > 0, "build.host_tool_timeout", "must be positive")
+	check(c.Deps.DownloadAttempts >= 1, "deps.download_attempts", "must be at least 1")
+	check(c.Deps.DownloadTimeout > 0, "deps.download_timeout", "must be positive")
+	for i, bundle := range c.Deps.CABundles {
+		check(strings.TrimSpace(bundle) != "", fmt.Sprintf("deps.ca_bundles[%d]", i), "must not be empty")
+	}
+	for _, name := range sortedKeys(c.Deps.Mirrors) {
+		key := "deps.mirrors." + name
+		check(knownManifestName(name), key, "is not a known dependency (%s)", strings.Join(ManifestNames, ", "))
+		u, err := url.Parse(c.Deps.Mirrors[name])
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", key, "must be an http or https URL")
+	}
+	for _, name := range sortedKeys(c.Deps.Manifest) {
+		a := c.Deps.Manifest[name]
+		key := "deps.manifest." + name
+		check(knownManifestName(name), key, "is not a known dependency (%s)", strings.Join(ManifestNames, ", "))
+		check(a.Size >= 0, key+".size", "must not be negative")
+		check(a.SHA256 == "" || validSHA256(a.SHA256), key+".sha256", "must be 64 hexadecimal digits")
+		check(a.File == "" || a.File == filepath.Base(a.File), key+".file", "must be a file name without directories")
+	}
+	check(validMachineName(c.Deps.PodmanMachine), "deps.podman_machine", "must start with a letter and contain only letters, digits, '-' and '_'")
+	check(c.Deps.MachineInitTimeout > 0, "deps.machine_init_timeout", "must be positive")
+	check(c.Deps.MachineStartTimeout > 0, "deps.machine_start_timeout", "must be positive")
+	check(strings.TrimSpace(c.Test.Disk) != "", "test.disk", "must not be empty")
+	check(c.Test.MemoryMB >= 256, "test.memory_mb", "must be at least 256")
+	check(c.Test.CPUs >= 1, "test.cpus", "must be at least 1")
+	mac, err := net.ParseMAC(c.Test.MAC)
+	check(err == nil && len(mac) == 6, "test.mac", "%q is not a 48-bit MAC address", c.Test.MAC)
+	check(err != nil || mac[0]&1 == 0, "test.mac", "%q is a multicast address", c.Test.MAC)
+	check(strings.TrimSpace(c.Test.Accel) != "", "test.accel", "must not be empty")
+	check(c.Test.Timeout > 0, "test.timeout", "must be positive")
+	return errors.Join(errs...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func knownManifestName(name string) bool {
+	for _, known := range ManifestNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// knownName matches name against names the way the builder normalises
+// backend and format names; an empty name selects the builder's default.
+func knownName(names []string, name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return true
+	}
+	for _, known := range names {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+func validSHA256(digest string) bool {
+	if len(digest) != 64 {
+		return false
+	}
+	for _, r := range digest {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func validMachineName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '-' || r == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes c to w as YAML, preceded by a comment header.
+func (c *Config) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# %s: settings for build, dependencies and VM tests.\n# Command-line flags and environment variables override these values.\n", FileName); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
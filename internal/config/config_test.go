@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{name: "empty file", yaml: ""},
+		{name: "backend and format", yaml: "build:\n  backend: Host-Tool\n  format: vfat\n"},
+		{name: "empty backend selects the default", yaml: "build:\n  backend: \"\"\n"},
+		{name: "unknown backend", yaml: "build:\n  backend: docker\n", wantErr: `build.backend "docker" is not a known backend`},
+		{name: "unknown format", yaml: "build:\n  format: qcow2\n", wantErr: `build.format "qcow2" is not a known format`},
+		{name: "unknown key", yaml: "build:\n  nope: 1\n", wantErr: "field nope not found"},
+		{name: "invalid values", yaml: "build:\n  jobs: 0\ntest:\n  mac: 01:00:5e:00:00:01\n", wantErr: "build.jobs must be at least 1\ntest.mac"},
+		{name: "unknown mirror", yaml: "deps:\n  mirrors:\n    vbox: https://example.com/\n", wantErr: "deps.mirrors.vbox is not a known dependency"},
+		{name: "manifest file with directories", yaml: "deps:\n  manifest:\n    qemu:\n      file: ../qemu.zip\n", wantErr: "deps.manifest.qemu.file must be a file name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, FileName), []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Build.Backend != "auto" || cfg.Build.Format != "iso" {
+		t.Errorf("Load() = %+v, want the defaults", cfg.Build)
+	}
+}
@@ -0,0 +1,170 @@
+// Package crypt produces password hashes in the glibc crypt(3) formats
+// accepted by cloud-init's chpasswd and users modules.
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	sha512Prefix      = "$6$"
+	sha512SaltMax     = 16
+	sha512RoundsDef   = 5000
+	sha512RoundsMin   = 1000
+	sha512RoundsMax   = 999999999
+	cryptAlphabet     = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	defaultSaltLength = 16
+)
+
+// SHA512 hashes password with a random salt and the default round count,
+// returning a "$6$salt$hash" string.
+func SHA512(password string) (string, error) {
+	salt, err := randomSalt(defaultSaltLength)
+	if err != nil {
+		return "", err
+	}
+	return SHA512WithSalt(password, salt, sha512RoundsDef)
+}
+
+// SHA512Derived hashes password like SHA512, but derives the salt from seed
+// and password instead of drawing it at random, so the same inputs always
+// give the same hash. It is meant for reproducible builds, where seed is the
+// pinned build time.
+func SHA512Derived(password, seed string) (string, error) {
+	sum := sha256.Sum256([]byte(seed + "\x00" + password))
+	salt := make([]byte, defaultSaltLength)
+	for i := range salt {
+		salt[i] = cryptAlphabet[int(sum[i])%len(cryptAlphabet)]
+	}
+	return SHA512WithSalt(password, string(salt), sha512RoundsDef)
+}
+
+// SHA512WithSalt hashes password with the given salt and round count. The
+// salt is truncated to 16 characters and may not contain '$'.
+func SHA512WithSalt(password, salt string, rounds int) (string, error) {
+	if strings.ContainsAny(salt, "$:\n") {
+		return "", errors.New("crypt: salt contains invalid characters")
+	}
+	if len(salt) > sha512SaltMax {
+		salt = salt[:sha512SaltMax]
+	}
+	if rounds < sha512RoundsMin || rounds > sha512RoundsMax {
+		return "", fmt.Errorf("crypt: rounds must be between %d and %d", sha512RoundsMin, sha512RoundsMax)
+	}
+	pw, s := []byte(password), []byte(salt)
+
+	b := sha512.New()
+	b.Write(pw)
+	b.Write(s)
+	b.Write(pw)
+	sumB := b.Sum(nil)
+
+	a := sha512.New()
+	a.Write(pw)
+	a.Write(s)
+	n := len(pw)
+	for ; n > 64; n -= 64 {
+		a.Write(sumB)
+	}
+	a.Write(sumB[:n])
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(sumB)
+		} else {
+			a.Write(pw)
+		}
+	}
+	sumA := a.Sum(nil)
+
+	dp := sha512.New()
+	for range pw {
+		dp.Write(pw)
+	}
+	pSeq := repeat(dp.Sum(nil), len(pw))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(sumA[0]); i++ {
+		ds.Write(s)
+	}
+	sSeq := repeat(ds.Sum(nil), len(s))
+
+	c := sumA
+	for r := 0; r < rounds; r++ {
+		h := sha512.New()
+		if r&1 != 0 {
+			h.Write(pSeq)
+		} else {
+			h.Write(c)
+		}
+		if r%3 != 0 {
+			h.Write(sSeq)
+		}
+		if r%7 != 0 {
+			h.Write(pSeq)
+		}
+		if r&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pSeq)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(sha512Prefix)
+	if rounds != sha512RoundsDef {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	for _, t := range sha512Order {
+		encode24(&out, c[t[0]], c[t[1]], c[t[2]], 4)
+	}
+	encode24(&out, 0, 0, c[63], 2)
+	return out.String(), nil
+}
+
+// sha512Order is the byte permutation used when encoding the final digest.
+var sha512Order = [21][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
+
+func repeat(digest []byte, length int) []byte {
+	out := make([]byte, 0, length)
+	for len(out) < length {
+		take := length - len(out)
+		if take > len(digest) {
+			take = len(digest)
+		}
+		out = append(out, digest[:take]...)
+	}
+	return out
+}
+
+func randomSalt(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("crypt: generate salt: %w", err)
+	}
+	for i, v := range buf {
+		buf[i] = cryptAlphabet[int(v)%len(cryptAlphabet)]
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,141 @@
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+// The expected hashes come from glibc crypt(3). glibc keeps an explicit
+// "rounds=5000$" in its output; SHA512WithSalt always emits the default round
+// count in the short form, which crypt(3) treats as equivalent.
+func TestSHA512WithSalt(t *testing.T) {
+	tests := []struct {
+		password string
+		salt     string
+		rounds   int
+		want     string
+	}{
+		{
+			password: "Hello world!",
+			salt:     "saltstring",
+			rounds:   5000,
+			want:     "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		},
+		{
+			password: "Hello world!",
+			salt:     "saltstringsaltstring",
+			rounds:   10000,
+			want:     "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+		},
+		{
+			password: "This is just a test",
+			salt:     "toolongsaltstring",
+			rounds:   5000,
+			want:     "$6$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0",
+		},
+		{
+			password: "a very much longer text to encrypt.  This one even stretches over morethan one line.",
+			salt:     "anotherlongsaltstring",
+			rounds:   1400,
+			want:     "$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1",
+		},
+		{
+			password: "we have a short salt string but not a short password",
+			salt:     "short",
+			rounds:   77777,
+			want:     "$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0",
+		},
+		{
+			password: "a short string",
+			salt:     "asaltof16chars..",
+			rounds:   123456,
+			want:     "$6$rounds=123456$asaltof16chars..$BtCwjqMJGx5hrJhZywWvt0RLE8uZ4oPwcelCjmw2kSYu.Ec6ycULevoBK25fs2xXgMNrCzIMVcgEJAstJeonj1",
+		},
+		{
+			password: "",
+			salt:     "saltstring",
+			rounds:   5000,
+			want:     "$6$saltstring$kyGrqt6gmjAdtFLPrflEFifSYLCWWq1pyx95SvqinLDy2UHmj0sTF0MSLMwxPFZc3tu5kQckI8fks0zOPda3n1",
+		},
+		{
+			password: strings.Repeat("x", 130),
+			salt:     "abc",
+			rounds:   5000,
+			want:     "$6$abc$8knRde6bUcKt60C1r6lJVhEsk308eHiET26Y6K6rfZjmcxVa7T7KVJE9BXzBwwbgav0R3aIuLSdeo/e4Fs99m.",
+		},
+	}
+	for _, tt := range tests {
+		got, err := SHA512WithSalt(tt.password, tt.salt, tt.rounds)
+		if err != nil {
+			t.Errorf("SHA512WithSalt(%q, %q, %d): %v", tt.password, tt.salt, tt.rounds, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("SHA512WithSalt(%q, %q, %d) =\n%s\nwant\n%s", tt.password, tt.salt, tt.rounds, got, tt.want)
+		}
+	}
+}
+
+func TestSHA512WithSaltErrors(t *testing.T) {
+	tests := []struct {
+		salt   string
+		rounds int
+		want   string
+	}{
+		{salt: "bad$salt", rounds: 5000, want: "invalid characters"},
+		{salt: "bad:salt", rounds: 5000, want: "invalid characters"},
+		{salt: "salt", rounds: 999, want: "rounds must be between"},
+		{salt: "salt", rounds: 1000000000, want: "rounds must be between"},
+	}
+	for _, tt := range tests {
+		_, err := SHA512WithSalt("pw", tt.salt, tt.rounds)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("SHA512WithSalt(%q, %d) error = %v, want %q", tt.salt, tt.rounds, err, tt.want)
+		}
+	}
+}
+
+func TestSHA512(t *testing.T) {
+	a, err := SHA512("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := SHA512("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("two hashes share a salt")
+	}
+	fields := strings.Split(a, "$")
+	if len(fields) != 4 || fields[1] != "6" || len(fields[2]) != defaultSaltLength || len(fields[3]) != 86 {
+		t.Fatalf("SHA512() = %q, want $6$<16 char salt>$<86 char hash>", a)
+	}
+	again, err := SHA512WithSalt("secret", fields[2], sha512RoundsDef)
+	if err != nil || again != a {
+		t.Errorf("rehash with the same salt = %q, %v, want %q", again, err, a)
+	}
+}
+
+func TestSHA512Derived(t *testing.T) {
+	a, err := SHA512Derived("secret", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Split(a, "$")
+	if len(fields) != 4 || len(fields[2]) != defaultSaltLength {
+		t.Fatalf("SHA512Derived() = %q, want $6$<16 char salt>$<hash>", a)
+	}
+	if again, err := SHA512WithSalt("secret", fields[2], sha512RoundsDef); err != nil || again != a {
+		t.Errorf("rehash with the derived salt = %q, %v, want %q", again, err, a)
+	}
+	for _, in := range [][2]string{{"secret", "0"}, {"secret", "1"}, {"other", "0"}} {
+		got, err := SHA512Derived(in[0], in[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same := got == a; same != (in == [2]string{"secret", "0"}) {
+			t.Errorf("SHA512Derived(%q, %q) = %q, first hash %q", in[0], in[1], got, a)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package deps
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// GenisoimageInstall installs genisoimage in the Debian builder image unless
+// it is already there.
+const GenisoimageInstall = "if ! command -v genisoimage >/dev/null; then apt-get update -qq && apt-get install -y genisoimage; fi"
+
+// bundleContainer names the container that bakes genisoimage into the
+// exported image.
+const bundleContainer = "cloudinit-builder-bundle"
+
+// bundleManifestFile is the index of an offline bundle. The archives sit
+// under cache/ and the builder image under images/, as in the workspace.
+const bundleManifestFile = "manifest.yaml"
+
+// bundleManifest describes the contents of an offline bundle.
+type bundleManifest struct {
+	// Artifacts are the dependency archives, keyed by dependency name.
+	Artifacts map[string]config.Artifact `yaml:"artifacts"`
+	// Image is the builder container image saved with podman save.
+	Image bundleImage `yaml:"image"`
+}
+
+// bundleImage describes the saved builder image.
+type bundleImage struct {
+	Name   string `yaml:"name"`
+	File   string `yaml:"file"`
+	Size   int64  `yaml:"size"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// ExportBundle writes bundlePath, a zip holding the verified podman and QEMU
+// archives, the builder image of cfg and a manifest of both, so that
+// ImportBundle can set up a machine without network access.
+func ExportBundle(baseDir, bundlePath string, cfg *config.Config, logWriter io.Writer, logger sysutil.Logger) error {
+	m := bundleManifest{Artifacts: map[string]config.Artifact{}}
+	files := map[string]string{}
+	for _, name := range config.ManifestNames {
+		archive, err := fetchArchive(baseDir, name, cfg.Deps, logger)
+		if err != nil {
+			return fmt.Errorf("prepare %s: %w", name, err)
+		}
+		a, err := Artifact(name, cfg.Deps)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(archive)
+		if err != nil {
+			return err
+		}
+		a.Size = info.Size()
+		m.Artifacts[name] = a
+		files["cache/"+a.File] = archive
+	}
+
+	tmpDir := filepath.Join(baseDir, "runtime", "bundle")
+	if err := fsutil.EnsureDir(tmpDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	image := cfg.Build.ContainerImage
+	imagePath := filepath.Join(tmpDir, imageFileName(image))
+	if err := saveImage(baseDir, image, imagePath, cfg, logWriter, logger); err != nil {
+		return err
+	}
+	sum, err := fsutil.SHA256File(imagePath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return err
+	}
+	m.Image = bundleImage{Name: image, File: filepath.Base(imagePath), Size: info.Size(), SHA256: sum}
+	files["images/"+m.Image.File] = imagePath
+
+	return writeBundle(bundlePath, m, files, logger)
+}
+
+// saveImage pulls image unless it is already present, commits it with
+// genisoimage installed so builds need no package download, and saves it to
+// dest.
+func saveImage(baseDir, image, dest string, cfg *config.Config, logWriter io.Writer, logger sysutil.Logger) error {
+	podman, stop, err := startPodman(baseDir, cfg.Deps, logWriter, logger)
+	if err != nil {
+		return fmt.Errorf("prepare podman: %w", err)
+	}
+	defer stop()
+	if !podman.imageExists(image) {
+		if _, err := podman.run(cfg.Build.PullTimeout, "pull", image); err != nil {
+			return fmt.Errorf("podman pull: %w", err)
+		}
+	}
+	_, _ = podman.run(time.Minute, "rm", "-f", bundleContainer)
+	if _, err := podman.run(cfg.Build.RunTimeout, "run", "--name", bundleContainer, image, "bash", "-c", GenisoimageInstall); err != nil {
+		return fmt.Errorf("install genisoimage: %w", err)
+	}
+	defer podman.run(time.Minute, "rm", "-f", bundleContainer)
+	if _, err := podman.run(cfg.Build.RunTimeout, "commit", bundleContainer, image); err != nil {
+		return fmt.Errorf("podman commit: %w", err)
+	}
+	if _, err := podman.run(cfg.Build.PullTimeout, "save", "-o", dest, image); err != nil {
+		return fmt.Errorf("podman save: %w", err)
+	}
+	return nil
+}
+
+// imageFileName turns an image reference into a file name, e.g.
+// docker.io/library/debian:bookworm becomes debian-bookworm.tar.
+func imageFileName(image string) string {
+	name := image[strings.LastIndex(image, "/")+1:]
+	name = strings.NewReplacer(":", "-", "@", "-").Replace(name)
+	return name + ".tar"
+}
+
+func writeBundle(bundlePath string, m bundleManifest, files map[string]string, logger sysutil.Logger) error {
+	if err := fsutil.EnsureDir(filepath.Dir(bundlePath)); err != nil {
+		return err
+	}
+	tmpPath := bundlePath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	var index bytes.Buffer
+	enc := yaml.NewEncoder(&index)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: bundleManifestFile, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(index.Bytes()); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// The archives are compressed already; storing them saves time.
+		method := zip.Store
+		if strings.HasSuffix(name, ".tar") {
+			method = zip.Deflate
+		}
+		if err := addBundleFile(zw, name, files[name], method); err != nil {
+			return err
+		}
+		if logger != nil {
+			logger.Printf("added %s to bundle", name)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, bundlePath)
+}
+
+func addBundleFile(zw *zip.Writer, name, src string, method uint16) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// ImportBundle unpacks a bundle written by ExportBundle: the archives go to
+// the cache directory and, on Windows, are installed under the tools
+// directory, and the builder image is loaded into the podman image store.
+// Every file is verified against the bundle manifest, and an archive whose
+// digest differs from a pin in the local manifest is refused.
+func ImportBundle(baseDir, bundlePath string, cfg *config.Config, logWriter io.Writer, logger sysutil.Logger) (string, error) {
+	zr, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	m, err := readBundleManifest(&zr.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := importArchives(baseDir, &zr.Reader, m, cfg.Deps, logger)
+	if err != nil {
+		return "", err
+	}
+	if err := seedTools(baseDir, d, logger); err != nil {
+		return "", fmt.Errorf("install tools: %w", err)
+	}
+
+	tmpDir := filepath.Join(baseDir, "runtime", "bundle")
+	defer os.RemoveAll(tmpDir)
+	imagePath := filepath.Join(tmpDir, m.Image.File)
+	if err := extractBundleFile(&zr.Reader, "images/"+m.Image.File, imagePath, m.Image.Size, m.Image.SHA256); err != nil {
+		return "", err
+	}
+	podman, stop, err := startPodman(baseDir, d, logWriter, logger)
+	if err != nil {
+		return "", fmt.Errorf("prepare podman: %w", err)
+	}
+	defer stop()
+	if _, err := podman.run(cfg.Build.PullTimeout, "load", "-i", imagePath); err != nil {
+		return "", fmt.Errorf("podman load: %w", err)
+	}
+	if m.Image.Name != cfg.Build.ContainerImage && logger != nil {
+		logger.Printf("warning: bundle image %s differs from build.container_image %s", m.Image.Name, cfg.Build.ContainerImage)
+	}
+	return m.Image.Name, nil
+}
+
+// importArchives extracts the dependency archives of the bundle m into the
+// cache directory and returns cfgDeps with the bundle's file names and
+// digests applied, so the tools are installed from the imported archives.
+// An archive the local manifest does not pin is verified against the bundle
+// only; the digest is not remembered for later downloads.
+func importArchives(baseDir string, zr *zip.Reader, m bundleManifest, cfgDeps config.Deps, logger sysutil.Logger) (config.Deps, error) {
+	d := cfgDeps
+	d.Manifest = map[string]config.Artifact{}
+	for name, a := range cfgDeps.Manifest {
+		d.Manifest[name] = a
+	}
+	names := make([]string, 0, len(m.Artifacts))
+	for name := range m.Artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		a := m.Artifacts[name]
+		a.SHA256 = strings.ToLower(a.SHA256)
+		local, err := resolveArtifact(name, cfgDeps)
+		if err != nil {
+			return d, err
+		}
+		if local.SHA256 != "" && local.SHA256 != a.SHA256 {
+			return d, fmt.Errorf("bundle %s archive has SHA-256 %s, the local manifest pins %s", name, a.SHA256, local.SHA256)
+		}
+		if local.SHA256 == "" {
+			if logger != nil {
+				logger.Printf("warning: no local pin for %s; trusting the bundle's SHA-256 %s for this import", name, a.SHA256)
+			}
+			output.Printf("[!] No SHA-256 pinned for %s; set deps.manifest.%s.sha256: %s in %s to keep downloads verified\n", name, name, a.SHA256, config.FileName)
+		}
+		dest := filepath.Join(CacheDir(baseDir), a.File)
+		if err := extractBundleFile(zr, "cache/"+a.File, dest, a.Size, a.SHA256); err != nil {
+			return d, err
+		}
+		if logger != nil {
+			logger.Printf("imported %s %s to %s", name, a.Version, dest)
+		}
+		// Keep the cached file name and digest, but not the URL, so the
+		// tools come from the imported archive.
+		o := d.Manifest[name]
+		o.File, o.Size, o.SHA256 = a.File, a.Size, a.SHA256
+		d.Manifest[name] = o
+	}
+	return d, nil
+}
+
+func readBundleManifest(zr *zip.Reader) (bundleManifest, error) {
+	var m bundleManifest
+	f, err := zr.Open(bundleManifestFile)
+	if err != nil {
+		return m, fmt.Errorf("not a dependency bundle: %w", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return m, fmt.Errorf("read bundle %s: %w", bundleManifestFile, err)
+	}
+	var errs []error
+	for name, a := range m.Artifacts {
+		if _, ok := embeddedManifest[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown dependency %s", name))
+		}
+		if !plainFileName(a.File) || a.SHA256 == "" {
+			errs = append(errs, fmt.Errorf("incomplete entry for %s", name))
+		}
+	}
+	if m.Image.Name == "" || !plainFileName(m.Image.File) || m.Image.SHA256 == "" {
+		errs = append(errs, errors.New("incomplete image entry"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return m, fmt.Errorf("bundle %s: %w", bundleManifestFile, err)
+	}
+	return m, nil
+}
+
+// plainFileName reports whether name is a file name without directories on
+// any platform, so that it cannot escape the cache or runtime directory.
+func plainFileName(name string) bool {
+	return name != "" && name != "." && filepath.IsLocal(name) && !strings.ContainsAny(name, `/\:`)
+}
+
+// extractBundleFile copies the bundle entry name to dest, verifying its size
+// and digest before it replaces dest.
+func extractBundleFile(zr *zip.Reader, name, dest string, size int64, sha256 string) error {
+	in, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("bundle is missing %s", name)
+	}
+	defer in.Close()
+	if err := fsutil.EnsureDir(filepath.Dir(dest)); err != nil {
+		return err
+	}
+	tmpPath := dest + ".tmp"
+	if err := fsutil.CopyStream(tmpPath, in); err != nil {
+		return err
+	}
+	if err := verifyArchive(tmpPath, config.Artifact{Size: size, SHA256: strings.ToLower(sha256)}); err != nil {
+		_ = fsutil.RemoveIfExists(tmpPath)
+		return fmt.Errorf("bundle entry %s: %w", name, err)
+	}
+	return os.Rename(tmpPath, dest)
+}
@@ -0,0 +1,109 @@
+package deps
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"velocloud-cloudinit-builder/internal/config"
+)
+
+// bundleZip returns a zip reader over files, keyed by entry name.
+func bundleZip(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestReadBundleManifest(t *testing.T) {
+	const digest = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	manifest := func(archive, image string) string {
+		return "artifacts:\n  qemu:\n    file: '" + archive + "'\n    sha256: " + digest + "\nimage:\n  name: debian:bookworm\n  file: '" + image + "'\n  sha256: " + digest + "\n"
+	}
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{name: "valid", manifest: manifest("qemu.zip", "debian-bookworm.tar")},
+		{name: "slash in archive", manifest: manifest("../qemu.zip", "debian-bookworm.tar"), wantErr: "incomplete entry for qemu"},
+		{name: "backslash in archive", manifest: manifest(`..\qemu.zip`, "debian-bookworm.tar"), wantErr: "incomplete entry for qemu"},
+		{name: "drive letter in archive", manifest: manifest(`C:qemu.zip`, "debian-bookworm.tar"), wantErr: "incomplete entry for qemu"},
+		{name: "dot dot archive", manifest: manifest("..", "debian-bookworm.tar"), wantErr: "incomplete entry for qemu"},
+		{name: "backslash in image", manifest: manifest("qemu.zip", `..\..\evil.tar`), wantErr: "incomplete image entry"},
+		{name: "absolute image", manifest: manifest("qemu.zip", "/tmp/evil.tar"), wantErr: "incomplete image entry"},
+		{name: "unknown dependency", manifest: strings.Replace(manifest("qemu.zip", "a.tar"), "qemu:", "vbox:", 1), wantErr: "unknown dependency vbox"},
+		{name: "unknown key", manifest: "extra: 1\n", wantErr: "field extra not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readBundleManifest(bundleZip(t, map[string]string{bundleManifestFile: tt.manifest}))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if _, err := readBundleManifest(bundleZip(t, map[string]string{"other": ""})); err == nil || !strings.Contains(err.Error(), "not a dependency bundle") {
+		t.Errorf("zip without a manifest: error = %v", err)
+	}
+}
+
+func TestImportArchivesPins(t *testing.T) {
+	testManifest(t, config.Artifact{Version: "1", URL: "https://example.invalid/qemu.zip", File: "qemu.zip"})
+	const data = "bundled archive"
+	zr := bundleZip(t, map[string]string{"cache/qemu.zip": data})
+	m := bundleManifest{Artifacts: map[string]config.Artifact{
+		"qemu": {Version: "1", File: "qemu.zip", Size: int64(len(data)), SHA256: strings.ToUpper(sha256Hex(data))},
+	}}
+	baseDir := t.TempDir()
+	cfgDeps := config.Default().Deps
+
+	d, err := importArchives(baseDir, zr, m, cfgDeps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Manifest["qemu"].SHA256 != sha256Hex(data) {
+		t.Errorf("override = %+v, want the bundle digest", d.Manifest["qemu"])
+	}
+	if len(cfgDeps.Manifest) != 0 {
+		t.Errorf("importArchives changed the configured manifest: %v", cfgDeps.Manifest)
+	}
+	// The bundle digest is not remembered beyond the import.
+	if _, err := CheckCachedArchive(baseDir, "qemu", cfgDeps); !errors.Is(err, ErrNotPinned) {
+		t.Errorf("CheckCachedArchive after import = %v, want ErrNotPinned", err)
+	}
+	if _, err := CheckCachedArchive(baseDir, "qemu", d); err != nil {
+		t.Errorf("CheckCachedArchive with the imported digest = %v", err)
+	}
+
+	// A bundle whose digest differs from a local pin is refused.
+	m.Artifacts["qemu"] = config.Artifact{Version: "1", File: "qemu.zip", SHA256: sha256Hex("other")}
+	if _, err := importArchives(baseDir, zr, m, d, nil); err == nil || !strings.Contains(err.Error(), "the local manifest pins") {
+		t.Errorf("import of a conflicting bundle: error = %v", err)
+	}
+}
@@ -2,10 +2,8 @@ package deps
 
 import (
 	"archive/zip"
-	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,16 +12,7 @@ import (
 	"velocloud-cloudinit-builder/internal/sysutil"
 )
 
-const (
-	podmanVersionTag = "v5.1.0"
-	podmanZipURL     = "https://github.com/containers/podman/releases/download/v5.1.0/podman-remote-release-windows_amd64.zip"
-	podmanZipName    = "podman-remote-release-windows_amd64.zip"
-)
-
 var baseDirs = []string{
-	"tools",
-	"tools/podman",
-	"tools/qemu",
 	"templates",
 	"images",
 	"runtime",
@@ -32,14 +21,26 @@ var baseDirs = []string{
 	"runtime/podman/config",
 	"runtime/podman/run",
 	"runtime/podman/home",
-	"cache",
 	"logs",
 }
 
-// EnsureBaseLayout guarantees the directory skeleton exists.
-func EnsureBaseLayout(baseDir string, logger sysutil.Logger) error {
+// LayoutDirs returns the directories created by EnsureBaseLayout.
+func LayoutDirs(baseDir string) []string {
+	targets := []string{
+		filepath.Join(ToolsDir(baseDir), "podman"),
+		filepath.Join(ToolsDir(baseDir), "qemu"),
+		CacheDir(baseDir),
+	}
 	for _, rel := range baseDirs {
-		target := filepath.Join(baseDir, filepath.FromSlash(rel))
+		targets = append(targets, filepath.Join(baseDir, filepath.FromSlash(rel)))
+	}
+	return targets
+}
+
+// EnsureBaseLayout guarantees the directory skeleton exists, including the
+// tool and cache directories, which may be shared.
+func EnsureBaseLayout(baseDir string, logger sysutil.Logger) error {
+	for _, target := range LayoutDirs(baseDir) {
 		if err := fsutil.EnsureDir(target); err != nil {
 			return err
 		}
@@ -50,21 +51,32 @@ func EnsureBaseLayout(baseDir string, logger sysutil.Logger) error {
 	return nil
 }
 
-// EnsureTemplates creates default template files if they do not already exist.
-func EnsureTemplates(baseDir string, logger sysutil.Logger) error {
-	templateDir := filepath.Join(baseDir, "templates")
-	userData := filepath.Join(templateDir, "user-data.txt")
-	metaData := filepath.Join(templateDir, "meta-data.txt")
+// defaultTemplates lists the files written by EnsureTemplates. The optional
+// network-config and vendor-data seeds and the user-data.d part are only
+// provided as .example files, so they are not packed until the user renames
+// them.
+var defaultTemplates = []struct {
+	name    string
+	label   string
+	content func() string
+}{
+	{name: "user-data.txt", label: "user-data template", content: defaultUserData},
+	{name: "meta-data.txt", label: "meta-data template", content: defaultMetaData},
+	{name: "network-config.txt.example", label: "network-config example", content: defaultNetworkConfig},
+	{name: "vendor-data.txt.example", label: "vendor-data example", content: defaultVendorData},
+	{name: "user-data.d/50-example.sh.example", label: "user-data part example", content: defaultUserDataPart},
+}
 
-	if created, err := ensureFileWithContent(userData, defaultUserData(), logger); err != nil {
-		return err
-	} else if created && logger != nil {
-		logger.Printf("created default user-data template at %s", userData)
-	}
-	if created, err := ensureFileWithContent(metaData, defaultMetaData(), logger); err != nil {
-		return err
-	} else if created && logger != nil {
-		logger.Printf("created default meta-data template at %s", metaData)
+// EnsureTemplates creates default template files in templateDir if they do
+// not already exist.
+func EnsureTemplates(templateDir string, logger sysutil.Logger) error {
+	for _, tmpl := range defaultTemplates {
+		path := filepath.Join(templateDir, tmpl.name)
+		if created, err := ensureFileWithContent(path, tmpl.content(), logger); err != nil {
+			return err
+		} else if created && logger != nil {
+			logger.Printf("created default %s at %s", tmpl.label, path)
+		}
 	}
 	return nil
 }
@@ -83,91 +95,6 @@ func ensureFileWithContent(path string, content string, logger sysutil.Logger) (
 	return true, nil
 }
 
-// EnsurePodman makes sure podman.exe is available locally and returns its path.
-func EnsurePodman(baseDir string, logger sysutil.Logger) (string, error) {
-	podmanDir := filepath.Join(baseDir, "tools", "podman")
-	podmanExe := filepath.Join(podmanDir, "podman.exe")
-
-	exists, err := fsutil.PathExists(podmanExe)
-	if err != nil {
-		return "", err
-	}
-	if exists {
-		if err := copySupportBinaries(podmanDir); err != nil {
-			return "", err
-		}
-		if logger != nil {
-			logger.Printf("podman already present at %s", podmanExe)
-		}
-		return podmanExe, nil
-	}
-
-	if logger != nil {
-		logger.Printf("podman not found, downloading portable release %s", podmanVersionTag)
-	}
-	cacheDir := filepath.Join(baseDir, "cache")
-	if err := fsutil.EnsureDir(cacheDir); err != nil {
-		return "", err
-	}
-	zipPath := filepath.Join(cacheDir, podmanZipName)
-	if err := downloadFile(podmanZipURL, zipPath, logger); err != nil {
-		return "", err
-	}
-
-	if logger != nil {
-		logger.Printf("extracting podman archive %s", zipPath)
-	}
-	if err := fsutil.RemoveIfExists(podmanDir); err != nil {
-		return "", err
-	}
-	if err := fsutil.EnsureDir(podmanDir); err != nil {
-		return "", err
-	}
-	if err := extractZip(zipPath, podmanDir); err != nil {
-		return "", err
-	}
-	if err := placePodmanExecutable(podmanDir); err != nil {
-		return "", err
-	}
-	if err := copySupportBinaries(podmanDir); err != nil {
-		return "", err
-	}
-	if logger != nil {
-		logger.Printf("podman ready at %s", podmanExe)
-	}
-	return podmanExe, nil
-}
-
-func downloadFile(url, dest string, logger sysutil.Logger) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("download failed: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status %s", resp.Status)
-	}
-	tmpDest := dest + ".tmp"
-	out, err := os.Create(tmpDest)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-	if _, err := io.Copy(out, resp.Body); err != nil {
-		return err
-	}
-	if err := out.Close(); err != nil {
-		return err
-	}
-	if err := os.Rename(tmpDest, dest); err != nil {
-		return err
-	}
-	if logger != nil {
-		logger.Printf("downloaded %s (%d bytes)", dest, resp.ContentLength)
-	}
-	return nil
-}
-
 func extractZip(zipPath, dest string) error {
 	reader, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -210,77 +137,53 @@ func extractZip(zipPath, dest string) error {
 	return nil
 }
 
-func placePodmanExecutable(podmanDir string) error {
-	return copyBinaryIfNeeded(podmanDir, "podman.exe")
+func defaultUserData() string {
+	return strings.Join([]string{
+		"#cloud-config",
+		"hostname: vce",
+	}, "\n") + "\n"
 }
 
-func copySupportBinaries(podmanDir string) error {
-	for _, name := range []string{"win-sshproxy.exe", "gvproxy.exe"} {
-		if err := copyBinaryIfNeeded(podmanDir, name); err != nil {
-			return err
-		}
-	}
-	return nil
+func defaultMetaData() string {
+	return strings.Join([]string{
+		"instance-id: vce",
+		"local-hostname: vce",
+	}, "\n") + "\n"
 }
 
-func copyBinaryIfNeeded(rootDir, binaryName string) error {
-	target := filepath.Join(rootDir, binaryName)
-	exists, err := fsutil.PathExists(target)
-	if err != nil {
-		return err
-	}
-	if exists {
-		return nil
-	}
-	var found string
-	err = filepath.WalkDir(rootDir, func(path string, d os.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-		if d.IsDir() {
-			return nil
-		}
-		if strings.EqualFold(d.Name(), binaryName) {
-			found = path
-			return io.EOF
-		}
-		return nil
-	})
-	if err != nil && !errors.Is(err, io.EOF) {
-		return err
-	}
-	if found == "" {
-		return fmt.Errorf("%s not found after extraction", binaryName)
-	}
-	in, err := os.Open(found)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
-	if err != nil {
-		return err
-	}
-	if _, err := io.Copy(out, in); err != nil {
-		out.Close()
-		return err
-	}
-	return out.Close()
+func defaultNetworkConfig() string {
+	return strings.Join([]string{
+		"# Rename to network-config.txt to pack it into the ISO.",
+		"# Static WAN addressing for the edge (NoCloud network config version 2).",
+		"version: 2",
+		"ethernets:",
+		"  eth0:",
+		"    dhcp4: false",
+		"    addresses:",
+		"      - 192.168.1.2/24",
+		"    gateway4: 192.168.1.1",
+		"    nameservers:",
+		"      addresses: [8.8.8.8, 8.8.4.4]",
+		"  eth1:",
+		"    dhcp4: true",
+	}, "\n") + "\n"
 }
 
-func defaultUserData() string {
+func defaultVendorData() string {
 	return strings.Join([]string{
 		"#cloud-config",
-		"hostname: vce",
-		"password: Velocloud123",
-		"chpasswd: {expire: False}",
-		"ssh_pwauth: True",
+		"# Rename to vendor-data.txt to pack it into the ISO.",
+		"# Settings shared by every edge; user-data takes precedence.",
+		"timezone: UTC",
 	}, "\n") + "\n"
 }
 
-func defaultMetaData() string {
+func defaultUserDataPart() string {
 	return strings.Join([]string{
-		"instance-id: vce",
-		"local-hostname: vce",
+		"#!/bin/sh",
+		"# Rename to 50-example.sh to add it to user-data as a MIME part.",
+		"# Every file in user-data.d is rendered like user-data.txt and packed",
+		"# after it, in name order; the content type follows the first line.",
+		"echo \"provisioned {{ index . \"hostname\" | default \"edge\" }}\" > /var/tmp/cloudinit-builder",
 	}, "\n") + "\n"
 }
@@ -0,0 +1,45 @@
+package deps
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// toolsDir and cacheDir override the workspace's tools/ and cache/ so that
+// downloaded Podman and QEMU builds can be shared between workspaces.
+var (
+	toolsDir string
+	cacheDir string
+)
+
+// SetSharedDirs sets the tool and download directories used instead of
+// tools/ and cache/ in the base directory. Empty values keep the defaults.
+func SetSharedDirs(tools, cache string) {
+	toolsDir = tools
+	cacheDir = cache
+}
+
+// ToolsDir returns the directory holding the portable tools for baseDir.
+func ToolsDir(baseDir string) string {
+	if toolsDir != "" {
+		return toolsDir
+	}
+	return filepath.Join(baseDir, "tools")
+}
+
+// CacheDir returns the directory holding downloaded archives for baseDir.
+func CacheDir(baseDir string) string {
+	if cacheDir != "" {
+		return cacheDir
+	}
+	return filepath.Join(baseDir, "cache")
+}
+
+// withinDir reports whether path lies below dir.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,368 @@
+package deps
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+const (
+	initialBackoff   = time.Second
+	maxBackoff       = 30 * time.Second
+	progressInterval = 200 * time.Millisecond
+	logInterval      = 10 * time.Second
+)
+
+// downloader fetches files over HTTP, resuming partial downloads and
+// retrying transient failures.
+type downloader struct {
+	client   *http.Client
+	attempts int
+	// stall aborts an attempt that receives no data for this long.
+	stall time.Duration
+	// backoff is the wait before the first retry; it doubles up to maxBackoff.
+	backoff time.Duration
+	logger  sysutil.Logger
+}
+
+// newDownloader returns a downloader with the settings of d. Requests go
+// through the proxy named by HTTPS_PROXY, HTTP_PROXY and NO_PROXY, and the
+// CA bundles of d, relative to baseDir, are trusted next to the system roots.
+func newDownloader(baseDir string, d config.Deps, logger sysutil.Logger) (*downloader, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if len(d.CABundles) > 0 {
+		pool, err := certPool(baseDir, d.CABundles, logger)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &downloader{
+		client:   &http.Client{Transport: transport},
+		attempts: d.DownloadAttempts,
+		stall:    d.DownloadTimeout,
+		backoff:  initialBackoff,
+		logger:   logger,
+	}, nil
+}
+
+// certPool returns the system roots plus the certificates of bundles.
+func certPool(baseDir string, bundles []string, logger sysutil.Logger) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, bundle := range bundles {
+		path := bundle
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", path)
+		}
+		if logger != nil {
+			logger.Printf("trusting CA bundle %s", path)
+		}
+	}
+	return pool, nil
+}
+
+// logProxy records the proxy, if any, that req goes through. Proxy
+// credentials are masked.
+func (dl *downloader) logProxy(req *http.Request) {
+	if dl.logger == nil {
+		return
+	}
+	transport, ok := dl.client.Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		return
+	}
+	proxy, err := transport.Proxy(req)
+	switch {
+	case err != nil:
+		dl.logger.Printf("warning: invalid proxy setting: %v", err)
+	case proxy == nil:
+		dl.logger.Printf("connecting to %s directly", req.URL.Host)
+	default:
+		dl.logger.Printf("connecting to %s through proxy %s", req.URL.Host, proxy.Redacted())
+	}
+}
+
+// retryableError marks failures worth another attempt: network errors,
+// stalls, 5xx, 408 and 429.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// fetch downloads url to dest. Data goes to dest+".tmp", which is kept when
+// an attempt fails, so the next attempt (or the next run) continues it with
+// an HTTP Range request instead of starting over.
+func (dl *downloader) fetch(url, dest string) error {
+	tmpDest := dest + ".tmp"
+	backoff := dl.backoff
+	var err error
+	for attempt := 1; attempt <= dl.attempts; attempt++ {
+		if attempt > 1 {
+			if dl.logger != nil {
+				dl.logger.Printf("retrying download of %s in %s (attempt %d of %d): %v", url, backoff, attempt, dl.attempts, err)
+			}
+			output.Printf("[!] Download interrupted, retrying in %s (attempt %d of %d)\n", backoff, attempt, dl.attempts)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		err = dl.attempt(url, tmpDest)
+		if err == nil {
+			return os.Rename(tmpDest, dest)
+		}
+		var retryable *retryableError
+		if !errors.As(err, &retryable) {
+			break
+		}
+	}
+	return fmt.Errorf("download %s: %w", url, err)
+}
+
+// attempt makes one request, appending to tmpDest when the server honours
+// the Range header and rewriting it when it does not.
+func (dl *downloader) attempt(url, tmpDest string) error {
+	var offset int64
+	if info, err := os.Stat(tmpDest); err == nil {
+		offset = info.Size()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var stalled atomic.Bool
+	timer := time.AfterFunc(dl.stall, func() {
+		stalled.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	dl.logProxy(req)
+	resp, err := dl.client.Do(req)
+	if err != nil {
+		return dl.transportError(err, stalled.Load())
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	total := resp.ContentLength
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return &retryableError{fmt.Errorf("unexpected Content-Range %q for resume at %d", resp.Header.Get("Content-Range"), offset)}
+		}
+		flags |= os.O_APPEND
+		total = size
+		if dl.logger != nil {
+			dl.logger.Printf("resuming %s at byte %d", filepath.Base(tmpDest), offset)
+		}
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == offset {
+			return nil
+		}
+		if err := os.Remove(tmpDest); err != nil {
+			return err
+		}
+		return &retryableError{errors.New("partial download does not match the server copy; starting over")}
+	default:
+		err := fmt.Errorf("unexpected HTTP status %s", resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests {
+			return &retryableError{err}
+		}
+		return err
+	}
+
+	out, err := os.OpenFile(tmpDest, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	progress := newProgress(filepath.Base(strings.TrimSuffix(tmpDest, ".tmp")), offset, total, dl.logger)
+	body := &stallReader{r: resp.Body, timer: timer, stall: dl.stall}
+	_, copyErr := io.Copy(io.MultiWriter(out, progress), body)
+	progress.finish(copyErr == nil)
+	if copyErr != nil {
+		return dl.transportError(copyErr, stalled.Load())
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if total >= 0 && progress.done != total {
+		return &retryableError{fmt.Errorf("connection closed after %d of %d bytes", progress.done, total)}
+	}
+	return nil
+}
+
+func (dl *downloader) transportError(err error, stalled bool) error {
+	if stalled {
+		return &retryableError{fmt.Errorf("no data received for %s", dl.stall)}
+	}
+	return &retryableError{err}
+}
+
+// parseContentRange reads "bytes <start>-<end>/<size>" and "bytes */<size>".
+// size is -1 when the server does not know it.
+func parseContentRange(value string) (start, size int64, ok bool) {
+	spec, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rangePart, sizePart, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size = -1
+	if sizePart != "*" {
+		n, err := strconv.ParseInt(sizePart, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		size = n
+	}
+	if rangePart == "*" {
+		return 0, size, true
+	}
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// stallReader pushes back the stall timer whenever data arrives.
+type stallReader struct {
+	r     io.Reader
+	timer *time.Timer
+	stall time.Duration
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.stall)
+	}
+	return n, err
+}
+
+// progress draws a console progress bar for one attempt and writes a
+// summary to the log every logInterval.
+type progress struct {
+	name    string
+	done    int64
+	total   int64
+	resumed int64
+	start   time.Time
+	drawn   time.Time
+	logged  time.Time
+	logger  sysutil.Logger
+}
+
+func newProgress(name string, offset, total int64, logger sysutil.Logger) *progress {
+	now := time.Now()
+	return &progress{name: name, done: offset, total: total, resumed: offset, start: now, logged: now, logger: logger}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	now := time.Now()
+	if now.Sub(p.drawn) >= progressInterval {
+		p.drawn = now
+		output.Progress("[*] " + p.line(now))
+	}
+	if p.logger != nil && now.Sub(p.logged) >= logInterval {
+		p.logged = now
+		p.logger.Printf("download %s", p.line(now))
+	}
+	return len(b), nil
+}
+
+func (p *progress) finish(complete bool) {
+	now := time.Now()
+	if complete {
+		output.Progress("[*] " + p.line(now))
+	}
+	output.EndProgress()
+	if p.logger != nil {
+		p.logger.Printf("download %s", p.line(now))
+	}
+}
+
+// line renders "name [=====>    ] 12.0 MiB / 48.0 MiB  3.2 MiB/s  ETA 11s".
+func (p *progress) line(now time.Time) string {
+	elapsed := now.Sub(p.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.done-p.resumed) / elapsed
+	}
+	if p.total <= 0 {
+		return fmt.Sprintf("%s %s  %s/s", p.name, formatBytes(p.done), formatBytes(int64(rate)))
+	}
+	const width = 24
+	filled := int(float64(width) * float64(p.done) / float64(p.total))
+	if filled > width {
+		filled = width
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	eta := "--"
+	if rate > 0 {
+		eta = time.Duration(float64(p.total-p.done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s [%s] %s / %s  %s/s  ETA %s", p.name, bar, formatBytes(p.done), formatBytes(p.total), formatBytes(int64(rate)), eta)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,227 @@
+package deps
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const payload = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// step answers one request; calls counts from zero.
+type step func(w http.ResponseWriter, r *http.Request)
+
+// scriptedServer answers the n-th request with steps[n], repeating the last
+// step once the script runs out, and records the Range header of each.
+type scriptedServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	steps  []step
+	ranges []string
+}
+
+func newScriptedServer(t *testing.T, steps ...step) *scriptedServer {
+	s := &scriptedServer{steps: steps}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		n := len(s.ranges)
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+		if n >= len(s.steps) {
+			n = len(s.steps) - 1
+		}
+		s.steps[n](w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *scriptedServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+// serve honours Range requests like a static file server.
+func serve(w http.ResponseWriter, r *http.Request) {
+	http.ServeContent(w, r, "archive.zip", time.Time{}, strings.NewReader(payload))
+}
+
+// dropAfter sends the first n bytes of a full response, then closes the
+// connection.
+func dropAfter(n int) step {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(payload[:n]))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+func status(code int) step {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+// stallAfter sends the first n bytes, then stops sending until the client
+// gives up.
+func stallAfter(n int) step {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(payload[:n]))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}
+}
+
+// ignoreRange answers every request with the whole payload and 200.
+func ignoreRange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+	_, _ = w.Write([]byte(payload))
+}
+
+func TestDownloaderFetch(t *testing.T) {
+	tests := []struct {
+		name string
+		// partial is left in dest+".tmp" before the download starts.
+		partial    string
+		steps      []step
+		attempts   int
+		wantRanges []string
+		wantErr    string
+	}{
+		{
+			name:       "complete",
+			steps:      []step{serve},
+			attempts:   3,
+			wantRanges: []string{""},
+		},
+		{
+			name:       "resume after a dropped connection",
+			steps:      []step{dropAfter(10), serve},
+			attempts:   3,
+			wantRanges: []string{"", "bytes=10-"},
+		},
+		{
+			name:       "5xx is retried",
+			steps:      []step{status(http.StatusServiceUnavailable), status(http.StatusBadGateway), serve},
+			attempts:   3,
+			wantRanges: []string{"", "", ""},
+		},
+		{
+			name:       "5xx until attempts run out",
+			steps:      []step{status(http.StatusInternalServerError)},
+			attempts:   2,
+			wantRanges: []string{"", ""},
+			wantErr:    "unexpected HTTP status 500",
+		},
+		{
+			name:       "404 is not retried",
+			steps:      []step{status(http.StatusNotFound), serve},
+			attempts:   3,
+			wantRanges: []string{""},
+			wantErr:    "unexpected HTTP status 404",
+		},
+		{
+			name:       "stall is retried",
+			steps:      []step{stallAfter(5), serve},
+			attempts:   3,
+			wantRanges: []string{"", "bytes=5-"},
+		},
+		{
+			name:       "416 with a complete partial download",
+			partial:    payload,
+			steps:      []step{serve},
+			attempts:   1,
+			wantRanges: []string{fmt.Sprintf("bytes=%d-", len(payload))},
+		},
+		{
+			name:       "416 with a mismatching partial download starts over",
+			partial:    payload + "extra",
+			steps:      []step{serve},
+			attempts:   2,
+			wantRanges: []string{fmt.Sprintf("bytes=%d-", len(payload)+5), ""},
+		},
+		{
+			name:       "200 instead of 206 restarts",
+			partial:    "stale",
+			steps:      []step{ignoreRange},
+			attempts:   1,
+			wantRanges: []string{"bytes=5-"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newScriptedServer(t, tt.steps...)
+			dest := filepath.Join(t.TempDir(), "archive.zip")
+			if tt.partial != "" {
+				if err := os.WriteFile(dest+".tmp", []byte(tt.partial), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dl := &downloader{
+				client:   srv.Client(),
+				attempts: tt.attempts,
+				stall:    200 * time.Millisecond,
+				backoff:  time.Millisecond,
+			}
+			err := dl.fetch(srv.URL+"/archive.zip", dest)
+			if got := srv.requests(); strings.Join(got, "|") != strings.Join(tt.wantRanges, "|") {
+				t.Errorf("Range headers = %q, want %q", got, tt.wantRanges)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != payload {
+				t.Errorf("downloaded %q, want %q", data, payload)
+			}
+			if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("partial file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value       string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 10-35/36", 10, 36, true},
+		{"bytes 0-9/*", 0, -1, true},
+		{"bytes */36", 0, 36, true},
+		{"bytes 10-35", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+		{"bytes x-1/2", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, size, ok := parseContentRange(tt.value)
+		if start != tt.start || size != tt.size || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v", tt.value, start, size, ok, tt.start, tt.size, tt.ok)
+		}
+	}
+}
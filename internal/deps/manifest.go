@@ -0,0 +1,187 @@
+package deps
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+//go:embed manifest.yaml
+var manifestYAML []byte
+
+// embeddedManifest is the parsed manifest.yaml, keyed by dependency name.
+var embeddedManifest = mustParseManifest(manifestYAML)
+
+func mustParseManifest(data []byte) map[string]config.Artifact {
+	var m map[string]config.Artifact
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		panic(fmt.Sprintf("parse embedded dependency manifest: %v", err))
+	}
+	return m
+}
+
+// Artifact returns the manifest entry of the dependency name, with the
+// non-zero fields of d.Manifest applied over the embedded values and the URL
+// moved to the mirror in d.Mirrors, if any. The entry must pin a SHA-256.
+func Artifact(name string, d config.Deps) (config.Artifact, error) {
+	a, err := resolveArtifact(name, d)
+	if err != nil {
+		return config.Artifact{}, err
+	}
+	if a.SHA256 == "" {
+		return config.Artifact{}, fmt.Errorf("manifest entry %s has no SHA-256 pinned; set deps.manifest.%s.sha256 in %s", name, name, config.FileName)
+	}
+	return a, nil
+}
+
+// resolveArtifact is Artifact without the requirement of a pinned digest.
+func resolveArtifact(name string, d config.Deps) (config.Artifact, error) {
+	a, ok := embeddedManifest[name]
+	if !ok {
+		return config.Artifact{}, fmt.Errorf("dependency %s is not in the manifest", name)
+	}
+	if o, ok := d.Manifest[name]; ok {
+		if o.Version != "" {
+			a.Version = o.Version
+		}
+		if o.URL != "" {
+			a.URL = o.URL
+		}
+		if o.File != "" {
+			a.File = o.File
+		}
+		if o.Size != 0 {
+			a.Size = o.Size
+		}
+		if o.SHA256 != "" {
+			a.SHA256 = o.SHA256
+		}
+	}
+	if base, ok := d.Mirrors[name]; ok {
+		a.URL = strings.TrimRight(base, "/") + "/" + url.PathEscape(a.File)
+	}
+	if a.URL == "" || a.File == "" {
+		return config.Artifact{}, fmt.Errorf("manifest entry %s needs both url and file", name)
+	}
+	a.SHA256 = strings.ToLower(a.SHA256)
+	return a, nil
+}
+
+// Cache states reported by CheckCachedArchive.
+var (
+	ErrNotCached = errors.New("archive not cached")
+	ErrNotPinned = errors.New("no SHA-256 pinned")
+)
+
+// CheckCachedArchive verifies the cached archive of the dependency name and
+// returns its path. It returns ErrNotCached when there is none and
+// ErrNotPinned when the manifest has no digest to compare it with; an
+// unpinned archive that is not cached yet gives both.
+func CheckCachedArchive(baseDir, name string, d config.Deps) (string, error) {
+	a, err := resolveArtifact(name, d)
+	if err != nil {
+		return "", err
+	}
+	zipPath := filepath.Join(CacheDir(baseDir), a.File)
+	exists, err := fsutil.PathExists(zipPath)
+	if err != nil {
+		return zipPath, err
+	}
+	if !exists {
+		if a.SHA256 == "" {
+			return zipPath, errors.Join(ErrNotCached, ErrNotPinned)
+		}
+		return zipPath, ErrNotCached
+	}
+	if a.SHA256 == "" {
+		return zipPath, ErrNotPinned
+	}
+	return zipPath, verifyArchive(zipPath, a)
+}
+
+// verifyArchive checks the size and SHA-256 of path against a.
+func verifyArchive(path string, a config.Artifact) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if a.Size > 0 && info.Size() != a.Size {
+		return fmt.Errorf("%s is %d bytes, the manifest pins %d", path, info.Size(), a.Size)
+	}
+	sum, err := fsutil.SHA256File(path)
+	if err != nil {
+		return fmt.Errorf("hash %s: %w", path, err)
+	}
+	if sum != a.SHA256 {
+		return fmt.Errorf("%s has SHA-256 %s, the manifest pins %s", path, sum, a.SHA256)
+	}
+	return nil
+}
+
+// fetchArchive returns the verified archive of name in the cache directory.
+// A cached archive is reused only when it matches the manifest; otherwise it
+// is discarded and downloaded again, and a download that does not match is
+// refused before anything is extracted. An entry without a SHA-256 is not
+// downloaded at all.
+func fetchArchive(baseDir, name string, d config.Deps, logger sysutil.Logger) (string, error) {
+	a, err := Artifact(name, d)
+	if err != nil {
+		return "", err
+	}
+	cacheDir := CacheDir(baseDir)
+	if err := fsutil.EnsureDir(cacheDir); err != nil {
+		return "", err
+	}
+	zipPath := filepath.Join(cacheDir, a.File)
+	exists, err := fsutil.PathExists(zipPath)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		verifyErr := verifyArchive(zipPath, a)
+		if verifyErr == nil {
+			if logger != nil {
+				logger.Printf("using cached %s %s archive %s (sha256 %s)", name, a.Version, zipPath, a.SHA256)
+			}
+			return zipPath, nil
+		}
+		if logger != nil {
+			logger.Printf("warning: discarding cached %s archive: %v", name, verifyErr)
+		}
+		if err := fsutil.RemoveIfExists(zipPath); err != nil {
+			return "", err
+		}
+	}
+
+	if logger != nil {
+		logger.Printf("downloading %s %s from %s", name, a.Version, a.URL)
+	}
+	dl, err := newDownloader(baseDir, d, logger)
+	if err != nil {
+		return "", err
+	}
+	if err := dl.fetch(a.URL, zipPath); err != nil {
+		return "", err
+	}
+	if err := verifyArchive(zipPath, a); err != nil {
+		_ = fsutil.RemoveIfExists(zipPath)
+		return "", fmt.Errorf("refusing to use %s download: %w", name, err)
+	}
+	if logger != nil {
+		logger.Printf("verified %s (sha256 %s)", zipPath, a.SHA256)
+	}
+	return zipPath, nil
+}
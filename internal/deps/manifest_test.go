@@ -0,0 +1,91 @@
+package deps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/fsutil"
+)
+
+// testManifest replaces the embedded manifest with a single unpinned qemu
+// entry for the duration of the test.
+func testManifest(t *testing.T, a config.Artifact) {
+	t.Helper()
+	saved := embeddedManifest
+	embeddedManifest = map[string]config.Artifact{"qemu": a}
+	t.Cleanup(func() { embeddedManifest = saved })
+}
+
+func testDeps(url string) config.Deps {
+	d := config.Default().Deps
+	d.DownloadAttempts = 1
+	d.DownloadTimeout = 5 * time.Second
+	d.Mirrors = map[string]string{"qemu": url}
+	return d
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFetchArchiveNeedsDigest(t *testing.T) {
+	testManifest(t, config.Artifact{Version: "1", URL: "https://example.invalid/qemu.zip", File: "qemu.zip"})
+	body := "first archive"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	baseDir := t.TempDir()
+	d := testDeps(srv.URL)
+
+	if _, err := CheckCachedArchive(baseDir, "qemu", d); !errors.Is(err, ErrNotCached) || !errors.Is(err, ErrNotPinned) {
+		t.Fatalf("CheckCachedArchive before download = %v, want ErrNotCached and ErrNotPinned", err)
+	}
+	if _, err := fetchArchive(baseDir, "qemu", d, nil); err == nil || !strings.Contains(err.Error(), "no SHA-256 pinned") {
+		t.Fatalf("fetchArchive without a digest: error = %v", err)
+	}
+	if requests != 0 {
+		t.Fatalf("fetchArchive without a digest made %d requests", requests)
+	}
+
+	// A digest in the configuration allows the download.
+	d.Manifest = map[string]config.Artifact{"qemu": {SHA256: strings.ToUpper(sha256Hex(body))}}
+	zipPath, err := fetchArchive(baseDir, "qemu", d, nil)
+	if err != nil {
+		t.Fatalf("fetchArchive with a configured digest: %v", err)
+	}
+	if _, err := CheckCachedArchive(baseDir, "qemu", d); err != nil {
+		t.Fatalf("CheckCachedArchive after download = %v", err)
+	}
+	if _, err := CheckCachedArchive(baseDir, "qemu", testDeps(srv.URL)); !errors.Is(err, ErrNotPinned) || errors.Is(err, ErrNotCached) {
+		t.Fatalf("CheckCachedArchive without the digest = %v, want ErrNotPinned", err)
+	}
+
+	// A tampered cache is discarded, and a download that does not match the
+	// digest is refused.
+	if err := os.WriteFile(zipPath, []byte("tampered archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	body = "other archive"
+	if _, err := fetchArchive(baseDir, "qemu", d, nil); err == nil || !strings.Contains(err.Error(), "refusing to use qemu download") {
+		t.Fatalf("fetchArchive of a changed archive: error = %v", err)
+	}
+	if exists, _ := fsutil.PathExists(zipPath); exists {
+		t.Error("the refused download was kept in the cache")
+	}
+	if _, err := os.Stat(filepath.Join(CacheDir(baseDir), "pins.yaml")); !os.IsNotExist(err) {
+		t.Errorf("a digest was recorded in the cache: %v", err)
+	}
+}
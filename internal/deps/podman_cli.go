@@ -0,0 +1,36 @@
+package deps
+
+import (
+	"io"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// podmanCLI runs podman commands against the image store that the builder
+// backends use: the managed machine on Windows, the host's podman elsewhere.
+type podmanCLI struct {
+	baseDir   string
+	path      string
+	env       []string
+	global    []string
+	logWriter io.Writer
+	logger    sysutil.Logger
+}
+
+func (p *podmanCLI) run(timeout time.Duration, args ...string) (*sysutil.RunResult, error) {
+	return sysutil.RunCommand(sysutil.RunOptions{
+		Timeout: timeout,
+		Dir:     p.baseDir,
+		Logger:  p.logger,
+		Stdout:  p.logWriter,
+		Stderr:  p.logWriter,
+		Env:     p.env,
+	}, p.path, append(append([]string{}, p.global...), args...)...)
+}
+
+// imageExists reports whether image is in the store.
+func (p *podmanCLI) imageExists(image string) bool {
+	_, err := p.run(time.Minute, "image", "exists", image)
+	return err == nil
+}
@@ -7,34 +7,50 @@ import (
 	"strings"
 	"time"
 
+	"velocloud-cloudinit-builder/internal/config"
 	"velocloud-cloudinit-builder/internal/fsutil"
 	"velocloud-cloudinit-builder/internal/sysutil"
 )
 
-const (
-	podmanMachineName   = "cloudinit-builder"
-	machineInitTimeout  = 10 * time.Minute
-	machineStartTimeout = 3 * time.Minute
-)
-
-// EnsurePodmanMachine makes sure a dedicated podman machine exists and is running.
+// EnsurePodmanMachine makes sure the podman machine configured in m exists and is running.
 // It returns the machine name and the environment variables to be used for podman commands.
-func EnsurePodmanMachine(baseDir, podmanPath string, logWriter io.Writer, logger sysutil.Logger) (string, []string, error) {
+func EnsurePodmanMachine(baseDir, podmanPath string, m config.Deps, logWriter io.Writer, logger sysutil.Logger) (string, []string, error) {
 	env, err := podmanEnv(baseDir)
 	if err != nil {
 		return "", nil, err
 	}
 
-	if err := ensureMachineExists(baseDir, podmanPath, env, logWriter, logger); err != nil {
+	if err := ensureMachineExists(baseDir, podmanPath, m, env, logWriter, logger); err != nil {
 		return "", nil, err
 	}
-	if err := ensureMachineRunning(baseDir, podmanPath, env, logWriter, logger); err != nil {
+	if err := ensureMachineRunning(baseDir, podmanPath, m, env, logWriter, logger); err != nil {
 		return "", nil, err
 	}
-	if err := ensureDefaultConnection(baseDir, podmanPath, env, logWriter, logger); err != nil {
+	if err := ensureDefaultConnection(baseDir, podmanPath, m, env, logWriter, logger); err != nil {
 		return "", nil, err
 	}
-	return podmanMachineName, env, nil
+	return m.PodmanMachine, env, nil
+}
+
+// PodmanMachineState returns the state of the podman machine configured in
+// m, such as "running" or "stopped", or "missing" when it was never created.
+func PodmanMachineState(baseDir, podmanPath string, m config.Deps) (string, error) {
+	env, err := podmanEnv(baseDir)
+	if err != nil {
+		return "", err
+	}
+	result, err := sysutil.RunCommand(sysutil.RunOptions{
+		Timeout: 30 * time.Second,
+		Dir:     baseDir,
+		Env:     env,
+	}, podmanPath, "machine", "inspect", m.PodmanMachine, "--format", "{{.State}}")
+	if err != nil {
+		if machineMissing(err, result) {
+			return "missing", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(result.Stdout), nil
 }
 
 func podmanEnv(baseDir string) ([]string, error) {
@@ -57,16 +73,16 @@ func podmanEnv(baseDir string) ([]string, error) {
 	}, nil
 }
 
-func ensureMachineExists(baseDir, podmanPath string, env []string, logWriter io.Writer, logger sysutil.Logger) error {
+func ensureMachineExists(baseDir, podmanPath string, m config.Deps, env []string, logWriter io.Writer, logger sysutil.Logger) error {
 	opts := sysutil.RunOptions{
-		Timeout: machineStartTimeout,
+		Timeout: m.MachineStartTimeout,
 		Dir:     baseDir,
 		Logger:  logger,
 		Stdout:  logWriter,
 		Stderr:  logWriter,
 		Env:     env,
 	}
-	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "inspect", podmanMachineName)
+	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "inspect", m.PodmanMachine)
 	if err == nil {
 		return nil
 	}
@@ -75,19 +91,19 @@ func ensureMachineExists(baseDir, podmanPath string, env []string, logWriter io.
 	}
 
 	if logger != nil {
-		logger.Printf("initializing podman machine %s", podmanMachineName)
+		logger.Printf("initializing podman machine %s", m.PodmanMachine)
 	}
-	opts.Timeout = machineInitTimeout
-	if cleanupErr := cleanupMachineConnection(baseDir, podmanPath, env, logWriter, logger); cleanupErr != nil && logger != nil {
+	opts.Timeout = m.MachineInitTimeout
+	if cleanupErr := cleanupMachineConnection(baseDir, podmanPath, m, env, logWriter, logger); cleanupErr != nil && logger != nil {
 		logger.Printf("warning: failed to clean stale connection: %v", cleanupErr)
 	}
-	if _, err = sysutil.RunCommand(opts, podmanPath, "machine", "init", podmanMachineName, "--now"); err != nil {
+	if _, err = sysutil.RunCommand(opts, podmanPath, "machine", "init", m.PodmanMachine, "--now"); err != nil {
 		errLower := strings.ToLower(err.Error())
 		if strings.Contains(errLower, "connection") && strings.Contains(errLower, "already exists") {
-			if cleanupErr := cleanupMachineConnection(baseDir, podmanPath, env, logWriter, logger); cleanupErr != nil && logger != nil {
+			if cleanupErr := cleanupMachineConnection(baseDir, podmanPath, m, env, logWriter, logger); cleanupErr != nil && logger != nil {
 				logger.Printf("warning: failed to clean stale connection: %v", cleanupErr)
 			}
-			if _, retryErr := sysutil.RunCommand(opts, podmanPath, "machine", "init", podmanMachineName, "--now"); retryErr != nil {
+			if _, retryErr := sysutil.RunCommand(opts, podmanPath, "machine", "init", m.PodmanMachine, "--now"); retryErr != nil {
 				return fmt.Errorf("podman machine init after cleanup: %w", retryErr)
 			}
 			return nil
@@ -97,8 +113,8 @@ func ensureMachineExists(baseDir, podmanPath string, env []string, logWriter io.
 	return nil
 }
 
-func ensureMachineRunning(baseDir, podmanPath string, env []string, logWriter io.Writer, logger sysutil.Logger) error {
-	state, err := machineState(baseDir, podmanPath, env, logWriter, logger)
+func ensureMachineRunning(baseDir, podmanPath string, m config.Deps, env []string, logWriter io.Writer, logger sysutil.Logger) error {
+	state, err := machineState(baseDir, podmanPath, m, env, logWriter, logger)
 	if err != nil {
 		return err
 	}
@@ -106,23 +122,23 @@ func ensureMachineRunning(baseDir, podmanPath string, env []string, logWriter io
 		return nil
 	}
 	if logger != nil {
-		logger.Printf("starting podman machine %s", podmanMachineName)
+		logger.Printf("starting podman machine %s", m.PodmanMachine)
 	}
 	opts := sysutil.RunOptions{
-		Timeout: machineStartTimeout,
+		Timeout: m.MachineStartTimeout,
 		Dir:     baseDir,
 		Logger:  logger,
 		Stdout:  logWriter,
 		Stderr:  logWriter,
 		Env:     env,
 	}
-	if _, err := sysutil.RunCommand(opts, podmanPath, "machine", "start", podmanMachineName); err != nil {
+	if _, err := sysutil.RunCommand(opts, podmanPath, "machine", "start", m.PodmanMachine); err != nil {
 		return fmt.Errorf("podman machine start: %w", err)
 	}
 	return nil
 }
 
-func ensureDefaultConnection(baseDir, podmanPath string, env []string, logWriter io.Writer, logger sysutil.Logger) error {
+func ensureDefaultConnection(baseDir, podmanPath string, m config.Deps, env []string, logWriter io.Writer, logger sysutil.Logger) error {
 	opts := sysutil.RunOptions{
 		Timeout: 30 * time.Second,
 		Dir:     baseDir,
@@ -131,7 +147,7 @@ func ensureDefaultConnection(baseDir, podmanPath string, env []string, logWriter
 		Stderr:  logWriter,
 		Env:     env,
 	}
-	if _, err := sysutil.RunCommand(opts, podmanPath, "system", "connection", "default", podmanMachineName); err != nil {
+	if _, err := sysutil.RunCommand(opts, podmanPath, "system", "connection", "default", m.PodmanMachine); err != nil {
 		if !strings.Contains(err.Error(), "already default") {
 			return fmt.Errorf("set podman connection default: %w", err)
 		}
@@ -139,7 +155,7 @@ func ensureDefaultConnection(baseDir, podmanPath string, env []string, logWriter
 	return nil
 }
 
-func machineState(baseDir, podmanPath string, env []string, logWriter io.Writer, logger sysutil.Logger) (string, error) {
+func machineState(baseDir, podmanPath string, m config.Deps, env []string, logWriter io.Writer, logger sysutil.Logger) (string, error) {
 	opts := sysutil.RunOptions{
 		Timeout: 30 * time.Second,
 		Dir:     baseDir,
@@ -148,7 +164,7 @@ func machineState(baseDir, podmanPath string, env []string, logWriter io.Writer,
 		Stderr:  logWriter,
 		Env:     env,
 	}
-	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "inspect", podmanMachineName, "--format", "{{.State}}")
+	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "inspect", m.PodmanMachine, "--format", "{{.State}}")
 	if err != nil {
 		return "", fmt.Errorf("podman machine inspect: %w", err)
 	}
@@ -178,8 +194,8 @@ func machineMissing(err error, result *sysutil.RunResult) bool {
 	return false
 }
 
-func cleanupMachineConnection(baseDir, podmanPath string, env []string, logWriter io.Writer, logger sysutil.Logger) error {
-	names := []string{podmanMachineName, podmanMachineName + "-root"}
+func cleanupMachineConnection(baseDir, podmanPath string, m config.Deps, env []string, logWriter io.Writer, logger sysutil.Logger) error {
+	names := []string{m.PodmanMachine, m.PodmanMachine + "-root"}
 	for _, name := range names {
 		opts := sysutil.RunOptions{
 			Timeout: 30 * time.Second,
@@ -205,19 +221,16 @@ func cleanupMachineConnection(baseDir, podmanPath string, env []string, logWrite
 }
 
 // StopPodmanMachine stops the running podman machine if it exists.
-func StopPodmanMachine(baseDir, podmanPath, machineName string, env []string, logWriter io.Writer, logger sysutil.Logger) error {
-	if machineName == "" {
-		machineName = podmanMachineName
-	}
+func StopPodmanMachine(baseDir, podmanPath string, m config.Deps, env []string, logWriter io.Writer, logger sysutil.Logger) error {
 	opts := sysutil.RunOptions{
-		Timeout: machineStartTimeout,
+		Timeout: m.MachineStartTimeout,
 		Dir:     baseDir,
 		Logger:  logger,
 		Stdout:  logWriter,
 		Stderr:  logWriter,
 		Env:     env,
 	}
-	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "stop", machineName)
+	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "stop", m.PodmanMachine)
 	if err != nil {
 		if machineMissing(err, result) || strings.Contains(strings.ToLower(err.Error()), "already stopped") {
 			return nil
@@ -228,28 +241,28 @@ func StopPodmanMachine(baseDir, podmanPath, machineName string, env []string, lo
 }
 
 // RemovePodmanMachine stops and removes the managed podman machine.
-func RemovePodmanMachine(baseDir, podmanPath string, logger sysutil.Logger) error {
+func RemovePodmanMachine(baseDir, podmanPath string, m config.Deps, logger sysutil.Logger) error {
 	env, err := podmanEnv(baseDir)
 	if err != nil {
 		return err
 	}
-	if err := StopPodmanMachine(baseDir, podmanPath, podmanMachineName, env, nil, logger); err != nil {
+	if err := StopPodmanMachine(baseDir, podmanPath, m, env, nil, logger); err != nil {
 		return err
 	}
 	opts := sysutil.RunOptions{
-		Timeout: machineStartTimeout,
+		Timeout: m.MachineStartTimeout,
 		Dir:     baseDir,
 		Logger:  logger,
 		Env:     env,
 	}
-	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "rm", "-f", podmanMachineName)
+	result, err := sysutil.RunCommand(opts, podmanPath, "machine", "rm", "-f", m.PodmanMachine)
 	if err != nil {
 		if machineMissing(err, result) {
 			return nil
 		}
 		return fmt.Errorf("podman machine rm: %w", err)
 	}
-	if cleanupErr := cleanupMachineConnection(baseDir, podmanPath, env, nil, logger); cleanupErr != nil && logger != nil {
+	if cleanupErr := cleanupMachineConnection(baseDir, podmanPath, m, env, nil, logger); cleanupErr != nil && logger != nil {
 		logger.Printf("warning: failed to clean connection after removal: %v", cleanupErr)
 	}
 	return nil
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package deps
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+const podmanExeName = "podman"
+
+// EnsurePodman returns the podman installed on the host. Outside Windows
+// podman runs containers natively, so nothing is downloaded and no machine
+// is needed.
+func EnsurePodman(baseDir string, d config.Deps, logger sysutil.Logger) (string, error) {
+	podmanPath, err := FindPodman(baseDir)
+	if err != nil {
+		return "", fmt.Errorf("%w; install it with the package manager of the host", err)
+	}
+	if logger != nil {
+		logger.Printf("using host podman at %s", podmanPath)
+	}
+	return podmanPath, nil
+}
+
+// FindPodman returns the host's podman without changing anything.
+func FindPodman(baseDir string) (string, error) {
+	podmanPath, err := exec.LookPath(podmanExeName)
+	if err != nil {
+		return "", fmt.Errorf("%s not found on PATH", podmanExeName)
+	}
+	return podmanPath, nil
+}
+
+// startPodman returns the host's podman. Nothing needs stopping afterwards.
+func startPodman(baseDir string, d config.Deps, logWriter io.Writer, logger sysutil.Logger) (*podmanCLI, func(), error) {
+	podmanPath, err := EnsurePodman(baseDir, d, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &podmanCLI{
+		baseDir:   baseDir,
+		path:      podmanPath,
+		logWriter: logWriter,
+		logger:    logger,
+	}, func() {}, nil
+}
+
+// seedTools does nothing: podman and QEMU come from the host, so the
+// imported archives only go to the cache for export to Windows machines.
+func seedTools(baseDir string, d config.Deps, logger sysutil.Logger) error {
+	if logger != nil {
+		logger.Printf("host podman and qemu are used on this platform; no tools to install")
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package deps
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+const podmanExeName = "podman.exe"
+
+// EnsurePodman makes sure the portable podman.exe is available under the
+// tools directory, downloading the archive pinned in the manifest on first
+// use, and returns its path.
+func EnsurePodman(baseDir string, d config.Deps, logger sysutil.Logger) (string, error) {
+	podmanDir := filepath.Join(ToolsDir(baseDir), "podman")
+	podmanExe := filepath.Join(podmanDir, podmanExeName)
+
+	exists, err := fsutil.PathExists(podmanExe)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		if err := copySupportBinaries(podmanDir); err != nil {
+			return "", err
+		}
+		if logger != nil {
+			logger.Printf("podman already present at %s", podmanExe)
+		}
+		return podmanExe, nil
+	}
+
+	if logger != nil {
+		logger.Printf("podman not found, installing portable release")
+	}
+	zipPath, err := fetchArchive(baseDir, "podman", d, logger)
+	if err != nil {
+		return "", err
+	}
+
+	if logger != nil {
+		logger.Printf("extracting podman archive %s", zipPath)
+	}
+	if err := fsutil.RemoveIfExists(podmanDir); err != nil {
+		return "", err
+	}
+	if err := fsutil.EnsureDir(podmanDir); err != nil {
+		return "", err
+	}
+	if err := extractZip(zipPath, podmanDir); err != nil {
+		return "", err
+	}
+	if err := placePodmanExecutable(podmanDir); err != nil {
+		return "", err
+	}
+	if err := copySupportBinaries(podmanDir); err != nil {
+		return "", err
+	}
+	if logger != nil {
+		logger.Printf("podman ready at %s", podmanExe)
+	}
+	return podmanExe, nil
+}
+
+func placePodmanExecutable(podmanDir string) error {
+	return copyBinaryIfNeeded(podmanDir, podmanExeName)
+}
+
+func copySupportBinaries(podmanDir string) error {
+	for _, name := range []string{"win-sshproxy.exe", "gvproxy.exe"} {
+		if err := copyBinaryIfNeeded(podmanDir, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyBinaryIfNeeded(rootDir, binaryName string) error {
+	target := filepath.Join(rootDir, binaryName)
+	exists, err := fsutil.PathExists(target)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	var found string
+	err = filepath.WalkDir(rootDir, func(path string, d os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.EqualFold(d.Name(), binaryName) {
+			found = path
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if found == "" {
+		return fmt.Errorf("%s not found after extraction", binaryName)
+	}
+	in, err := os.Open(found)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// FindPodman returns the portable podman.exe if it has been installed.
+func FindPodman(baseDir string) (string, error) {
+	podmanExe := filepath.Join(ToolsDir(baseDir), "podman", podmanExeName)
+	exists, err := fsutil.PathExists(podmanExe)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("portable %s is not installed in %s", podmanExeName, filepath.Dir(podmanExe))
+	}
+	return podmanExe, nil
+}
+
+// startPodman prepares podman and the managed machine. The returned function
+// stops the machine again.
+func startPodman(baseDir string, d config.Deps, logWriter io.Writer, logger sysutil.Logger) (*podmanCLI, func(), error) {
+	podmanPath, err := EnsurePodman(baseDir, d, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	machineName, env, err := EnsurePodmanMachine(baseDir, podmanPath, d, logWriter, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := func() {
+		if err := StopPodmanMachine(baseDir, podmanPath, d, env, logWriter, logger); err != nil && logger != nil {
+			logger.Printf("warning: failed to stop podman machine: %v", err)
+		}
+	}
+	return &podmanCLI{
+		baseDir:   baseDir,
+		path:      podmanPath,
+		env:       env,
+		global:    []string{"--connection", machineName},
+		logWriter: logWriter,
+		logger:    logger,
+	}, stop, nil
+}
+
+// seedTools extracts the cached podman and QEMU archives into the tools
+// directory.
+func seedTools(baseDir string, d config.Deps, logger sysutil.Logger) error {
+	if _, err := EnsurePodman(baseDir, d, logger); err != nil {
+		return err
+	}
+	_, err := ensureQEMU(baseDir, d, logger)
+	return err
+}
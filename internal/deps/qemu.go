@@ -1,97 +1,63 @@
 package deps
 
 import (
-	"errors"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io/fs"
 	"path/filepath"
-	"strings"
 
+	"velocloud-cloudinit-builder/internal/config"
 	"velocloud-cloudinit-builder/internal/fsutil"
 	"velocloud-cloudinit-builder/internal/sysutil"
 )
 
-var errStopWalk = errors.New("qemu-stop-walk")
-
-const (
-	qemuVersionTag = "20240822"
-	qemuZipName    = "qemu-w64-portable-20240822.zip"
-	qemuZipURL     = "https://github.com/dirkarnez/qemu-portable/releases/download/20240822/qemu-w64-portable-20240822.zip"
-	qemuExeName    = "qemu-system-x86_64.exe"
-)
-
-// EnsureQEMU ensures that a portable QEMU build is available locally and returns the absolute executable path.
-func EnsureQEMU(baseDir string, logger sysutil.Logger) (string, error) {
-	qemuDir := filepath.Join(baseDir, "tools", "qemu")
-	if err := fsutil.EnsureDir(qemuDir); err != nil {
-		return "", err
+// TestVMName returns the QEMU -name that the VM test of the workspace baseDir
+// gives its guest, so that uninstall stops only that workspace's VMs.
+func TestVMName(baseDir string) string {
+	if abs, err := filepath.Abs(baseDir); err == nil {
+		baseDir = abs
 	}
+	sum := sha256.Sum256([]byte(filepath.Clean(baseDir)))
+	return "cloudinit-builder-test-" + hex.EncodeToString(sum[:6])
+}
 
-	if exe, err := findQEMUExecutable(qemuDir); err == nil {
-		if logger != nil {
-			logger.Printf("qemu already present at %s", exe)
-		}
-		return exe, nil
+// EnsureQEMU returns the absolute path of the QEMU executable. d.QEMU takes
+// precedence and is relative to baseDir; when it is empty the platform
+// default is used: a portable build under the tools directory on Windows,
+// qemu-system-x86_64 on PATH elsewhere.
+func EnsureQEMU(baseDir string, d config.Deps, logger sysutil.Logger) (string, error) {
+	if d.QEMU == "" {
+		return ensureQEMU(baseDir, d, logger)
 	}
-
-	if logger != nil {
-		logger.Printf("qemu not found, downloading portable release %s", qemuVersionTag)
-	}
-	cacheDir := filepath.Join(baseDir, "cache")
-	if err := fsutil.EnsureDir(cacheDir); err != nil {
-		return "", err
-	}
-	zipPath := filepath.Join(cacheDir, qemuZipName)
-	if err := downloadFile(qemuZipURL, zipPath, logger); err != nil {
+	qemuPath, err := configuredQEMU(baseDir, d.QEMU)
+	if err != nil {
 		return "", err
 	}
-
 	if logger != nil {
-		logger.Printf("extracting qemu archive %s", zipPath)
-	}
-	if err := fsutil.RemoveIfExists(qemuDir); err != nil {
-		return "", err
-	}
-	if err := fsutil.EnsureDir(qemuDir); err != nil {
-		return "", err
-	}
-	if err := extractZip(zipPath, qemuDir); err != nil {
-		return "", err
+		logger.Printf("using configured qemu at %s", qemuPath)
 	}
+	return qemuPath, nil
+}
 
-	exe, err := findQEMUExecutable(qemuDir)
-	if err != nil {
-		return "", err
-	}
-	if logger != nil {
-		logger.Printf("qemu ready at %s", exe)
+// FindQEMU is EnsureQEMU without the download: it reports where QEMU is, or
+// why it is not available yet.
+func FindQEMU(baseDir string, d config.Deps) (string, error) {
+	if d.QEMU != "" {
+		return configuredQEMU(baseDir, d.QEMU)
 	}
-	return exe, nil
+	return findQEMU(baseDir)
 }
 
-func findQEMUExecutable(root string) (string, error) {
-	var found string
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-		if !d.Type().IsRegular() {
-			return nil
-		}
-		if strings.EqualFold(d.Name(), qemuExeName) {
-			found = path
-			return errStopWalk
-		}
-		return nil
-	})
-	if err != nil && !errors.Is(err, errStopWalk) {
+func configuredQEMU(baseDir, qemuPath string) (string, error) {
+	if !filepath.IsAbs(qemuPath) {
+		qemuPath = filepath.Join(baseDir, qemuPath)
+	}
+	exists, err := fsutil.PathExists(qemuPath)
+	if err != nil {
 		return "", err
 	}
-	if found == "" {
-		return "", fmt.Errorf("%s not found inside %s", qemuExeName, root)
+	if !exists {
+		return "", fmt.Errorf("configured qemu %s does not exist", qemuPath)
 	}
-	return found, nil
+	return qemuPath, nil
 }
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package deps
+
+import (
+	"fmt"
+	"os/exec"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+const qemuExeName = "qemu-system-x86_64"
+
+// ensureQEMU looks up the QEMU installed on the host.
+func ensureQEMU(baseDir string, d config.Deps, logger sysutil.Logger) (string, error) {
+	exe, err := findQEMU(baseDir)
+	if err != nil {
+		return "", err
+	}
+	if logger != nil {
+		logger.Printf("using host qemu at %s", exe)
+	}
+	return exe, nil
+}
+
+func findQEMU(baseDir string) (string, error) {
+	exe, err := exec.LookPath(qemuExeName)
+	if err != nil {
+		return "", fmt.Errorf("%s not found on PATH; install QEMU or set deps.qemu", qemuExeName)
+	}
+	return exe, nil
+}
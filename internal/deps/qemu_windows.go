@@ -0,0 +1,95 @@
+package deps
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+var errStopWalk = errors.New("qemu-stop-walk")
+
+const qemuExeName = "qemu-system-x86_64.exe"
+
+// ensureQEMU ensures that a portable QEMU build is available under the tools
+// directory, downloading the archive pinned in the manifest on first use, and
+// returns the executable path.
+func ensureQEMU(baseDir string, d config.Deps, logger sysutil.Logger) (string, error) {
+	qemuDir := filepath.Join(ToolsDir(baseDir), "qemu")
+	if err := fsutil.EnsureDir(qemuDir); err != nil {
+		return "", err
+	}
+
+	if exe, err := findQEMUExecutable(qemuDir); err == nil {
+		if logger != nil {
+			logger.Printf("qemu already present at %s", exe)
+		}
+		return exe, nil
+	}
+
+	if logger != nil {
+		logger.Printf("qemu not found, installing portable release")
+	}
+	zipPath, err := fetchArchive(baseDir, "qemu", d, logger)
+	if err != nil {
+		return "", err
+	}
+
+	if logger != nil {
+		logger.Printf("extracting qemu archive %s", zipPath)
+	}
+	if err := fsutil.RemoveIfExists(qemuDir); err != nil {
+		return "", err
+	}
+	if err := fsutil.EnsureDir(qemuDir); err != nil {
+		return "", err
+	}
+	if err := extractZip(zipPath, qemuDir); err != nil {
+		return "", err
+	}
+
+	exe, err := findQEMUExecutable(qemuDir)
+	if err != nil {
+		return "", err
+	}
+	if logger != nil {
+		logger.Printf("qemu ready at %s", exe)
+	}
+	return exe, nil
+}
+
+func findQEMU(baseDir string) (string, error) {
+	return findQEMUExecutable(filepath.Join(ToolsDir(baseDir), "qemu"))
+}
+
+func findQEMUExecutable(root string) (string, error) {
+	var found string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if strings.EqualFold(d.Name(), qemuExeName) {
+			found = path
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("%s not found inside %s", qemuExeName, root)
+	}
+	return found, nil
+}
@@ -0,0 +1,124 @@
+//go:build !windows
+
+package deps
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+const helperStopTimeout = 5 * time.Second
+
+// stopHelpers sends SIGTERM to the test VMs of baseDir still running and
+// SIGKILL to those that outlive helperStopTimeout. Podman is the host's own
+// installation outside Windows, so only those VMs are stopped.
+func stopHelpers(baseDir string, logger sysutil.Logger) error {
+	pids, err := findProcesses(qemuExeName, TestVMName(baseDir))
+	if err != nil {
+		return err
+	}
+	var aggregate error
+	var running []int
+	for _, pid := range pids {
+		if logger != nil {
+			logger.Printf("sending SIGTERM to %s (pid %d)", qemuExeName, pid)
+		}
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			if !errors.Is(err, syscall.ESRCH) {
+				aggregate = errors.Join(aggregate, fmt.Errorf("signal pid %d: %w", pid, err))
+			}
+			continue
+		}
+		running = append(running, pid)
+	}
+	deadline := time.Now().Add(helperStopTimeout)
+	for len(running) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		running = alive(running)
+	}
+	for _, pid := range running {
+		if logger != nil {
+			logger.Printf("sending SIGKILL to %s (pid %d)", qemuExeName, pid)
+		}
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			aggregate = errors.Join(aggregate, fmt.Errorf("kill pid %d: %w", pid, err))
+		}
+	}
+	return aggregate
+}
+
+// findProcesses returns the processes whose executable is named exeName and
+// whose QEMU -name is exactly vmName. Hosts without /proc have none.
+func findProcesses(exeName, vmName string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	self := os.Getpid()
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+		args := bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0})
+		if filepath.Base(string(args[0])) != exeName || !hasVMName(args, vmName) {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// hasVMName reports whether args pass -name with the guest name vmName, as
+// in "-name <vmName>,process=<vmName>".
+func hasVMName(args [][]byte, vmName string) bool {
+	for i := 0; i+1 < len(args); i++ {
+		if string(args[i]) != "-name" {
+			continue
+		}
+		name, _, _ := strings.Cut(string(args[i+1]), ",")
+		if strings.TrimPrefix(name, "guest=") == vmName {
+			return true
+		}
+	}
+	return false
+}
+
+func alive(pids []int) []int {
+	var out []int
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, 0); err == nil || errors.Is(err, syscall.EPERM) {
+			out = append(out, pid)
+		}
+	}
+	return out
+}
+
+// selfDeleteBinary removes binaryPath directly: a running executable can be
+// unlinked outside Windows.
+func selfDeleteBinary(baseDir, binaryPath string, logger sysutil.Logger) error {
+	if err := os.Remove(binaryPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove %s: %w", binaryPath, err)
+	}
+	if logger != nil {
+		logger.Printf("removed %s", binaryPath)
+	}
+	return nil
+}
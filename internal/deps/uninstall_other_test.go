@@ -0,0 +1,30 @@
+//go:build !windows
+
+package deps
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHasVMName(t *testing.T) {
+	const name = "cloudinit-builder-test-0123456789ab"
+	tests := []struct {
+		cmdline string
+		want    bool
+	}{
+		{cmdline: "qemu-system-x86_64 -name " + name + ",process=" + name + " -m 4096", want: true},
+		{cmdline: "qemu-system-x86_64 -m 4096 -name guest=" + name, want: true},
+		{cmdline: "qemu-system-x86_64 -name cloudinit-builder-test-ba9876543210,process=x -m 4096"},
+		{cmdline: "qemu-system-x86_64 -name cloudinit-builder-test,process=cloudinit-builder-test"},
+		{cmdline: "qemu-system-x86_64 -name " + name + "-other"},
+		{cmdline: "qemu-system-x86_64 -drive file=/tmp/" + name + ".qcow2"},
+		{cmdline: "qemu-system-x86_64 -name"},
+	}
+	for _, tt := range tests {
+		args := bytes.Split([]byte(tt.cmdline), []byte(" "))
+		if got := hasVMName(args, name); got != tt.want {
+			t.Errorf("hasVMName(%q) = %v, want %v", tt.cmdline, got, tt.want)
+		}
+	}
+}
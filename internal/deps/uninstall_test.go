@@ -0,0 +1,66 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"velocloud-cloudinit-builder/internal/config"
+)
+
+type recordingLogger struct{ lines []string }
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestPerformUninstallSharedDirs(t *testing.T) {
+	baseDir := t.TempDir()
+	shared := t.TempDir()
+	tools := filepath.Join(shared, "tools")
+	cache := filepath.Join(shared, "cache")
+	SetSharedDirs(tools, cache)
+	t.Cleanup(func() { SetSharedDirs("", "") })
+
+	podmanExe := filepath.Join(tools, "podman", podmanExeName)
+	for _, path := range []string{podmanExe, filepath.Join(cache, "qemu.zip"), filepath.Join(baseDir, "images", "cloud-init.iso")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := &recordingLogger{}
+	if err := PerformUninstall(baseDir, false, "", config.Default().Deps, logger); err != nil {
+		t.Fatal(err)
+	}
+	log := strings.Join(logger.lines, "\n")
+	if strings.Contains(log, "running command") {
+		t.Errorf("uninstall ran commands with a shared tools directory:\n%s", log)
+	}
+	for _, path := range []string{podmanExe, filepath.Join(cache, "qemu.zip")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("shared file removed: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "images")); !os.IsNotExist(err) {
+		t.Errorf("images/ was kept: %v", err)
+	}
+}
+
+func TestTestVMName(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	if TestVMName(a) != TestVMName(a+string(filepath.Separator)) {
+		t.Error("TestVMName depends on a trailing separator")
+	}
+	if TestVMName(a) == TestVMName(b) {
+		t.Errorf("workspaces %s and %s share the VM name %s", a, b, TestVMName(a))
+	}
+	if !strings.HasPrefix(TestVMName(a), "cloudinit-builder-test-") {
+		t.Errorf("TestVMName() = %q", TestVMName(a))
+	}
+}
@@ -0,0 +1,69 @@
+package deps
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// stopHelpers terminates the portable podman and QEMU processes so their
+// files can be removed.
+func stopHelpers(baseDir string, logger sysutil.Logger) error {
+	return killProcesses(baseDir, logger, podmanExeName, qemuExeName)
+}
+
+func killProcesses(baseDir string, logger sysutil.Logger, processNames ...string) error {
+	var aggregate error
+	for _, name := range processNames {
+		result, err := sysutil.RunCommand(sysutil.RunOptions{
+			Timeout: 5 * time.Second,
+			Dir:     baseDir,
+			Logger:  logger,
+		}, "taskkill", "/IM", name, "/T", "/F")
+		if err != nil {
+			if result != nil && result.ExitCode == 128 {
+				continue
+			}
+			if logger != nil {
+				logger.Printf("warning: failed to kill %s: %v", name, err)
+			}
+			aggregate = errors.Join(aggregate, fmt.Errorf("kill %s: %w", name, err))
+		}
+	}
+	return aggregate
+}
+
+// selfDeleteBinary deletes binaryPath from a detached batch script, since
+// Windows does not allow removing a running executable.
+func selfDeleteBinary(baseDir, binaryPath string, logger sysutil.Logger) error {
+	scriptPath := filepath.Join(baseDir, fmt.Sprintf("cleanup-%d.bat", time.Now().Unix()))
+	return scheduleSelfDelete(scriptPath, binaryPath, logger)
+}
+
+func scheduleSelfDelete(scriptPath, binaryPath string, logger sysutil.Logger) error {
+	scriptContent := fmt.Sprintf(`@echo off
+timeout /t 2 >nul
+del "%s"
+del "%%~f0"
+`, binaryPath)
+	if err := os.WriteFile(scriptPath, []byte(scriptContent), 0o644); err != nil {
+		return err
+	}
+	if logger != nil {
+		logger.Printf("created self-delete script %s", scriptPath)
+	}
+	_, err := sysutil.RunCommand(sysutil.RunOptions{
+		Timeout: 2 * time.Second,
+	}, "cmd.exe", "/C", "start", "", scriptPath)
+	if err != nil {
+		return fmt.Errorf("launch cleanup script: %w", err)
+	}
+	if logger != nil {
+		logger.Printf("scheduled self-delete via %s", scriptPath)
+	}
+	return nil
+}
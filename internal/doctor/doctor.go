@@ -0,0 +1,321 @@
+// Package doctor inspects a workspace and the host for the problems that
+// usually surface only deep inside a build or VM test log.
+package doctor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/builder"
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/deps"
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/sysutil"
+	"velocloud-cloudinit-builder/internal/vmtest"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+// Check outcomes, from best to worst.
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Check is one line of the report.
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+	// Hint tells how to fix a warning or failure.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Report is the result of Run.
+type Report struct {
+	Checks   []Check `json:"checks"`
+	Passed   int     `json:"passed"`
+	Warnings int     `json:"warnings"`
+	Failed   int     `json:"failed"`
+}
+
+const (
+	minFreeSpace  = 1 << 30
+	warnFreeSpace = 5 << 30
+)
+
+// Run checks baseDir with cfg. cfgErr is the error of loading the
+// configuration file, if any; cfg then holds the defaults. Run neither
+// downloads tools nor creates or starts the podman machine.
+func Run(baseDir string, cfg *config.Config, cfgErr error) Report {
+	checks := []Check{
+		checkConfig(cfgErr),
+		checkLayout(baseDir),
+		checkTemplates(baseDir, cfg),
+		checkBackend(cfg),
+	}
+	podman := checkPodman(baseDir)
+	checks = append(checks, podman)
+	if runtime.GOOS == "windows" && podman.Status == Pass {
+		checks = append(checks, checkPodmanMachine(baseDir, cfg))
+	}
+	checks = append(checks, checkQEMU(baseDir, cfg))
+	for _, name := range config.ManifestNames {
+		checks = append(checks, checkCache(baseDir, name, cfg))
+	}
+	checks = append(checks,
+		checkAccelerator(vmtest.Accel(cfg.Test.Accel)),
+		checkDiskSpace(baseDir, cfg),
+		checkBaseImage(baseDir, cfg),
+	)
+
+	r := Report{Checks: checks}
+	for _, c := range checks {
+		switch c.Status {
+		case Pass:
+			r.Passed++
+		case Warn:
+			r.Warnings++
+		case Fail:
+			r.Failed++
+		}
+	}
+	return r
+}
+
+// WriteTable prints r as a table with the hints below their checks.
+func (r Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tDETAIL")
+	for _, c := range r.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Status, c.Name, c.Detail)
+		if c.Hint != "" && c.Status != Pass {
+			fmt.Fprintf(tw, "\t\t-> %s\n", c.Hint)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d warning(s), %d failed\n", r.Passed, r.Warnings, r.Failed)
+	return err
+}
+
+// WriteJSON prints r as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func checkConfig(cfgErr error) Check {
+	c := Check{Name: "config", Status: Pass, Detail: config.FileName + " is valid"}
+	if cfgErr != nil {
+		c.Status = Fail
+		c.Detail = strings.ReplaceAll(cfgErr.Error(), "\n", "; ")
+		c.Hint = "fix the listed keys, or rewrite the defaults with config init --force"
+	}
+	return c
+}
+
+func checkLayout(baseDir string) Check {
+	var missing []string
+	for _, dir := range deps.LayoutDirs(baseDir) {
+		if exists, _ := fsutil.PathExists(dir); !exists {
+			missing = append(missing, relPath(baseDir, dir))
+		}
+	}
+	if len(missing) > 0 {
+		return Check{Name: "layout", Status: Warn, Detail: "missing " + strings.Join(missing, ", "), Hint: "run build once; it creates the workspace layout"}
+	}
+	return Check{Name: "layout", Status: Pass, Detail: "workspace layout complete in " + baseDir}
+}
+
+func checkTemplates(baseDir string, cfg *config.Config) Check {
+	dir := builder.TemplateDir(baseDir, builder.Options{Config: cfg})
+	var missing []string
+	for _, name := range []string{"user-data.txt", "meta-data.txt"} {
+		if exists, _ := fsutil.PathExists(filepath.Join(dir, name)); !exists {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return Check{Name: "templates", Status: Warn, Detail: fmt.Sprintf("%s missing in %s", strings.Join(missing, ", "), relPath(baseDir, dir)), Hint: "build writes default templates when they are missing"}
+	}
+	return Check{Name: "templates", Status: Pass, Detail: "user-data.txt and meta-data.txt in " + relPath(baseDir, dir)}
+}
+
+func checkBackend(cfg *config.Config) Check {
+	name, err := builder.CheckBackend(cfg)
+	if err != nil {
+		return Check{Name: "backend", Status: Fail, Detail: fmt.Sprintf("%s backend: %v", name, err), Hint: "install what the backend needs, or use build.backend: auto"}
+	}
+	return Check{Name: "backend", Status: Pass, Detail: name + " backend is available"}
+}
+
+func checkPodman(baseDir string) Check {
+	podmanPath, err := deps.FindPodman(baseDir)
+	if err != nil {
+		hint := "only needed for the container backend and deps export/import; install podman from the distribution"
+		if runtime.GOOS == "windows" {
+			hint = "only needed for the podman-machine backend; it is downloaded on first use or installed by deps import"
+		}
+		return Check{Name: "podman", Status: Warn, Detail: err.Error(), Hint: hint}
+	}
+	return Check{Name: "podman", Status: Pass, Detail: toolVersion(podmanPath)}
+}
+
+func checkPodmanMachine(baseDir string, cfg *config.Config) Check {
+	podmanPath, _ := deps.FindPodman(baseDir)
+	name := "podman machine " + cfg.Deps.PodmanMachine
+	state, err := deps.PodmanMachineState(baseDir, podmanPath, cfg.Deps)
+	switch {
+	case err != nil:
+		return Check{Name: name, Status: Fail, Detail: strings.ReplaceAll(err.Error(), "\n", "; "), Hint: "make sure WSL2 or Hyper-V is enabled; uninstall removes a broken machine"}
+	case state == "missing":
+		return Check{Name: name, Status: Warn, Detail: "not created yet", Hint: "the first podman-machine build creates it, which needs network access"}
+	default:
+		return Check{Name: name, Status: Pass, Detail: state}
+	}
+}
+
+func checkQEMU(baseDir string, cfg *config.Config) Check {
+	qemuPath, err := deps.FindQEMU(baseDir, cfg.Deps)
+	if err != nil {
+		hint := "only needed for test; install the distribution's QEMU package"
+		if runtime.GOOS == "windows" && cfg.Deps.QEMU == "" {
+			hint = "test downloads it on first use, or install it with deps import"
+		}
+		return Check{Name: "qemu", Status: Warn, Detail: err.Error(), Hint: hint}
+	}
+	return Check{Name: "qemu", Status: Pass, Detail: toolVersion(qemuPath)}
+}
+
+func checkCache(baseDir, name string, cfg *config.Config) Check {
+	c := Check{Name: "cache " + name}
+	zipPath, err := deps.CheckCachedArchive(baseDir, name, cfg.Deps)
+	switch {
+	case errors.Is(err, deps.ErrNotCached):
+		c.Status, c.Detail = Pass, "not downloaded"
+		if exists, _ := fsutil.PathExists(zipPath + ".tmp"); exists {
+			c.Status, c.Detail = Warn, "partial download "+relPath(baseDir, zipPath+".tmp")
+			c.Hint = "the next download resumes it"
+		}
+		if errors.Is(err, deps.ErrNotPinned) {
+			c.Status = Warn
+			c.Detail += "; no SHA-256 pinned, downloads are refused"
+			c.Hint = fmt.Sprintf("set deps.manifest.%s.sha256 to the digest published with the release", name)
+		}
+	case errors.Is(err, deps.ErrNotPinned):
+		c.Status, c.Detail = Warn, relPath(baseDir, zipPath)+" cannot be verified: no SHA-256 pinned"
+		c.Hint = fmt.Sprintf("set deps.manifest.%s.sha256", name)
+	case err != nil:
+		c.Status, c.Detail = Fail, err.Error()
+		c.Hint = fmt.Sprintf("delete %s; it is downloaded again on next use", relPath(baseDir, zipPath))
+	default:
+		c.Status, c.Detail = Pass, relPath(baseDir, zipPath)+" matches the manifest"
+	}
+	return c
+}
+
+func checkDiskSpace(baseDir string, cfg *config.Config) Check {
+	free, err := sysutil.FreeSpace(baseDir)
+	if err != nil {
+		return Check{Name: "disk space", Status: Warn, Detail: "cannot read free space: " + err.Error()}
+	}
+	need := uint64(minFreeSpace)
+	if info, err := os.Stat(diskPath(baseDir, cfg)); err == nil {
+		need += uint64(info.Size())
+	}
+	c := Check{Name: "disk space", Status: Pass, Detail: fmt.Sprintf("%s free", gib(free))}
+	switch {
+	case free < need:
+		c.Status = Fail
+		c.Detail += fmt.Sprintf(", test needs %s to clone the base disk", gib(need))
+		c.Hint = "free up space or move the workspace with --base-dir"
+	case free < warnFreeSpace:
+		c.Status = Warn
+		c.Hint = "downloads and disk clones may run out of space"
+	}
+	return c
+}
+
+// checkBaseImage reads the qcow2 header of test.disk.
+func checkBaseImage(baseDir string, cfg *config.Config) Check {
+	path := diskPath(baseDir, cfg)
+	c := Check{Name: "base image", Status: Pass}
+	f, err := os.Open(path)
+	if err != nil {
+		c.Status, c.Detail = Warn, relPath(baseDir, path)+" not found"
+		c.Hint = "copy the VeloCloud qcow2 there, or set test.disk; only test needs it"
+		return c
+	}
+	defer f.Close()
+	var header struct {
+		Magic         [4]byte
+		Version       uint32
+		BackingOffset uint64
+		BackingSize   uint32
+		ClusterBits   uint32
+		Size          uint64
+	}
+	if err := binary.Read(f, binary.BigEndian, &header); err != nil || string(header.Magic[:]) != "QFI\xfb" {
+		c.Status, c.Detail = Fail, relPath(baseDir, path)+" is not a qcow2 image"
+		c.Hint = "convert it with qemu-img convert -O qcow2"
+		return c
+	}
+	if header.Version != 2 && header.Version != 3 {
+		c.Status, c.Detail = Fail, fmt.Sprintf("%s has unsupported qcow2 version %d", relPath(baseDir, path), header.Version)
+		return c
+	}
+	c.Detail = fmt.Sprintf("%s: qcow2 v%d, %s virtual", relPath(baseDir, path), header.Version, gib(header.Size))
+	if header.BackingOffset != 0 {
+		c.Status = Warn
+		c.Detail += ", has a backing file"
+		c.Hint = "the clone made by test keeps a relative backing path; flatten it with qemu-img convert"
+	}
+	return c
+}
+
+func diskPath(baseDir string, cfg *config.Config) string {
+	if filepath.IsAbs(cfg.Test.Disk) {
+		return cfg.Test.Disk
+	}
+	return filepath.Join(baseDir, cfg.Test.Disk)
+}
+
+// toolVersion returns the path of a tool and the first line of its
+// --version output.
+func toolVersion(path string) string {
+	result, err := sysutil.RunCommand(sysutil.RunOptions{Timeout: 15 * time.Second}, path, "--version")
+	if err != nil || result == nil {
+		return path
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(result.Stdout), "\n")
+	if line == "" {
+		return path
+	}
+	return fmt.Sprintf("%s (%s)", path, strings.TrimSpace(line))
+}
+
+func gib(n uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+}
+
+func relPath(baseDir, target string) string {
+	rel, err := filepath.Rel(baseDir, target)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return target
+	}
+	return rel
+}
@@ -0,0 +1,217 @@
+package doctor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/deps"
+)
+
+// writeFile creates baseDir/rel with data.
+func writeFile(t *testing.T, baseDir, rel, data string) {
+	t.Helper()
+	path := filepath.Join(baseDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// qcow2Header returns the header of an empty qcow2 v3 image of size bytes.
+func qcow2Header(size uint64) string {
+	var buf bytes.Buffer
+	buf.WriteString("QFI\xfb")
+	_ = binary.Write(&buf, binary.BigEndian, struct {
+		Version       uint32
+		BackingOffset uint64
+		BackingSize   uint32
+		ClusterBits   uint32
+		Size          uint64
+	}{Version: 3, ClusterBits: 16, Size: size})
+	return buf.String()
+}
+
+// healthyWorkspace lays out baseDir the way a finished build and test leave
+// it, with every dependency archive cached and pinned in cfg.
+func healthyWorkspace(t *testing.T, baseDir string, cfg *config.Config) {
+	t.Helper()
+	if err := deps.EnsureBaseLayout(baseDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, baseDir, "templates/user-data.txt", "#cloud-config\n")
+	writeFile(t, baseDir, "templates/meta-data.txt", "instance-id: vce\n")
+	writeFile(t, baseDir, cfg.Test.Disk, qcow2Header(8<<30))
+	cfg.Deps.Manifest = map[string]config.Artifact{}
+	for _, name := range config.ManifestNames {
+		data := name + " archive"
+		sum := sha256.Sum256([]byte(data))
+		writeFile(t, baseDir, "cache/"+name+".zip", data)
+		cfg.Deps.Manifest[name] = config.Artifact{File: name + ".zip", SHA256: hex.EncodeToString(sum[:])}
+	}
+}
+
+func findCheck(t *testing.T, r Report, name string) Check {
+	t.Helper()
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("report has no %q check: %+v", name, r.Checks)
+	return Check{}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, baseDir string, cfg *config.Config)
+		cfgErr error
+		// want maps check names to their status and a substring of the
+		// detail.
+		want map[string][2]string
+	}{
+		{
+			name:  "healthy workspace",
+			setup: healthyWorkspace,
+			want: map[string][2]string{
+				"config":       {"pass", "is valid"},
+				"layout":       {"pass", "layout complete"},
+				"templates":    {"pass", "user-data.txt and meta-data.txt"},
+				"cache qemu":   {"pass", "matches the manifest"},
+				"cache podman": {"pass", "matches the manifest"},
+				"base image":   {"pass", "qcow2 v3, 8.0 GiB virtual"},
+			},
+		},
+		{
+			name: "missing dependency",
+			setup: func(t *testing.T, baseDir string, cfg *config.Config) {
+				healthyWorkspace(t, baseDir, cfg)
+				cfg.Deps.QEMU = "tools/qemu/missing-qemu"
+			},
+			want: map[string][2]string{
+				"qemu":       {"warn", "does not exist"},
+				"cache qemu": {"pass", "matches the manifest"},
+			},
+		},
+		{
+			name:  "empty workspace",
+			setup: func(t *testing.T, baseDir string, cfg *config.Config) {},
+			want: map[string][2]string{
+				"layout":     {"warn", "missing"},
+				"templates":  {"warn", "user-data.txt, meta-data.txt missing"},
+				"base image": {"warn", "not found"},
+			},
+		},
+		{
+			name: "unpinned dependency not cached",
+			setup: func(t *testing.T, baseDir string, cfg *config.Config) {
+				healthyWorkspace(t, baseDir, cfg)
+				cfg.Deps.Manifest["qemu"] = config.Artifact{File: "qemu-next.zip"}
+			},
+			want: map[string][2]string{
+				"cache qemu":   {"warn", "no SHA-256 pinned"},
+				"cache podman": {"pass", "matches the manifest"},
+			},
+		},
+		{
+			name: "unpinned dependency cached",
+			setup: func(t *testing.T, baseDir string, cfg *config.Config) {
+				healthyWorkspace(t, baseDir, cfg)
+				cfg.Deps.Manifest["qemu"] = config.Artifact{File: "qemu.zip"}
+			},
+			want: map[string][2]string{
+				"cache qemu": {"warn", "cannot be verified: no SHA-256 pinned"},
+			},
+		},
+		{
+			name: "corrupt cache and image",
+			setup: func(t *testing.T, baseDir string, cfg *config.Config) {
+				healthyWorkspace(t, baseDir, cfg)
+				writeFile(t, baseDir, "cache/qemu.zip", "tampered")
+				writeFile(t, baseDir, cfg.Test.Disk, "not an image")
+			},
+			want: map[string][2]string{
+				"cache qemu": {"fail", "has SHA-256"},
+				"base image": {"fail", "is not a qcow2 image"},
+			},
+		},
+		{
+			name:   "invalid config",
+			setup:  healthyWorkspace,
+			cfgErr: errors.Join(errors.New("build.format: unknown"), errors.New("test.memory: too small")),
+			want: map[string][2]string{
+				"config": {"fail", "build.format: unknown; test.memory: too small"},
+				"layout": {"pass", "layout complete"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLOUDINIT_BUILDER_TEMPLATES", "")
+			baseDir := t.TempDir()
+			cfg := config.Default()
+			tt.setup(t, baseDir, cfg)
+
+			r := Run(baseDir, cfg, tt.cfgErr)
+			for name, want := range tt.want {
+				c := findCheck(t, r, name)
+				if string(c.Status) != want[0] || !strings.Contains(c.Detail, want[1]) {
+					t.Errorf("%s = %s %q, want %s containing %q", name, c.Status, c.Detail, want[0], want[1])
+				}
+				if c.Status != Pass && c.Hint == "" {
+					t.Errorf("%s has no hint", name)
+				}
+			}
+			var passed, warnings, failed int
+			for _, c := range r.Checks {
+				switch c.Status {
+				case Pass:
+					passed++
+				case Warn:
+					warnings++
+				case Fail:
+					failed++
+				default:
+					t.Errorf("%s has status %q", c.Name, c.Status)
+				}
+			}
+			if r.Passed != passed || r.Warnings != warnings || r.Failed != failed {
+				t.Errorf("report counts %d/%d/%d, checks give %d/%d/%d", r.Passed, r.Warnings, r.Failed, passed, warnings, failed)
+			}
+		})
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	r := Report{
+		Checks: []Check{
+			{Name: "layout", Status: Pass, Detail: "complete", Hint: "not shown"},
+			{Name: "qemu", Status: Warn, Detail: "not found", Hint: "install it"},
+		},
+		Passed:   1,
+		Warnings: 1,
+	}
+	var buf bytes.Buffer
+	if err := r.WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"STATUS", "pass", "-> install it", "1 passed, 1 warning(s), 0 failed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "not shown") {
+		t.Errorf("table shows the hint of a passed check:\n%s", out)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package doctor
+
+import (
+	"os"
+	"runtime"
+)
+
+// checkAccelerator compares the configured QEMU accelerator with /dev/kvm.
+func checkAccelerator(accel string) Check {
+	c := Check{Name: "accelerator", Status: Pass}
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	kvm := err == nil
+	if kvm {
+		f.Close()
+	}
+	switch {
+	case accel == "kvm" && kvm:
+		c.Detail = "kvm, /dev/kvm is usable"
+	case accel == "kvm":
+		c.Status, c.Detail = Fail, "test.accel is kvm but /dev/kvm is not usable: "+err.Error()
+		c.Hint = "enable virtualization in the firmware, load kvm_intel or kvm_amd and join the kvm group, or set test.accel: tcg"
+	case accel == "tcg" && kvm:
+		c.Status, c.Detail = Warn, "tcg software emulation although /dev/kvm is usable"
+		c.Hint = "set test.accel: kvm for much faster VM tests"
+	case accel == "tcg" && runtime.GOOS == "linux":
+		c.Detail = "tcg software emulation, /dev/kvm is not usable"
+	default:
+		c.Detail = accel
+	}
+	return c
+}
@@ -0,0 +1,37 @@
+package doctor
+
+import (
+	"strings"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/sysutil"
+)
+
+// checkAccelerator compares the configured QEMU accelerator with whether the
+// Hyper-V hypervisor is running, which WHPX needs.
+func checkAccelerator(accel string) Check {
+	c := Check{Name: "accelerator", Status: Pass}
+	result, err := sysutil.RunCommand(sysutil.RunOptions{Timeout: 30 * time.Second},
+		"powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"(Get-CimInstance Win32_ComputerSystem).HypervisorPresent")
+	if err != nil {
+		c.Status, c.Detail = Warn, accel+", cannot query the hypervisor: "+err.Error()
+		return c
+	}
+	hypervisor := strings.EqualFold(strings.TrimSpace(result.Stdout), "true")
+	switch {
+	case accel == "whpx" && hypervisor:
+		c.Detail = "whpx, Hyper-V hypervisor is running"
+	case accel == "whpx":
+		c.Status, c.Detail = Fail, "test.accel is whpx but no hypervisor is running"
+		c.Hint = "enable Windows Hypervisor Platform in Windows features and reboot, or set test.accel: tcg"
+	case accel == "tcg" && hypervisor:
+		c.Status, c.Detail = Warn, "tcg software emulation although a hypervisor is running"
+		c.Hint = "set test.accel: whpx for faster VM tests; it needs the Windows Hypervisor Platform feature"
+	case accel == "tcg":
+		c.Detail = "tcg software emulation, no hypervisor is running"
+	default:
+		c.Detail = accel
+	}
+	return c
+}
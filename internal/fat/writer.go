@@ -0,0 +1,605 @@
+// Package fat writes small FAT16 volumes with long file names, which is all a
+// vfat cloud-init NoCloud seed disk needs.
+package fat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize      = 512
+	reservedSectors = 1
+	numFATs         = 2
+	rootEntries     = 512
+	dirEntrySize    = 32
+	// FAT16 volumes must have at least minClusters clusters, otherwise
+	// drivers treat them as FAT12.
+	minClusters = 4096
+	maxClusters = 65524
+	maxNameLen  = 255
+	lfnChars    = 13
+	oemName     = "CIBUILD "
+
+	attrVolumeID = 0x08
+	attrDir      = 0x10
+	attrArchive  = 0x20
+	attrLFN      = 0x0F
+	endOfChain   = 0xFFFF
+)
+
+// Options configures the generated volume.
+type Options struct {
+	// Label is the volume label, at most 11 characters.
+	Label string
+	// Created is used for the volume serial number and for entries without
+	// an explicit modification time. The current time is used when zero.
+	Created time.Time
+}
+
+// File describes a regular file placed into the volume.
+type File struct {
+	// Path is the slash separated location inside the volume.
+	Path string
+	// Source is read from disk when Data is nil.
+	Source  string
+	Data    []byte
+	ModTime time.Time
+}
+
+// Image collects files and serialises them as a FAT16 volume.
+type Image struct {
+	opts Options
+	root *node
+}
+
+type node struct {
+	name     string
+	dir      bool
+	parent   *node
+	children []*node
+	file     File
+	size     int64
+	modTime  time.Time
+
+	short    [11]byte
+	lfn      []uint16
+	cluster  uint32
+	clusters uint32
+}
+
+// New returns an empty volume using opts.
+func New(opts Options) (*Image, error) {
+	if len(opts.Label) > 11 {
+		return nil, fmt.Errorf("fat: volume label %q is longer than 11 characters", opts.Label)
+	}
+	if opts.Created.IsZero() {
+		opts.Created = time.Now()
+	}
+	opts.Created = opts.Created.UTC()
+	return &Image{
+		opts: opts,
+		root: &node{dir: true, modTime: opts.Created},
+	}, nil
+}
+
+// AddFile registers f, creating intermediate directories as needed.
+func (img *Image) AddFile(f File) error {
+	clean := path.Clean("/" + filepath.ToSlash(f.Path))
+	if clean == "/" {
+		return errors.New("fat: empty file path")
+	}
+	parts := strings.Split(strings.TrimPrefix(clean, "/"), "/")
+	for _, part := range parts {
+		if len(utf16.Encode([]rune(part))) > maxNameLen {
+			return fmt.Errorf("fat: name %q is too long", part)
+		}
+	}
+	parent := img.root
+	for _, part := range parts[:len(parts)-1] {
+		next := parent.child(part)
+		if next == nil {
+			next = &node{name: part, dir: true, parent: parent, modTime: img.opts.Created}
+			parent.children = append(parent.children, next)
+		} else if !next.dir {
+			return fmt.Errorf("fat: %s is a file, cannot hold %s", part, clean)
+		}
+		parent = next
+	}
+	name := parts[len(parts)-1]
+	if parent.child(name) != nil {
+		return fmt.Errorf("fat: duplicate path %s", clean)
+	}
+
+	n := &node{name: name, parent: parent, file: f, modTime: f.ModTime}
+	if f.Data != nil || f.Source == "" {
+		n.size = int64(len(f.Data))
+	} else {
+		info, err := os.Stat(f.Source)
+		if err != nil {
+			return fmt.Errorf("fat: stat %s: %w", f.Source, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("fat: %s is not a regular file", f.Source)
+		}
+		n.size = info.Size()
+		if n.modTime.IsZero() {
+			n.modTime = info.ModTime()
+		}
+	}
+	if n.size > int64(^uint32(0)) {
+		return fmt.Errorf("fat: %s exceeds the 4 GiB file size limit", clean)
+	}
+	if n.modTime.IsZero() {
+		n.modTime = img.opts.Created
+	}
+	n.modTime = n.modTime.UTC()
+	parent.children = append(parent.children, n)
+	return nil
+}
+
+// child looks up name case-insensitively, as FAT does.
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the volume to dest, replacing any existing file.
+func (img *Image) WriteFile(dest string) error {
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := img.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+type layout struct {
+	sectorsPerCluster uint32
+	clusterCount      uint32
+	fatSectors        uint32
+	totalSectors      uint32
+	dirs              []*node
+	files             []*node
+}
+
+func (l *layout) clusterSize() int64 {
+	return int64(l.sectorsPerCluster) * sectorSize
+}
+
+// WriteTo serialises the volume into w.
+func (img *Image) WriteTo(w io.Writer) (int64, error) {
+	l, err := img.layout()
+	if err != nil {
+		return 0, err
+	}
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	cw.write(img.bootSector(l))
+	fat := img.fatTable(l)
+	for i := 0; i < numFATs; i++ {
+		cw.write(fat)
+	}
+	root := img.directoryEntries(img.root)
+	cw.write(root)
+	cw.write(make([]byte, rootEntries*dirEntrySize-len(root)))
+
+	for _, d := range l.dirs {
+		entries := img.directoryEntries(d)
+		cw.write(entries)
+		cw.write(make([]byte, int64(d.clusters)*l.clusterSize()-int64(len(entries))))
+	}
+	for _, f := range l.files {
+		if err := cw.copyFile(f); err != nil {
+			return cw.n, err
+		}
+		cw.write(make([]byte, int64(f.clusters)*l.clusterSize()-f.size))
+	}
+	used := int64(2)
+	for _, n := range append(l.dirs, l.files...) {
+		used += int64(n.clusters)
+	}
+	for i := used; i < int64(l.clusterCount)+2; i++ {
+		cw.write(make([]byte, l.clusterSize()))
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+func (img *Image) layout() (*layout, error) {
+	l := &layout{}
+	if err := img.assignNames(img.root); err != nil {
+		return nil, err
+	}
+	if n := entryCount(img.root); n > rootEntries {
+		return nil, fmt.Errorf("fat: root directory needs %d entries, at most %d fit", n, rootEntries)
+	}
+	var walk func(d *node)
+	walk = func(d *node) {
+		for _, c := range d.children {
+			if c.dir {
+				l.dirs = append(l.dirs, c)
+				walk(c)
+			} else {
+				l.files = append(l.files, c)
+			}
+		}
+	}
+	walk(img.root)
+
+	for spc := uint32(1); spc <= 64; spc *= 2 {
+		size := int64(spc) * sectorSize
+		need := uint32(0)
+		for _, d := range l.dirs {
+			need += uint32(clustersFor(int64(entryCount(d))*dirEntrySize, size))
+		}
+		for _, f := range l.files {
+			need += uint32(clustersFor(f.size, size))
+		}
+		count := need + need/8 + 16
+		if count < minClusters {
+			count = minClusters
+		}
+		if count > maxClusters {
+			continue
+		}
+		l.sectorsPerCluster = spc
+		l.clusterCount = count
+		l.fatSectors = uint32(clustersFor(int64(count+2)*2, sectorSize))
+		l.totalSectors = reservedSectors + numFATs*l.fatSectors + rootEntries*dirEntrySize/sectorSize + count*spc
+		break
+	}
+	if l.sectorsPerCluster == 0 {
+		return nil, errors.New("fat: contents exceed the FAT16 size limit")
+	}
+
+	next := uint32(2)
+	for _, d := range l.dirs {
+		d.cluster = next
+		d.clusters = uint32(clustersFor(int64(entryCount(d))*dirEntrySize, l.clusterSize()))
+		next += d.clusters
+	}
+	for _, f := range l.files {
+		f.clusters = uint32(clustersFor(f.size, l.clusterSize()))
+		if f.clusters > 0 {
+			f.cluster = next
+			next += f.clusters
+		}
+	}
+	return l, nil
+}
+
+// clustersFor returns how many units of size hold n bytes, at least one for
+// directories; an empty file needs none.
+func clustersFor(n, size int64) int64 {
+	return (n + size - 1) / size
+}
+
+// entryCount returns the number of 32-byte entries of directory d.
+func entryCount(d *node) int {
+	n := 1 // "." and ".." for subdirectories, the volume label for the root
+	if d.parent != nil {
+		n = 2
+	}
+	for _, c := range d.children {
+		n += 1 + (len(c.lfn)+lfnChars-1)/lfnChars
+	}
+	return n
+}
+
+// assignNames gives every child of d a unique 8.3 name, plus a long name
+// when the 8.3 form does not preserve it exactly.
+func (img *Image) assignNames(d *node) error {
+	used := map[[11]byte]bool{}
+	var pending []*node
+	for _, c := range d.children {
+		if short, ok := exactShortName(c.name); ok {
+			c.short = short
+			used[short] = true
+			continue
+		}
+		pending = append(pending, c)
+	}
+	for _, c := range pending {
+		c.lfn = utf16.Encode([]rune(c.name))
+		base, ext := shortBasis(c.name)
+		found := false
+		for i := 1; i < 1000000 && !found; i++ {
+			tail := fmt.Sprintf("~%d", i)
+			b := base
+			if len(b)+len(tail) > 8 {
+				b = b[:8-len(tail)]
+			}
+			short := packShort(b+tail, ext)
+			if !used[short] {
+				c.short = short
+				used[short] = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("fat: too many names similar to %q", c.name)
+		}
+	}
+	for _, c := range d.children {
+		if c.dir {
+			if err := img.assignNames(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exactShortName returns name as an 8.3 entry when it already is a valid
+// upper-case short name.
+func exactShortName(name string) ([11]byte, bool) {
+	base, ext, _ := strings.Cut(name, ".")
+	if base == "" || len(base) > 8 || len(ext) > 3 || strings.Contains(ext, ".") {
+		return [11]byte{}, false
+	}
+	for _, r := range base + ext {
+		if !validShortChar(r) {
+			return [11]byte{}, false
+		}
+	}
+	return packShort(base, ext), true
+}
+
+// shortBasis derives the upper-case base and extension used to generate an
+// 8.3 alias for name.
+func shortBasis(name string) (string, string) {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	clean := func(s string, limit int) string {
+		var b strings.Builder
+		for _, r := range strings.ToUpper(s) {
+			if r == '.' || r == ' ' {
+				continue
+			}
+			if !validShortChar(r) {
+				r = '_'
+			}
+			b.WriteRune(r)
+			if b.Len() == limit {
+				break
+			}
+		}
+		return b.String()
+	}
+	base = clean(base, 6)
+	if base == "" {
+		base = "_"
+	}
+	return base, clean(ext, 3)
+}
+
+func validShortChar(r rune) bool {
+	switch {
+	case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("$%'-_@~`!(){}^#&", r)
+}
+
+func packShort(base, ext string) [11]byte {
+	var out [11]byte
+	for i := range out {
+		out[i] = ' '
+	}
+	copy(out[:8], base)
+	copy(out[8:], ext)
+	return out
+}
+
+func shortChecksum(short [11]byte) byte {
+	var sum byte
+	for _, b := range short {
+		sum = (sum>>1 | sum<<7) + b
+	}
+	return sum
+}
+
+func (img *Image) bootSector(l *layout) []byte {
+	b := make([]byte, sectorSize)
+	copy(b, []byte{0xEB, 0x3C, 0x90})
+	copy(b[3:], oemName)
+	binary.LittleEndian.PutUint16(b[11:], sectorSize)
+	b[13] = byte(l.sectorsPerCluster)
+	binary.LittleEndian.PutUint16(b[14:], reservedSectors)
+	b[16] = numFATs
+	binary.LittleEndian.PutUint16(b[17:], rootEntries)
+	if l.totalSectors < 0x10000 {
+		binary.LittleEndian.PutUint16(b[19:], uint16(l.totalSectors))
+	} else {
+		binary.LittleEndian.PutUint32(b[32:], l.totalSectors)
+	}
+	b[21] = 0xF8
+	binary.LittleEndian.PutUint16(b[22:], uint16(l.fatSectors))
+	binary.LittleEndian.PutUint16(b[24:], 32)
+	binary.LittleEndian.PutUint16(b[26:], 64)
+	b[36] = 0x80
+	b[38] = 0x29
+	binary.LittleEndian.PutUint32(b[39:], uint32(img.opts.Created.Unix()))
+	copy(b[43:54], img.label())
+	copy(b[54:62], "FAT16   ")
+	// Non-bootable: spin forever if a BIOS ever jumps here.
+	copy(b[62:], []byte{0xEB, 0xFE})
+	b[510], b[511] = 0x55, 0xAA
+	return b
+}
+
+func (img *Image) label() []byte {
+	label := []byte("NO NAME    ")
+	if img.opts.Label != "" {
+		label = []byte(fmt.Sprintf("%-11s", img.opts.Label))
+	}
+	return label
+}
+
+func (img *Image) fatTable(l *layout) []byte {
+	fat := make([]byte, l.fatSectors*sectorSize)
+	binary.LittleEndian.PutUint16(fat[0:], 0xFFF8)
+	binary.LittleEndian.PutUint16(fat[2:], endOfChain)
+	for _, n := range append(l.dirs, l.files...) {
+		for i := uint32(0); i < n.clusters; i++ {
+			next := uint16(n.cluster + i + 1)
+			if i == n.clusters-1 {
+				next = endOfChain
+			}
+			binary.LittleEndian.PutUint16(fat[(n.cluster+i)*2:], next)
+		}
+	}
+	return fat
+}
+
+// directoryEntries returns the raw entries of directory d.
+func (img *Image) directoryEntries(d *node) []byte {
+	var out []byte
+	if d.parent == nil {
+		var label [11]byte
+		copy(label[:], img.label())
+		out = append(out, shortEntry(label, attrVolumeID, 0, 0, img.opts.Created)...)
+	} else {
+		parentCluster := d.parent.cluster
+		out = append(out, shortEntry(packShort(".", ""), attrDir, d.cluster, 0, d.modTime)...)
+		out = append(out, shortEntry(packShort("..", ""), attrDir, parentCluster, 0, d.modTime)...)
+	}
+	for _, c := range d.children {
+		out = append(out, lfnEntries(c)...)
+		attr := byte(attrArchive)
+		size := uint32(c.size)
+		if c.dir {
+			attr, size = attrDir, 0
+		}
+		out = append(out, shortEntry(c.short, attr, c.cluster, size, c.modTime)...)
+	}
+	return out
+}
+
+func lfnEntries(n *node) []byte {
+	if len(n.lfn) == 0 {
+		return nil
+	}
+	name := append([]uint16(nil), n.lfn...)
+	if len(name)%lfnChars != 0 {
+		name = append(name, 0)
+	}
+	for len(name)%lfnChars != 0 {
+		name = append(name, 0xFFFF)
+	}
+	count := len(name) / lfnChars
+	sum := shortChecksum(n.short)
+	out := make([]byte, 0, count*dirEntrySize)
+	for seq := count; seq >= 1; seq-- {
+		e := make([]byte, dirEntrySize)
+		e[0] = byte(seq)
+		if seq == count {
+			e[0] |= 0x40
+		}
+		e[11] = attrLFN
+		e[13] = sum
+		chars := name[(seq-1)*lfnChars : seq*lfnChars]
+		offsets := []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30}
+		for i, c := range chars {
+			binary.LittleEndian.PutUint16(e[offsets[i]:], c)
+		}
+		out = append(out, e...)
+	}
+	return out
+}
+
+func shortEntry(name [11]byte, attr byte, cluster, size uint32, t time.Time) []byte {
+	e := make([]byte, dirEntrySize)
+	copy(e, name[:])
+	e[11] = attr
+	dosTime, dosDate := dosDateTime(t)
+	binary.LittleEndian.PutUint16(e[14:], dosTime)
+	binary.LittleEndian.PutUint16(e[16:], dosDate)
+	binary.LittleEndian.PutUint16(e[18:], dosDate)
+	binary.LittleEndian.PutUint16(e[22:], dosTime)
+	binary.LittleEndian.PutUint16(e[24:], dosDate)
+	binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(e[28:], size)
+	return e
+}
+
+// dosDateTime converts t to FAT's two-second resolution, clamped to the
+// 1980-2107 range FAT can store.
+func dosDateTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	switch {
+	case t.Year() < 1980:
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	case t.Year() > 2107:
+		t = time.Date(2107, 12, 31, 23, 59, 58, 0, time.UTC)
+	}
+	dosTime := uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2)
+	dosDate := uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+	return dosTime, dosDate
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) write(b []byte) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+func (cw *countingWriter) copyFile(n *node) error {
+	if cw.err != nil {
+		return cw.err
+	}
+	if n.file.Data != nil || n.file.Source == "" {
+		cw.write(n.file.Data)
+		return cw.err
+	}
+	f, err := os.Open(n.file.Source)
+	if err != nil {
+		return fmt.Errorf("fat: open %s: %w", n.file.Source, err)
+	}
+	defer f.Close()
+	copied, err := io.Copy(cw.w, io.LimitReader(f, n.size))
+	cw.n += copied
+	if err != nil {
+		return fmt.Errorf("fat: copy %s: %w", n.file.Source, err)
+	}
+	if copied != n.size {
+		return fmt.Errorf("fat: %s changed size while writing", n.file.Source)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// volume is a minimal FAT16 reader used to check the writer's output.
+type volume struct {
+	t           *testing.T
+	data        []byte
+	clusterSize int
+	fat         []byte
+	rootStart   int
+	dataStart   int
+	label       string
+}
+
+func readVolume(t *testing.T, data []byte) *volume {
+	t.Helper()
+	if len(data)%sectorSize != 0 || data[510] != 0x55 || data[511] != 0xAA {
+		t.Fatalf("volume of %d bytes has no boot signature", len(data))
+	}
+	b := data[:sectorSize]
+	if got := binary.LittleEndian.Uint16(b[11:]); got != sectorSize {
+		t.Fatalf("bytes per sector = %d", got)
+	}
+	total := int(binary.LittleEndian.Uint16(b[19:]))
+	if total == 0 {
+		total = int(binary.LittleEndian.Uint32(b[32:]))
+	}
+	if total*sectorSize != len(data) {
+		t.Fatalf("boot sector declares %d sectors, volume has %d", total, len(data)/sectorSize)
+	}
+	fatSectors := int(binary.LittleEndian.Uint16(b[22:]))
+	fatStart := int(binary.LittleEndian.Uint16(b[14:])) * sectorSize
+	fatSize := fatSectors * sectorSize
+	if !bytes.Equal(data[fatStart:fatStart+fatSize], data[fatStart+fatSize:fatStart+2*fatSize]) {
+		t.Error("the two FAT copies differ")
+	}
+	rootStart := fatStart + int(b[16])*fatSize
+	v := &volume{
+		t:           t,
+		data:        data,
+		clusterSize: int(b[13]) * sectorSize,
+		fat:         data[fatStart : fatStart+fatSize],
+		rootStart:   rootStart,
+		dataStart:   rootStart + int(binary.LittleEndian.Uint16(b[17:]))*dirEntrySize,
+		label:       strings.TrimRight(string(b[43:54]), " "),
+	}
+	clusters := (len(data) - v.dataStart) / v.clusterSize
+	if clusters < minClusters || clusters > maxClusters {
+		t.Errorf("%d clusters is not a FAT16 volume", clusters)
+	}
+	if string(b[54:62]) != "FAT16   " {
+		t.Errorf("file system type = %q", b[54:62])
+	}
+	return v
+}
+
+// chain returns the contents of the cluster chain starting at cluster.
+func (v *volume) chain(cluster uint16) []byte {
+	var out []byte
+	for seen := 0; cluster >= 2 && cluster < 0xFFF8; seen++ {
+		if seen > len(v.fat) {
+			v.t.Fatal("cluster chain loops")
+		}
+		off := v.dataStart + int(cluster-2)*v.clusterSize
+		out = append(out, v.data[off:off+v.clusterSize]...)
+		cluster = binary.LittleEndian.Uint16(v.fat[int(cluster)*2:])
+	}
+	return out
+}
+
+type entry struct {
+	name    string
+	short   string
+	dir     bool
+	cluster uint16
+	size    uint32
+	modTime time.Time
+}
+
+// entries decodes a directory, joining long names with their 8.3 entry.
+func (v *volume) entries(raw []byte) []entry {
+	var out []entry
+	var lfn []uint16
+	for off := 0; off+dirEntrySize <= len(raw); off += dirEntrySize {
+		e := raw[off : off+dirEntrySize]
+		switch {
+		case e[0] == 0:
+			return out
+		case e[11] == attrLFN:
+			var chars []uint16
+			for _, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				chars = append(chars, binary.LittleEndian.Uint16(e[o:]))
+			}
+			if e[0]&0x40 != 0 {
+				lfn = nil
+			}
+			lfn = append(chars, lfn...)
+			continue
+		case e[11]&attrVolumeID != 0:
+			continue
+		}
+		short := strings.TrimRight(string(e[:8]), " ")
+		if ext := strings.TrimRight(string(e[8:11]), " "); ext != "" {
+			short += "." + ext
+		}
+		name := short
+		if lfn != nil {
+			end := len(lfn)
+			for i, c := range lfn {
+				if c == 0 {
+					end = i
+					break
+				}
+			}
+			name = string(utf16.Decode(lfn[:end]))
+			lfn = nil
+		}
+		dosTime, dosDate := binary.LittleEndian.Uint16(e[22:]), binary.LittleEndian.Uint16(e[24:])
+		out = append(out, entry{
+			name:    name,
+			short:   short,
+			dir:     e[11]&attrDir != 0,
+			cluster: binary.LittleEndian.Uint16(e[26:]),
+			size:    binary.LittleEndian.Uint32(e[28:]),
+			modTime: time.Date(1980+int(dosDate>>9), time.Month(dosDate>>5&0xF), int(dosDate&0x1F),
+				int(dosTime>>11), int(dosTime>>5&0x3F), int(dosTime&0x1F)*2, 0, time.UTC),
+		})
+	}
+	return out
+}
+
+// files walks the volume and returns every regular file by path.
+func (v *volume) files() map[string]entry {
+	out := map[string]entry{}
+	var walk func(prefix string, raw []byte)
+	walk = func(prefix string, raw []byte) {
+		for _, e := range v.entries(raw) {
+			if e.name == "." || e.name == ".." {
+				continue
+			}
+			if e.dir {
+				walk(prefix+e.name+"/", v.chain(e.cluster))
+				continue
+			}
+			out[prefix+e.name] = e
+		}
+	}
+	walk("", v.data[v.rootStart:v.dataStart])
+	return out
+}
+
+func (v *volume) read(e entry) []byte {
+	return v.chain(e.cluster)[:e.size]
+}
+
+func writeVolume(t *testing.T, opts Options, files []File) []byte {
+	t.Helper()
+	img, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := img.AddFile(f); err != nil {
+			t.Fatalf("AddFile(%s): %v", f.Path, err)
+		}
+	}
+	var buf bytes.Buffer
+	n, err := img.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo() = %d, wrote %d bytes", n, buf.Len())
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	stamp := time.Date(2024, 5, 6, 7, 8, 10, 0, time.UTC)
+	source := filepath.Join(t.TempDir(), "source.bin")
+	big := bytes.Repeat([]byte("0123456789abcdef"), 9000)
+	if err := os.WriteFile(source, big, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var many []File
+	for i := 0; i < 40; i++ {
+		many = append(many, File{Path: "many/network-config-" + strings.Repeat("x", i%3) + string(rune('a'+i%26)) + string(rune('a'+i/26)), Data: []byte{byte(i)}})
+	}
+	tests := []struct {
+		name  string
+		opts  Options
+		files []File
+		// shorts maps paths to the 8.3 name they must get.
+		shorts map[string]string
+	}{
+		{
+			name: "nocloud seed",
+			opts: Options{Label: "cidata", Created: stamp},
+			files: []File{
+				{Path: "user-data", Data: []byte("#cloud-config\nhostname: edge1\n")},
+				{Path: "meta-data", Data: []byte("instance-id: edge1\n")},
+				{Path: "network-config", Data: []byte("version: 2\n")},
+				{Path: "empty", Data: []byte{}},
+			},
+			shorts: map[string]string{"user-data": "USER-D~1", "network-config": "NETWOR~1", "empty": "EMPTY~1"},
+		},
+		{
+			name: "exact short names need no long name",
+			opts: Options{Label: "CIDATA", Created: stamp},
+			files: []File{
+				{Path: "README.TXT", Data: []byte("a")},
+				{Path: "DIR/A$B.C", Data: []byte("b")},
+			},
+			shorts: map[string]string{"README.TXT": "README.TXT", "DIR/A$B.C": "A$B.C"},
+		},
+		{
+			name: "nested directories, unicode and large files",
+			opts: Options{Created: stamp},
+			files: []File{
+				{Path: "openstack/latest/meta_data.json", Data: []byte(`{"uuid": "x"}`)},
+				{Path: "extra/Überraschung – a long file name.txt", Data: []byte("ü")},
+				{Path: "extra/big.bin", Source: source},
+				{Path: "extra/old.txt", Data: []byte("1970"), ModTime: time.Unix(0, 0)},
+			},
+		},
+		{
+			name:  "colliding aliases",
+			opts:  Options{Created: stamp},
+			files: many,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := readVolume(t, writeVolume(t, tt.opts, tt.files))
+			wantLabel := tt.opts.Label
+			if wantLabel == "" {
+				wantLabel = "NO NAME"
+			}
+			if v.label != wantLabel {
+				t.Errorf("label = %q, want %q", v.label, wantLabel)
+			}
+			got := v.files()
+			if len(got) != len(tt.files) {
+				t.Errorf("volume holds %d files, want %d", len(got), len(tt.files))
+			}
+			shorts := map[string]bool{}
+			for _, f := range tt.files {
+				e, ok := got[f.Path]
+				if !ok {
+					t.Errorf("%s not found", f.Path)
+					continue
+				}
+				want := f.Data
+				if f.Source != "" {
+					want = big
+				}
+				if data := v.read(e); !bytes.Equal(data, want) {
+					t.Errorf("%s: content differs: got %d bytes, want %d", f.Path, len(data), len(want))
+				}
+				wantTime := stamp
+				if !f.ModTime.IsZero() {
+					wantTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+				}
+				if f.Source != "" {
+					info, _ := os.Stat(source)
+					wantTime = info.ModTime().UTC().Truncate(2 * time.Second)
+				}
+				if !e.modTime.Equal(wantTime) {
+					t.Errorf("%s: modification time = %v, want %v", f.Path, e.modTime, wantTime)
+				}
+				if s, ok := tt.shorts[f.Path]; ok && e.short != s {
+					t.Errorf("%s: short name = %q, want %q", f.Path, e.short, s)
+				}
+				dir := filepath.ToSlash(filepath.Dir(f.Path))
+				if shorts[dir+"/"+e.short] {
+					t.Errorf("%s: short name %s is used twice", f.Path, e.short)
+				}
+				shorts[dir+"/"+e.short] = true
+			}
+		})
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	files := []File{{Path: "b", Data: []byte("b")}, {Path: "a/c", Data: []byte("c")}}
+	opts := Options{Label: "cidata", Created: time.Unix(1700000000, 0)}
+	if !bytes.Equal(writeVolume(t, opts, files), writeVolume(t, opts, files)) {
+		t.Error("identical input produced different volumes")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(Options{Label: "twelve chars"}); err == nil {
+		t.Error("New accepted a 12 character label")
+	}
+	tests := []struct {
+		name  string
+		files []File
+		want  string
+	}{
+		{name: "empty path", files: []File{{Path: "/"}}, want: "empty file path"},
+		{name: "duplicate ignores case", files: []File{{Path: "User-Data"}, {Path: "user-data"}}, want: "duplicate path"},
+		{name: "file as directory", files: []File{{Path: "a"}, {Path: "a/b"}}, want: "is a file"},
+		{name: "name too long", files: []File{{Path: strings.Repeat("n", 256)}}, want: "too long"},
+		{name: "missing source", files: []File{{Path: "a", Source: filepath.Join(t.TempDir(), "none")}}, want: "stat"},
+		{name: "directory source", files: []File{{Path: "a", Source: t.TempDir()}}, want: "not a regular file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := New(Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range tt.files {
+				if err = img.AddFile(f); err != nil {
+					break
+				}
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootDirectoryFull(t *testing.T) {
+	img, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rootEntries; i++ {
+		if err := img.AddFile(File{Path: strings.Repeat("f", 20) + string(rune('A'+i%26)) + string(rune('A'+i/26)), Data: []byte{1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := img.WriteTo(&bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "root directory needs") {
+		t.Errorf("error = %v, want the root directory limit", err)
+	}
+}
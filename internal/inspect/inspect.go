@@ -0,0 +1,291 @@
+package inspect
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/iso9660"
+	"velocloud-cloudinit-builder/internal/output"
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+const (
+	expectedLabel    = "cidata"
+	configDriveLabel = "config-2"
+	ovfEnvLabel      = "OVF ENV"
+)
+
+// SeedFiles lists the NoCloud files whose contents are printed, in order.
+var SeedFiles = []string{"user-data", "meta-data", "network-config", "vendor-data"}
+
+// ConfigDriveFiles lists the OpenStack config drive files printed instead of
+// SeedFiles for config-2 images, in the same order.
+var ConfigDriveFiles = []string{
+	"openstack/latest/user_data",
+	"openstack/latest/meta_data.json",
+	"openstack/latest/network_data.json",
+	"openstack/latest/vendor_data.json",
+}
+
+// OVFEnvFiles lists the files printed for OVF environment ISOs.
+var OVFEnvFiles = []string{"ovf-env.xml"}
+
+// seedFilesFor returns the seed file list matching the volume label.
+func seedFilesFor(rd *iso9660.Reader) []string {
+	switch {
+	case strings.EqualFold(rd.VolumeID(), configDriveLabel):
+		return ConfigDriveFiles
+	case strings.EqualFold(rd.VolumeID(), ovfEnvLabel):
+		return OVFEnvFiles
+	default:
+		return SeedFiles
+	}
+}
+
+// knownLabel reports whether label is one cloud-init looks for.
+func knownLabel(label string) bool {
+	for _, l := range []string{expectedLabel, configDriveLabel, ovfEnvLabel} {
+		if strings.EqualFold(label, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Options controls what Run prints and extracts.
+type Options struct {
+	// ExtractDir receives a copy of every file in the ISO when set.
+	ExtractDir string
+}
+
+// Run prints the label, file tree and seed file contents of the ISO at isoPath to w.
+func Run(isoPath string, opts Options, w io.Writer) error {
+	f, err := os.Open(isoPath)
+	if err != nil {
+		return fmt.Errorf("open iso: %w", err)
+	}
+	defer f.Close()
+	rd, err := iso9660.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", isoPath, err)
+	}
+
+	fmt.Fprintf(w, "Volume label: %s\n", rd.VolumeID())
+	fmt.Fprintf(w, "Extensions:   %s\n", extensions(rd))
+	if !knownLabel(rd.VolumeID()) {
+		fmt.Fprintf(w, "WARNING: volume label is %q, cloud-init NoCloud expects %q\n", rd.VolumeID(), expectedLabel)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Files:")
+	for _, e := range rd.Entries() {
+		if e.IsDir {
+			fmt.Fprintf(w, "  %10s  %s/\n", "-", e.Path)
+			continue
+		}
+		fmt.Fprintf(w, "  %10d  %s\n", e.Size, e.Path)
+	}
+
+	for i, name := range seedFilesFor(rd) {
+		data, err := rd.ReadFile(name)
+		if err != nil {
+			if i < 2 {
+				fmt.Fprintf(w, "\nWARNING: %s is missing from the ISO\n", name)
+			}
+			continue
+		}
+		if IsUserData(name) && (userdata.IsGzip(data) || userdata.IsMultipart(data)) {
+			parts, err := userdata.Split(data)
+			if err != nil {
+				fmt.Fprintf(w, "\nWARNING: %s: %v\n", name, err)
+				continue
+			}
+			fmt.Fprintf(w, "\n--- %s (%d bytes, %s) ---\n", name, len(data), describePayload(data, parts))
+			for i, p := range parts {
+				fmt.Fprintf(w, "\n--- %s part %d/%d: %s (%s, %d bytes) ---\n", name, i+1, len(parts), partName(i, p), defaultType(p.ContentType), len(p.Data))
+				writeContents(w, p.Data)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "\n--- %s (%d bytes) ---\n", name, len(data))
+		writeContents(w, data)
+		if name == OVFEnvFiles[0] {
+			writeOVFProperties(w, data)
+		}
+	}
+
+	if opts.ExtractDir != "" {
+		if err := extract(rd, opts.ExtractDir); err != nil {
+			return err
+		}
+		return extractParts(rd, opts.ExtractDir)
+	}
+	return nil
+}
+
+func writeContents(w io.Writer, data []byte) {
+	w.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		fmt.Fprintln(w)
+	}
+}
+
+// userDataFiles lists the seed files that may hold multipart or gzipped
+// user-data. A config drive wraps vendor-data in JSON, so only its
+// user_data qualifies.
+var userDataFiles = []string{"user-data", "vendor-data", "openstack/latest/user_data"}
+
+// IsUserData reports whether the seed file name may be multipart or gzipped.
+func IsUserData(name string) bool {
+	for _, f := range userDataFiles {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
+
+// ovfEnvironment is the part of an OVF environment document that carries
+// the cloud-init properties.
+type ovfEnvironment struct {
+	Properties []struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"PropertySection>Property"`
+}
+
+// writeOVFProperties prints the decoded base64 properties of an OVF
+// environment document.
+func writeOVFProperties(w io.Writer, data []byte) {
+	var env ovfEnvironment
+	if err := xml.Unmarshal(data, &env); err != nil {
+		fmt.Fprintf(w, "\nWARNING: %s: %v\n", OVFEnvFiles[0], err)
+		return
+	}
+	for _, p := range env.Properties {
+		if p.Key != "user-data" && p.Key != "network-config" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(p.Value)
+		if err != nil {
+			fmt.Fprintf(w, "\nWARNING: %s property is not base64: %v\n", p.Key, err)
+			continue
+		}
+		fmt.Fprintf(w, "\n--- %s property (%d bytes decoded) ---\n", p.Key, len(decoded))
+		if userdata.IsGzip(decoded) {
+			if decoded, err = userdata.Gunzip(decoded); err != nil {
+				fmt.Fprintf(w, "WARNING: %v\n", err)
+				continue
+			}
+		}
+		writeContents(w, decoded)
+	}
+}
+
+func describePayload(data []byte, parts []userdata.Part) string {
+	desc := fmt.Sprintf("%d part(s)", len(parts))
+	if userdata.IsGzip(data) {
+		desc = "gzip, " + desc
+	}
+	return desc
+}
+
+func partName(i int, p userdata.Part) string {
+	if p.Filename != "" {
+		return filepath.Base(p.Filename)
+	}
+	return fmt.Sprintf("part-%02d", i+1)
+}
+
+func defaultType(contentType string) string {
+	if contentType == "" {
+		return "unknown type"
+	}
+	return contentType
+}
+
+// extractParts writes the parts of multipart or gzipped user-data and
+// vendor-data to <dir>/<seed>.parts/, numbered in their original order.
+func extractParts(rd *iso9660.Reader, dir string) error {
+	for _, name := range seedFilesFor(rd) {
+		if !IsUserData(name) {
+			continue
+		}
+		data, err := rd.ReadFile(name)
+		if err != nil || !(userdata.IsGzip(data) || userdata.IsMultipart(data)) {
+			continue
+		}
+		parts, err := userdata.Split(data)
+		if err != nil {
+			return fmt.Errorf("split %s: %w", name, err)
+		}
+		partsDir, err := filepath.Abs(filepath.Join(dir, filepath.FromSlash(name)+".parts"))
+		if err != nil {
+			return err
+		}
+		if err := fsutil.EnsureDir(partsDir); err != nil {
+			return err
+		}
+		for i, p := range parts {
+			target, err := fsutil.SafeJoin(partsDir, fmt.Sprintf("%02d-%s", i+1, partName(i, p)))
+			if err != nil {
+				return fmt.Errorf("extract %s part %d: %w", name, i+1, err)
+			}
+			if err := os.WriteFile(target, p.Data, 0o644); err != nil {
+				return fmt.Errorf("extract %s part %d: %w", name, i+1, err)
+			}
+		}
+		output.Printf("[+] Split %s into %d part(s) in %s\n", name, len(parts), partsDir)
+	}
+	return nil
+}
+
+func extensions(rd *iso9660.Reader) string {
+	switch {
+	case rd.RockRidge():
+		return "Rock Ridge"
+	case rd.Joliet():
+		return "Joliet"
+	default:
+		return "none (plain ISO9660 names)"
+	}
+}
+
+func extract(rd *iso9660.Reader, dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("resolve extract dir: %w", err)
+	}
+	if err := fsutil.EnsureDir(absDir); err != nil {
+		return err
+	}
+	count := 0
+	for _, e := range rd.Entries() {
+		target, err := fsutil.SafeJoin(absDir, filepath.FromSlash(e.Path))
+		if err != nil {
+			return fmt.Errorf("extract %s: %w", e.Path, err)
+		}
+		if e.IsDir {
+			if err := fsutil.EnsureDir(target); err != nil {
+				return err
+			}
+			continue
+		}
+		r, err := rd.Open(e)
+		if err != nil {
+			return err
+		}
+		if err := fsutil.CopyStream(target, r); err != nil {
+			return fmt.Errorf("extract %s: %w", e.Path, err)
+		}
+		count++
+	}
+	output.Printf("[+] Extracted %d file(s) to %s\n", count, absDir)
+	return nil
+}
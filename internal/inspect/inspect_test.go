@@ -0,0 +1,100 @@
+package inspect
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/iso9660"
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+func writeISO(t *testing.T, label string, files map[string][]byte) string {
+	t.Helper()
+	img := iso9660.New(iso9660.Options{VolumeID: label, Joliet: true, RockRidge: true, Created: time.Unix(1700000000, 0)})
+	for name, data := range files {
+		if err := img.AddFile(iso9660.File{Path: name, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "seed.iso")
+	if err := img.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunSplitsUserData(t *testing.T) {
+	multipart, err := userdata.Assemble([]userdata.Part{
+		{Filename: "10-base.yaml", Data: []byte("#cloud-config\nhostname: edge\n")},
+		{Filename: "20-run.sh", Data: []byte("#!/bin/sh\necho hi\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		label string
+		files map[string][]byte
+		want  []string
+		deny  []string
+	}{
+		{
+			name:  "nocloud vendor-data",
+			label: "cidata",
+			files: map[string][]byte{"user-data": []byte("#cloud-config\n"), "meta-data": []byte("instance-id: x\n"), "vendor-data": multipart},
+			want:  []string{"vendor-data part 1/2: 10-base.yaml", "vendor-data part 2/2: 20-run.sh"},
+		},
+		{
+			name:  "nocloud meta-data is never split",
+			label: "cidata",
+			files: map[string][]byte{"user-data": []byte("#cloud-config\n"), "meta-data": multipart},
+			deny:  []string{"meta-data part"},
+		},
+		{
+			name:  "config drive user_data",
+			label: "config-2",
+			files: map[string][]byte{"openstack/latest/user_data": multipart, "openstack/latest/meta_data.json": []byte("{}")},
+			want:  []string{"openstack/latest/user_data part 2/2: 20-run.sh"},
+		},
+		{
+			name:  "config drive vendor_data.json is JSON",
+			label: "config-2",
+			files: map[string][]byte{"openstack/latest/user_data": []byte("#cloud-config\n"), "openstack/latest/meta_data.json": []byte("{}"), "openstack/latest/vendor_data.json": multipart},
+			deny:  []string{"vendor_data.json part"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Run(writeISO(t, tt.label, tt.files), Options{}, &out); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("output lacks %q:\n%s", s, out.String())
+				}
+			}
+			for _, s := range tt.deny {
+				if strings.Contains(out.String(), s) {
+					t.Errorf("output contains %q:\n%s", s, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRunExtract(t *testing.T) {
+	iso := writeISO(t, "cidata", map[string][]byte{"user-data": []byte("#cloud-config\n"), "meta-data": []byte("m\n"), "extra/a.txt": []byte("a")})
+	dir := t.TempDir()
+	if err := Run(iso, Options{ExtractDir: dir}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "extra", "a.txt"))
+	if err != nil || string(got) != "a" {
+		t.Fatalf("extracted extra/a.txt = %q, %v", got, err)
+	}
+}
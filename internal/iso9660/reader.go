@@ -0,0 +1,393 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	maxContinuations = 32
+	// maxDirectorySize bounds a single directory extent. Seed images hold a
+	// handful of files; a larger size comes from a corrupt or crafted image.
+	maxDirectorySize = 16 << 20
+)
+
+// Entry describes a file or directory found in an image.
+type Entry struct {
+	// Path is the slash separated location inside the image, without a leading slash.
+	Path    string
+	Name    string
+	IsDir   bool
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+
+	extent uint32
+}
+
+// Reader gives read-only access to an ISO9660 image.
+type Reader struct {
+	r         io.ReaderAt
+	size      int64
+	volumeID  string
+	joliet    bool
+	rockRidge bool
+	entries   []*Entry
+	byPath    map[string]*Entry
+}
+
+type dirRef struct {
+	extent uint32
+	size   uint32
+}
+
+// NewReader parses the volume descriptors and directory tree of the image in r.
+// Rock Ridge names are preferred, then Joliet, then plain ISO9660 identifiers.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	rd := &Reader{r: r, byPath: map[string]*Entry{}}
+	var primaryRoot, jolietRoot *dirRef
+	for sector := int64(systemAreaSize); ; sector++ {
+		vd := make([]byte, sectorSize)
+		if _, err := r.ReadAt(vd, sector*sectorSize); err != nil {
+			return nil, fmt.Errorf("iso9660: read volume descriptor: %w", err)
+		}
+		if string(vd[1:6]) != "CD001" {
+			return nil, errors.New("iso9660: not an ISO9660 image")
+		}
+		switch vd[0] {
+		case 1:
+			rd.volumeID = strings.TrimRight(string(vd[40:72]), " \x00")
+			rd.size = int64(binary.LittleEndian.Uint32(vd[80:])) * sectorSize
+			primaryRoot = rootRef(vd)
+		case 2:
+			esc := string(vd[88:91])
+			if esc == "%/@" || esc == "%/C" || esc == "%/E" {
+				jolietRoot = rootRef(vd)
+			}
+		}
+		if vd[0] == 255 {
+			break
+		}
+		if sector > systemAreaSize+64 {
+			return nil, errors.New("iso9660: volume descriptor set is not terminated")
+		}
+	}
+	if primaryRoot == nil {
+		return nil, errors.New("iso9660: primary volume descriptor missing")
+	}
+
+	rootRecords, err := rd.readDir(*primaryRoot)
+	if err != nil {
+		return nil, err
+	}
+	if len(rootRecords) > 0 {
+		su := rootRecords[0].systemUse
+		rd.rockRidge = len(su) >= 7 && string(su[:2]) == "SP" && su[4] == 0xBE && su[5] == 0xEF
+	}
+	root := *primaryRoot
+	if !rd.rockRidge && jolietRoot != nil {
+		rd.joliet = true
+		root = *jolietRoot
+	}
+	if err := rd.walk(root, "", 0); err != nil {
+		return nil, err
+	}
+	sort.Slice(rd.entries, func(i, j int) bool { return rd.entries[i].Path < rd.entries[j].Path })
+	return rd, nil
+}
+
+func rootRef(vd []byte) *dirRef {
+	rec := vd[156:190]
+	return &dirRef{
+		extent: binary.LittleEndian.Uint32(rec[2:]),
+		size:   binary.LittleEndian.Uint32(rec[10:]),
+	}
+}
+
+// VolumeID returns the volume label from the primary volume descriptor.
+func (rd *Reader) VolumeID() string { return rd.volumeID }
+
+// RockRidge reports whether names were taken from Rock Ridge entries.
+func (rd *Reader) RockRidge() bool { return rd.rockRidge }
+
+// Joliet reports whether names were taken from the Joliet tree.
+func (rd *Reader) Joliet() bool { return rd.joliet }
+
+// Entries returns every file and directory sorted by path.
+func (rd *Reader) Entries() []*Entry { return rd.entries }
+
+// Lookup returns the entry at p, or nil when it does not exist.
+func (rd *Reader) Lookup(p string) *Entry {
+	clean := strings.Trim(path.Clean("/"+p), "/")
+	if e := rd.byPath[clean]; e != nil || rd.rockRidge || rd.joliet {
+		return e
+	}
+	// Plain ISO9660 names are upper-cased 8.3 identifiers; map p the same way.
+	parts := strings.Split(clean, "/")
+	for i, part := range parts {
+		parts[i] = plainName([]byte(isoIdentifier(part, i < len(parts)-1)))
+	}
+	return rd.byPath[strings.Join(parts, "/")]
+}
+
+// Open returns a reader over the contents of the regular file e.
+func (rd *Reader) Open(e *Entry) (io.Reader, error) {
+	if e.IsDir {
+		return nil, fmt.Errorf("iso9660: %s is a directory", e.Path)
+	}
+	return io.NewSectionReader(rd.r, int64(e.extent)*sectorSize, e.Size), nil
+}
+
+// ReadFile returns the contents of the file at p.
+func (rd *Reader) ReadFile(p string) ([]byte, error) {
+	e := rd.Lookup(p)
+	if e == nil {
+		return nil, fmt.Errorf("iso9660: %s: %w", p, fs.ErrNotExist)
+	}
+	r, err := rd.Open(e)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+type record struct {
+	ident     []byte
+	extent    uint32
+	size      uint32
+	dir       bool
+	modTime   time.Time
+	systemUse []byte
+}
+
+// checkExtent rejects an area of size bytes at offset that is larger than
+// limit or ends past the volume, before anything is allocated for it.
+func (rd *Reader) checkExtent(offset, size, limit int64) error {
+	if size > limit {
+		return fmt.Errorf("size %d exceeds the limit of %d bytes", size, limit)
+	}
+	if offset+size > rd.size {
+		return fmt.Errorf("%d bytes at offset %d end past the %d byte volume", size, offset, rd.size)
+	}
+	return nil
+}
+
+func (rd *Reader) readDir(ref dirRef) ([]record, error) {
+	if err := rd.checkExtent(int64(ref.extent)*sectorSize, int64(ref.size), maxDirectorySize); err != nil {
+		return nil, fmt.Errorf("iso9660: directory at sector %d: %w", ref.extent, err)
+	}
+	buf := make([]byte, ref.size)
+	if _, err := rd.r.ReadAt(buf, int64(ref.extent)*sectorSize); err != nil {
+		return nil, fmt.Errorf("iso9660: read directory at sector %d: %w", ref.extent, err)
+	}
+	var records []record
+	for pos := 0; pos < len(buf); {
+		length := int(buf[pos])
+		if length == 0 {
+			pos = (pos/sectorSize + 1) * sectorSize
+			continue
+		}
+		if length < 34 || pos+length > len(buf) {
+			return nil, fmt.Errorf("iso9660: corrupt directory record at sector %d", ref.extent)
+		}
+		raw := buf[pos : pos+length]
+		identLen := int(raw[32])
+		if 33+identLen > length {
+			return nil, fmt.Errorf("iso9660: corrupt directory record at sector %d", ref.extent)
+		}
+		suStart := 33 + identLen
+		if identLen%2 == 0 {
+			suStart++
+		}
+		rec := record{
+			ident:   raw[33 : 33+identLen],
+			extent:  binary.LittleEndian.Uint32(raw[2:]),
+			size:    binary.LittleEndian.Uint32(raw[10:]),
+			dir:     raw[25]&0x02 != 0,
+			modTime: parseRecordTime(raw[18:25]),
+		}
+		if suStart < length {
+			rec.systemUse = raw[suStart:]
+		}
+		records = append(records, rec)
+		pos += length
+	}
+	return records, nil
+}
+
+func (rd *Reader) walk(ref dirRef, prefix string, depth int) error {
+	if depth > 64 {
+		return errors.New("iso9660: directory tree too deep")
+	}
+	records, err := rd.readDir(ref)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if len(rec.ident) == 1 && (rec.ident[0] == 0 || rec.ident[0] == 1) {
+			continue
+		}
+		e := &Entry{
+			IsDir:   rec.dir,
+			Size:    int64(rec.size),
+			ModTime: rec.modTime,
+			Mode:    0o444,
+			extent:  rec.extent,
+		}
+		if rec.dir {
+			e.Size = 0
+			e.Mode = fs.ModeDir | 0o555
+		}
+		switch {
+		case rd.rockRidge:
+			e.Name = string(rec.ident)
+			if err := rd.applyRockRidge(e, rec.systemUse); err != nil {
+				return err
+			}
+			if e.Name == string(rec.ident) {
+				e.Name = plainName(rec.ident)
+			}
+		case rd.joliet:
+			e.Name = jolietDecode(rec.ident)
+		default:
+			e.Name = plainName(rec.ident)
+		}
+		e.Path = path.Join(prefix, e.Name)
+		if _, dup := rd.byPath[e.Path]; dup {
+			continue
+		}
+		rd.entries = append(rd.entries, e)
+		rd.byPath[e.Path] = e
+		if rec.dir {
+			if err := rd.walk(dirRef{extent: rec.extent, size: rec.size}, e.Path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyRockRidge reads NM, PX and TF entries, following CE continuations.
+func (rd *Reader) applyRockRidge(e *Entry, su []byte) error {
+	var name []byte
+	var haveName bool
+	for hops := 0; len(su) > 0 && hops < maxContinuations; hops++ {
+		var next []byte
+		for len(su) >= 4 {
+			sig, length := string(su[:2]), int(su[2])
+			if length < 4 || length > len(su) {
+				break
+			}
+			body := su[4:length]
+			switch sig {
+			case "NM":
+				if len(body) >= 1 && body[0]&0x06 == 0 {
+					name = append(name, body[1:]...)
+					haveName = true
+				}
+			case "PX":
+				if len(body) >= 8 {
+					e.Mode = posixMode(binary.LittleEndian.Uint32(body))
+				}
+			case "TF":
+				if t, ok := rockRidgeModTime(body); ok {
+					e.ModTime = t
+				}
+			case "CE":
+				if len(body) >= 24 {
+					loc := binary.LittleEndian.Uint32(body[0:])
+					off := binary.LittleEndian.Uint32(body[8:])
+					size := binary.LittleEndian.Uint32(body[16:])
+					// A continuation area never spans logical blocks.
+					if int64(off)+int64(size) > sectorSize {
+						return fmt.Errorf("iso9660: continuation area of %d bytes at offset %d exceeds a sector", size, off)
+					}
+					if err := rd.checkExtent(int64(loc)*sectorSize+int64(off), int64(size), sectorSize); err != nil {
+						return fmt.Errorf("iso9660: continuation area: %w", err)
+					}
+					next = make([]byte, size)
+					if _, err := rd.r.ReadAt(next, int64(loc)*sectorSize+int64(off)); err != nil {
+						return fmt.Errorf("iso9660: read continuation area: %w", err)
+					}
+				}
+			case "ST":
+				su = nil
+			}
+			if su == nil {
+				break
+			}
+			su = su[length:]
+		}
+		su = next
+	}
+	if haveName {
+		e.Name = string(name)
+	}
+	return nil
+}
+
+func posixMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0o777)
+	switch m & 0o170000 {
+	case 0o040000:
+		mode |= fs.ModeDir
+	case 0o120000:
+		mode |= fs.ModeSymlink
+	}
+	return mode
+}
+
+// rockRidgeModTime extracts the modification stamp from a TF entry body.
+func rockRidgeModTime(body []byte) (time.Time, bool) {
+	if len(body) < 1 {
+		return time.Time{}, false
+	}
+	flags := body[0]
+	if flags&0x80 != 0 || flags&0x02 == 0 {
+		return time.Time{}, false
+	}
+	offset := 1
+	if flags&0x01 != 0 {
+		offset += 7
+	}
+	if len(body) < offset+7 {
+		return time.Time{}, false
+	}
+	return parseRecordTime(body[offset : offset+7]), true
+}
+
+func parseRecordTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone).UTC()
+}
+
+func plainName(ident []byte) string {
+	name := string(ident)
+	if i := strings.LastIndex(name, ";"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, ".")
+}
+
+func jolietDecode(ident []byte) string {
+	units := make([]uint16, 0, len(ident)/2)
+	for i := 0; i+1 < len(ident); i += 2 {
+		units = append(units, binary.BigEndian.Uint16(ident[i:]))
+	}
+	name := string(utf16.Decode(units))
+	if i := strings.LastIndex(name, ";"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
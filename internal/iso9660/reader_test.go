@@ -0,0 +1,121 @@
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleImage(t *testing.T) []byte {
+	t.Helper()
+	img := New(Options{VolumeID: "cidata", Joliet: true, RockRidge: true, Created: time.Unix(1700000000, 0)})
+	if err := img.AddFile(File{Path: "user-data", Data: []byte("#cloud-config\n")}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := img.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReaderRejectsCorruptImages(t *testing.T) {
+	const pvd = systemAreaSize * sectorSize
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+		want   string
+	}{
+		{
+			name:   "not an iso",
+			mutate: func(b []byte) []byte { copy(b[pvd+1:], "XXXXX"); return b },
+			want:   "not an ISO9660 image",
+		},
+		{
+			name:   "truncated",
+			mutate: func(b []byte) []byte { return b[:pvd+100] },
+			want:   "read volume descriptor",
+		},
+		{
+			name: "huge root directory",
+			mutate: func(b []byte) []byte {
+				putBoth32(b[pvd+156+10:], 0xFFFFFFF0)
+				return b
+			},
+			want: "exceeds the limit",
+		},
+		{
+			name: "root directory past the volume",
+			mutate: func(b []byte) []byte {
+				putBoth32(b[pvd+156+2:], uint32(len(b)/sectorSize))
+				return b
+			},
+			want: "past the",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(sampleImage(t))
+			_, err := NewReader(bytes.NewReader(data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderLookup(t *testing.T) {
+	rd, err := NewReader(bytes.NewReader(sampleImage(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		found bool
+	}{
+		{"user-data", true},
+		{"/user-data", true},
+		{"./user-data", true},
+		{"USER_DAT", false},
+		{"meta-data", false},
+	}
+	for _, tt := range tests {
+		if got := rd.Lookup(tt.path) != nil; got != tt.found {
+			t.Errorf("Lookup(%q) found = %v, want %v", tt.path, got, tt.found)
+		}
+	}
+	if _, err := rd.ReadFile("meta-data"); err == nil {
+		t.Error("ReadFile of a missing file succeeded")
+	}
+}
+
+func TestContinuationBounds(t *testing.T) {
+	data := sampleImage(t)
+	rd := &Reader{r: bytes.NewReader(data), size: int64(len(data))}
+	last := uint32(len(data)/sectorSize - 1)
+	tests := []struct {
+		name    string
+		loc     uint32
+		length  uint32
+		wantErr string
+	}{
+		{name: "within a sector", loc: last, length: 64},
+		{name: "huge", loc: 0, length: 0x7FFFFFFF, wantErr: "exceeds a sector"},
+		{name: "past the volume", loc: last + 1, length: 64, wantErr: "past the"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rd.applyRockRidge(&Entry{}, continuationEntry(tt.loc, tt.length))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("applyRockRidge: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,715 @@
+// Package iso9660 writes and reads small ISO9660 images with Joliet and
+// Rock Ridge extensions, which is all a cloud-init NoCloud seed needs.
+package iso9660
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize       = 2048
+	systemAreaSize   = 16
+	maxRecordLength  = 255
+	maxDirDepth      = 8
+	jolietNameMax    = 64
+	applicationID    = "CLOUDINIT-BUILDER"
+	rockRidgeExtID   = "RRIP_1991A"
+	rockRidgeExtDesc = "THE ROCK RIDGE INTERCHANGE PROTOCOL PROVIDES SUPPORT FOR POSIX FILE SYSTEM SEMANTICS"
+	rockRidgeExtSrc  = "PLEASE CONTACT DISC PUBLISHER FOR SPECIFICATION SOURCE.  SEE PUBLISHER IDENTIFIER IN PRIMARY VOLUME DESCRIPTOR FOR CONTACT INFORMATION."
+)
+
+const (
+	treePrimary = 0
+	treeJoliet  = 1
+)
+
+// Options configures the generated image.
+type Options struct {
+	VolumeID  string
+	Joliet    bool
+	RockRidge bool
+	// Created is used for the volume descriptors and for entries without an
+	// explicit modification time. The current time is used when zero.
+	Created time.Time
+}
+
+// File describes a regular file placed into the image.
+type File struct {
+	// Path is the slash separated location inside the image.
+	Path string
+	// Source is read from disk when Data is nil.
+	Source  string
+	Data    []byte
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// Image collects files and serialises them as an ISO9660 volume.
+type Image struct {
+	opts Options
+	root *node
+}
+
+type node struct {
+	name     string
+	dir      bool
+	parent   *node
+	children []*node
+	file     File
+	size     int64
+	mode     fs.FileMode
+	modTime  time.Time
+
+	ident  [2][]byte
+	sorted [2][]*node
+	number [2]int
+	loc    [2]uint32
+	extent [2]uint32
+	data   uint32
+}
+
+// New returns an empty image using opts.
+func New(opts Options) *Image {
+	if opts.VolumeID == "" {
+		opts.VolumeID = "CDROM"
+	}
+	if opts.Created.IsZero() {
+		opts.Created = time.Now()
+	}
+	opts.Created = opts.Created.UTC()
+	return &Image{
+		opts: opts,
+		root: &node{dir: true, mode: fs.ModeDir | 0o755, modTime: opts.Created},
+	}
+}
+
+// AddFile registers f, creating intermediate directories as needed.
+func (img *Image) AddFile(f File) error {
+	clean := path.Clean("/" + filepath.ToSlash(f.Path))
+	if clean == "/" {
+		return errors.New("iso9660: empty file path")
+	}
+	parts := strings.Split(strings.TrimPrefix(clean, "/"), "/")
+	// The root is the first of the eight directory levels ISO9660 allows.
+	if len(parts) > maxDirDepth {
+		return fmt.Errorf("iso9660: %s is nested deeper than %d directory levels", clean, maxDirDepth)
+	}
+	parent := img.root
+	for _, part := range parts[:len(parts)-1] {
+		next := parent.child(part)
+		if next == nil {
+			next = &node{name: part, dir: true, parent: parent, mode: fs.ModeDir | 0o755, modTime: img.opts.Created}
+			parent.children = append(parent.children, next)
+		} else if !next.dir {
+			return fmt.Errorf("iso9660: %s is a file, cannot hold %s", part, clean)
+		}
+		parent = next
+	}
+	name := parts[len(parts)-1]
+	if parent.child(name) != nil {
+		return fmt.Errorf("iso9660: duplicate path %s", clean)
+	}
+
+	n := &node{name: name, parent: parent, file: f, mode: f.Mode.Perm(), modTime: f.ModTime}
+	if f.Data != nil || f.Source == "" {
+		n.size = int64(len(f.Data))
+	} else {
+		info, err := os.Stat(f.Source)
+		if err != nil {
+			return fmt.Errorf("iso9660: stat %s: %w", f.Source, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("iso9660: %s is not a regular file", f.Source)
+		}
+		n.size = info.Size()
+		if n.modTime.IsZero() {
+			n.modTime = info.ModTime()
+		}
+	}
+	if n.size > int64(^uint32(0)) {
+		return fmt.Errorf("iso9660: %s exceeds the 4 GiB file size limit", clean)
+	}
+	if n.mode == 0 {
+		n.mode = 0o644
+	}
+	if n.modTime.IsZero() {
+		n.modTime = img.opts.Created
+	}
+	n.modTime = n.modTime.UTC()
+	parent.children = append(parent.children, n)
+	return nil
+}
+
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the image to dest, replacing any existing file.
+func (img *Image) WriteFile(dest string) error {
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := img.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// WriteTo serialises the image into w.
+func (img *Image) WriteTo(w io.Writer) (int64, error) {
+	l, err := img.layout()
+	if err != nil {
+		return 0, err
+	}
+	sw := &sectorWriter{w: bufio.NewWriter(w)}
+
+	sw.padTo(systemAreaSize)
+	sw.write(img.volumeDescriptor(l, treePrimary))
+	if img.opts.Joliet {
+		sw.write(img.volumeDescriptor(l, treeJoliet))
+	}
+	terminator := make([]byte, sectorSize)
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+	sw.write(terminator)
+
+	for _, tree := range l.trees() {
+		sw.padTo(l.pathTable[tree][0])
+		sw.write(pathTable(l.dirs[tree], tree, binary.LittleEndian))
+		sw.padTo(l.pathTable[tree][1])
+		sw.write(pathTable(l.dirs[tree], tree, binary.BigEndian))
+	}
+	for _, tree := range l.trees() {
+		for _, d := range l.dirs[tree] {
+			sw.padTo(d.loc[tree])
+			for _, rec := range img.directoryRecords(d, tree, l) {
+				if rem := sectorSize - int(sw.n%sectorSize); len(rec) > rem {
+					sw.write(make([]byte, rem))
+				}
+				sw.write(rec)
+			}
+		}
+		if tree == treePrimary && img.opts.RockRidge {
+			sw.padTo(l.continuation)
+			sw.write(rockRidgeER())
+		}
+	}
+	for _, f := range l.files {
+		if f.size == 0 {
+			continue
+		}
+		sw.padTo(f.data)
+		if err := sw.copyFile(f); err != nil {
+			return sw.n, err
+		}
+	}
+	sw.padTo(l.total)
+	if sw.err != nil {
+		return sw.n, sw.err
+	}
+	return sw.n, sw.w.Flush()
+}
+
+type layout struct {
+	dirs         [2][]*node
+	files        []*node
+	pathSize     [2]int
+	pathTable    [2][2]uint32
+	continuation uint32
+	total        uint32
+	joliet       bool
+}
+
+func (l *layout) trees() []int {
+	if l.joliet {
+		return []int{treePrimary, treeJoliet}
+	}
+	return []int{treePrimary}
+}
+
+func (img *Image) layout() (*layout, error) {
+	l := &layout{joliet: img.opts.Joliet}
+	if err := img.assignNames(img.root); err != nil {
+		return nil, err
+	}
+	for _, tree := range l.trees() {
+		queue := []*node{img.root}
+		for len(queue) > 0 {
+			d := queue[0]
+			queue = queue[1:]
+			l.dirs[tree] = append(l.dirs[tree], d)
+			d.number[tree] = len(l.dirs[tree])
+			for _, c := range d.sorted[tree] {
+				if c.dir {
+					queue = append(queue, c)
+				}
+			}
+		}
+		for _, d := range l.dirs[tree] {
+			l.pathSize[tree] += pathEntrySize(d.ident[tree])
+		}
+	}
+	for _, d := range l.dirs[treePrimary] {
+		for _, c := range d.sorted[treePrimary] {
+			if !c.dir {
+				l.files = append(l.files, c)
+			}
+		}
+	}
+
+	next := uint32(systemAreaSize + 2)
+	if img.opts.Joliet {
+		next++
+	}
+	for _, tree := range l.trees() {
+		for i := range l.pathTable[tree] {
+			l.pathTable[tree][i] = next
+			next += sectorsFor(int64(l.pathSize[tree]))
+		}
+	}
+	for _, tree := range l.trees() {
+		for _, d := range l.dirs[tree] {
+			d.extent[tree] = img.directorySize(d, tree)
+			d.loc[tree] = next
+			next += d.extent[tree] / sectorSize
+		}
+		if tree == treePrimary && img.opts.RockRidge {
+			l.continuation = next
+			next++
+		}
+	}
+	for _, f := range l.files {
+		f.data = next
+		next += sectorsFor(f.size)
+	}
+	l.total = next
+	return l, nil
+}
+
+func (img *Image) assignNames(d *node) error {
+	used := [2]map[string]bool{{}, {}}
+	for _, c := range d.children {
+		c.ident[treePrimary] = []byte(uniqueName(isoIdentifier(c.name, c.dir), c.dir, used[treePrimary], 8))
+		jolietLimit := jolietNameMax
+		if !c.dir {
+			jolietLimit -= 2
+		}
+		c.ident[treeJoliet] = ucs2(uniqueName(jolietName(c.name), c.dir, used[treeJoliet], jolietLimit))
+		if img.opts.RockRidge {
+			if size := 33 + len(c.ident[treePrimary]) + 1 + len(img.rockRidgeEntries(c, c.name)); size > maxRecordLength {
+				return fmt.Errorf("iso9660: name too long: %s", c.name)
+			}
+		}
+	}
+	for tree := range d.sorted {
+		d.sorted[tree] = append([]*node(nil), d.children...)
+		sort.Slice(d.sorted[tree], func(i, j int) bool {
+			return string(d.sorted[tree][i].ident[tree]) < string(d.sorted[tree][j].ident[tree])
+		})
+	}
+	for _, c := range d.children {
+		if c.dir {
+			if err := img.assignNames(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isoIdentifier maps name onto the 8.3 d-character set of ISO9660 level 1.
+func isoIdentifier(name string, dir bool) string {
+	mapChars := func(s string) string {
+		var b strings.Builder
+		for _, r := range strings.ToUpper(s) {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+		return b.String()
+	}
+	if dir {
+		return truncate(mapChars(name), 8)
+	}
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	return truncate(mapChars(base), 8) + "." + truncate(mapChars(ext), 3)
+}
+
+func jolietName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r > 0xFFFF || r < 0x20 || strings.ContainsRune(`*/:;?\`, r) {
+			b.WriteByte('_')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// uniqueName resolves collisions by overwriting the tail of the base name
+// with a counter, keeping the result within limit characters. Names that
+// came out of isoIdentifier keep their extension intact.
+func uniqueName(name string, dir bool, used map[string]bool, limit int) string {
+	base, ext := name, ""
+	if !dir && limit == 8 {
+		i := strings.LastIndex(name, ".")
+		base, ext = name[:i], name[i:]
+	}
+	base = truncate(base, limit)
+	candidate := base + ext
+	for n := 1; used[candidate]; n++ {
+		suffix := fmt.Sprint(n)
+		candidate = truncate(base, limit-len(suffix)) + suffix + ext
+	}
+	used[candidate] = true
+	if !dir {
+		candidate += ";1"
+	}
+	return candidate
+}
+
+func truncate(s string, limit int) string {
+	r := []rune(s)
+	if len(r) > limit {
+		return string(r[:limit])
+	}
+	return s
+}
+
+func ucs2(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(out[2*i:], u)
+	}
+	return out
+}
+
+func pathEntrySize(ident []byte) int {
+	n := len(ident)
+	if n == 0 {
+		n = 1
+	}
+	return 8 + n + n%2
+}
+
+func pathTable(dirs []*node, tree int, order binary.ByteOrder) []byte {
+	var out []byte
+	for _, d := range dirs {
+		ident := d.ident[tree]
+		if d.parent == nil {
+			ident = []byte{0}
+		}
+		entry := make([]byte, pathEntrySize(ident))
+		entry[0] = byte(len(ident))
+		order.PutUint32(entry[2:], d.loc[tree])
+		parent := 1
+		if d.parent != nil {
+			parent = d.parent.number[tree]
+		}
+		order.PutUint16(entry[6:], uint16(parent))
+		copy(entry[8:], ident)
+		out = append(out, entry...)
+	}
+	return out
+}
+
+func (img *Image) directorySize(d *node, tree int) uint32 {
+	var size, pos int
+	for _, rec := range img.directoryRecords(d, tree, nil) {
+		if pos+len(rec) > sectorSize {
+			size += sectorSize
+			pos = 0
+		}
+		pos += len(rec)
+	}
+	return uint32(size + sectorSize)
+}
+
+// directoryRecords builds the records of d. Locations are only filled in
+// when l is non-nil; record lengths do not depend on them.
+func (img *Image) directoryRecords(d *node, tree int, l *layout) [][]byte {
+	parent := d.parent
+	if parent == nil {
+		parent = d
+	}
+	rr := img.opts.RockRidge && tree == treePrimary
+	var selfSU, parentSU []byte
+	if rr {
+		if d.parent == nil {
+			selfSU = append(selfSU, 'S', 'P', 7, 1, 0xBE, 0xEF, 0)
+		}
+		selfSU = append(selfSU, img.rockRidgeEntries(d, "")...)
+		if d.parent == nil {
+			var at uint32
+			if l != nil {
+				at = l.continuation
+			}
+			selfSU = append(selfSU, continuationEntry(at, uint32(len(rockRidgeER())))...)
+		}
+		parentSU = img.rockRidgeEntries(parent, "")
+	}
+	records := [][]byte{
+		dirRecord([]byte{0}, d.loc[tree], d.extent[tree], d.modTime, true, selfSU),
+		dirRecord([]byte{1}, parent.loc[tree], parent.extent[tree], parent.modTime, true, parentSU),
+	}
+	for _, c := range d.sorted[tree] {
+		var su []byte
+		if rr {
+			su = img.rockRidgeEntries(c, c.name)
+		}
+		loc, size := c.data, uint32(c.size)
+		if c.dir {
+			loc, size = c.loc[tree], c.extent[tree]
+		}
+		records = append(records, dirRecord(c.ident[tree], loc, size, c.modTime, c.dir, su))
+	}
+	return records
+}
+
+func dirRecord(ident []byte, loc, size uint32, t time.Time, dir bool, su []byte) []byte {
+	length := 33 + len(ident)
+	if length%2 == 1 {
+		length++
+	}
+	rec := make([]byte, length, length+len(su)+1)
+	putBoth32(rec[2:], loc)
+	putBoth32(rec[10:], size)
+	copy(rec[18:25], recordTime(t))
+	if dir {
+		rec[25] = 0x02
+	}
+	putBoth16(rec[28:], 1)
+	rec[32] = byte(len(ident))
+	copy(rec[33:], ident)
+	rec = append(rec, su...)
+	if len(rec)%2 == 1 {
+		rec = append(rec, 0)
+	}
+	rec[0] = byte(len(rec))
+	return rec
+}
+
+// rockRidgeEntries returns the PX, TF and, when name is set, NM entries.
+func (img *Image) rockRidgeEntries(n *node, name string) []byte {
+	px := make([]byte, 36)
+	copy(px, "PX")
+	px[2], px[3] = 36, 1
+	mode := uint32(n.mode.Perm())
+	nlink := uint32(1)
+	if n.dir {
+		mode |= 0o040000
+		nlink = 2
+		for _, c := range n.children {
+			if c.dir {
+				nlink++
+			}
+		}
+	} else {
+		mode |= 0o100000
+	}
+	putBoth32(px[4:], mode)
+	putBoth32(px[12:], nlink)
+
+	tf := []byte{'T', 'F', 26, 1, 0x0E}
+	stamp := recordTime(n.modTime)
+	for i := 0; i < 3; i++ {
+		tf = append(tf, stamp...)
+	}
+
+	out := append(px, tf...)
+	if name != "" {
+		out = append(out, 'N', 'M', byte(5+len(name)), 1, 0)
+		out = append(out, name...)
+	}
+	return out
+}
+
+func continuationEntry(loc, length uint32) []byte {
+	ce := make([]byte, 28)
+	copy(ce, "CE")
+	ce[2], ce[3] = 28, 1
+	putBoth32(ce[4:], loc)
+	putBoth32(ce[12:], 0)
+	putBoth32(ce[20:], length)
+	return ce
+}
+
+func rockRidgeER() []byte {
+	er := []byte{'E', 'R', byte(8 + len(rockRidgeExtID) + len(rockRidgeExtDesc) + len(rockRidgeExtSrc)), 1,
+		byte(len(rockRidgeExtID)), byte(len(rockRidgeExtDesc)), byte(len(rockRidgeExtSrc)), 1}
+	er = append(er, rockRidgeExtID...)
+	er = append(er, rockRidgeExtDesc...)
+	return append(er, rockRidgeExtSrc...)
+}
+
+func (img *Image) volumeDescriptor(l *layout, tree int) []byte {
+	vd := make([]byte, sectorSize)
+	vd[0] = 1
+	text := func(s string, size int) []byte { return padASCII(s, size) }
+	if tree == treeJoliet {
+		vd[0] = 2
+		copy(vd[88:], "%/E")
+		text = padUCS2
+	}
+	copy(vd[1:], "CD001")
+	vd[6] = 1
+	copy(vd[8:40], text("", 32))
+	copy(vd[40:72], text(img.opts.VolumeID, 32))
+	putBoth32(vd[80:], l.total)
+	putBoth16(vd[120:], 1)
+	putBoth16(vd[124:], 1)
+	putBoth16(vd[128:], sectorSize)
+	putBoth32(vd[132:], uint32(l.pathSize[tree]))
+	binary.LittleEndian.PutUint32(vd[140:], l.pathTable[tree][0])
+	binary.BigEndian.PutUint32(vd[148:], l.pathTable[tree][1])
+	root := img.root
+	copy(vd[156:190], dirRecord([]byte{0}, root.loc[tree], root.extent[tree], root.modTime, true, nil))
+	copy(vd[190:318], text("", 128))
+	copy(vd[318:446], text("", 128))
+	copy(vd[446:574], text("", 128))
+	copy(vd[574:702], text(applicationID, 128))
+	copy(vd[702:739], text("", 37))
+	copy(vd[739:776], text("", 37))
+	copy(vd[776:813], text("", 37))
+	created := volumeTime(img.opts.Created)
+	copy(vd[813:830], created)
+	copy(vd[830:847], created)
+	copy(vd[847:864], volumeTime(time.Time{}))
+	copy(vd[864:881], created)
+	vd[881] = 1
+	return vd
+}
+
+func padASCII(s string, size int) []byte {
+	out := []byte(strings.Repeat(" ", size))
+	copy(out, s)
+	return out
+}
+
+func padUCS2(s string, size int) []byte {
+	out := make([]byte, size)
+	for i := 0; i+1 < size; i += 2 {
+		out[i+1] = ' '
+	}
+	enc := ucs2(s)
+	if len(enc) > size-size%2 {
+		enc = enc[:size-size%2]
+	}
+	copy(out, enc)
+	return out
+}
+
+func recordTime(t time.Time) []byte {
+	t = t.UTC()
+	return []byte{byte(t.Year() - 1900), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0}
+}
+
+func volumeTime(t time.Time) []byte {
+	if t.IsZero() {
+		return append([]byte(strings.Repeat("0", 16)), 0)
+	}
+	t = t.UTC()
+	s := fmt.Sprintf("%04d%02d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1e7)
+	return append([]byte(s), 0)
+}
+
+func putBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func sectorsFor(size int64) uint32 {
+	return uint32((size + sectorSize - 1) / sectorSize)
+}
+
+type sectorWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (sw *sectorWriter) write(b []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(b)
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func (sw *sectorWriter) padTo(sector uint32) {
+	target := int64(sector) * sectorSize
+	if sw.err == nil && sw.n > target {
+		sw.err = fmt.Errorf("iso9660: layout overrun at sector %d", sector)
+		return
+	}
+	for sw.err == nil && sw.n < target {
+		chunk := target - sw.n
+		if chunk > sectorSize {
+			chunk = sectorSize
+		}
+		sw.write(make([]byte, chunk))
+	}
+}
+
+func (sw *sectorWriter) copyFile(n *node) error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if n.file.Data != nil || n.file.Source == "" {
+		sw.write(n.file.Data)
+		return sw.err
+	}
+	src, err := os.Open(n.file.Source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	written, err := io.CopyN(sw.w, src, n.size)
+	sw.n += written
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("iso9660: %s shrank while writing", n.file.Source)
+		}
+		return err
+	}
+	return nil
+}
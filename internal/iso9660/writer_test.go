@@ -0,0 +1,204 @@
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeImage(t *testing.T, opts Options, files []File) *Reader {
+	t.Helper()
+	img := New(opts)
+	for _, f := range files {
+		if err := img.AddFile(f); err != nil {
+			t.Fatalf("AddFile(%s): %v", f.Path, err)
+		}
+	}
+	var buf bytes.Buffer
+	n, err := img.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n%sectorSize != 0 || int64(buf.Len()) != n {
+		t.Fatalf("image size %d (reported %d) is not a whole number of sectors", buf.Len(), n)
+	}
+	rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	return rd
+}
+
+func TestRoundTrip(t *testing.T) {
+	stamp := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	longName := "a-directory-name-well-beyond-the-eight-characters-of-level-1"
+	files := []File{
+		{Path: "user-data", Data: []byte("#cloud-config\nhostname: edge1\n"), Mode: 0o600},
+		{Path: "meta-data", Data: []byte("instance-id: edge1\n")},
+		{Path: "empty", Data: []byte{}},
+		{Path: "openstack/latest/meta_data.json", Data: []byte(`{"uuid":"x"}`)},
+		{Path: longName + "/file.txt", Data: bytes.Repeat([]byte("x"), 3*sectorSize+17)},
+	}
+	tests := []struct {
+		name      string
+		opts      Options
+		rockRidge bool
+		joliet    bool
+		// names maps the written path to the path the reader reports.
+		names map[string]string
+	}{
+		{
+			name:      "rock ridge and joliet",
+			opts:      Options{VolumeID: "cidata", Joliet: true, RockRidge: true, Created: stamp},
+			rockRidge: true,
+		},
+		{
+			name:   "joliet only",
+			opts:   Options{VolumeID: "cidata", Joliet: true, Created: stamp},
+			joliet: true,
+		},
+		{
+			name: "plain iso9660",
+			opts: Options{VolumeID: "CIDATA", Created: stamp},
+			names: map[string]string{
+				"user-data":                       "USER_DAT",
+				"meta-data":                       "META_DAT",
+				"empty":                           "EMPTY",
+				"openstack/latest/meta_data.json": "OPENSTAC/LATEST/META_DAT.JSO",
+				longName + "/file.txt":            "A_DIRECT/FILE.TXT",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := writeImage(t, tt.opts, files)
+			if rd.VolumeID() != tt.opts.VolumeID {
+				t.Errorf("VolumeID() = %q, want %q", rd.VolumeID(), tt.opts.VolumeID)
+			}
+			if rd.RockRidge() != tt.rockRidge || rd.Joliet() != tt.joliet {
+				t.Errorf("RockRidge() = %v, Joliet() = %v, want %v, %v", rd.RockRidge(), rd.Joliet(), tt.rockRidge, tt.joliet)
+			}
+			for _, f := range files {
+				want := f.Path
+				if tt.names != nil {
+					want = tt.names[f.Path]
+				}
+				e := rd.Lookup(want)
+				if e == nil {
+					t.Errorf("%s: %s not found", f.Path, want)
+					continue
+				}
+				got, err := rd.ReadFile(want)
+				if err != nil {
+					t.Fatalf("ReadFile(%s): %v", want, err)
+				}
+				if !bytes.Equal(got, f.Data) {
+					t.Errorf("%s: content differs: got %d bytes, want %d", want, len(got), len(f.Data))
+				}
+				if !e.ModTime.Equal(stamp) {
+					t.Errorf("%s: ModTime = %v, want %v", want, e.ModTime, stamp)
+				}
+			}
+			if tt.rockRidge {
+				if e := rd.Lookup("user-data"); e.Mode.Perm() != 0o600 {
+					t.Errorf("user-data mode = %v, want 0600", e.Mode.Perm())
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripDeterministic(t *testing.T) {
+	build := func() []byte {
+		img := New(Options{VolumeID: "cidata", Joliet: true, RockRidge: true, Created: time.Unix(1700000000, 0)})
+		for _, p := range []string{"b", "a", "c/d"} {
+			if err := img.AddFile(File{Path: p, Data: []byte(p)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, err := img.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	if !bytes.Equal(build(), build()) {
+		t.Error("identical input produced different images")
+	}
+}
+
+func TestNameCollisions(t *testing.T) {
+	files := []File{
+		{Path: "network-config", Data: []byte("1")},
+		{Path: "network-config-v2", Data: []byte("2")},
+		{Path: "network_config", Data: []byte("3")},
+	}
+	rd := writeImage(t, Options{Created: time.Unix(0, 0)}, files)
+	seen := map[string]bool{}
+	for _, e := range rd.Entries() {
+		if seen[e.Path] {
+			t.Errorf("duplicate identifier %s", e.Path)
+		}
+		seen[e.Path] = true
+	}
+	if len(seen) != len(files) {
+		t.Errorf("got %d entries, want %d", len(seen), len(files))
+	}
+}
+
+func TestAddFileErrors(t *testing.T) {
+	deep := strings.Repeat("d/", maxDirDepth) + "file"
+	tests := []struct {
+		name  string
+		opts  Options
+		files []string
+		want  string
+	}{
+		{name: "empty path", files: []string{"/"}, want: "empty file path"},
+		{name: "duplicate", files: []string{"a/b", "a/b"}, want: "duplicate path"},
+		{name: "file as directory", files: []string{"a", "a/b"}, want: "is a file"},
+		{name: "too deep", files: []string{deep}, want: "deeper than 8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := New(tt.opts)
+			var err error
+			for _, p := range tt.files {
+				if err = img.AddFile(File{Path: p, Data: []byte("x")}); err != nil {
+					break
+				}
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+	ok := strings.Repeat("d/", maxDirDepth-1) + "file"
+	if err := New(Options{}).AddFile(File{Path: ok}); err != nil {
+		t.Errorf("AddFile(%s) at the depth limit: %v", ok, err)
+	}
+}
+
+func TestLongRockRidgeNames(t *testing.T) {
+	long := strings.Repeat("n", 200)
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "file", path: long},
+		{name: "directory", path: long + "/file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := New(Options{RockRidge: true})
+			if err := img.AddFile(File{Path: tt.path, Data: []byte("x")}); err != nil {
+				t.Fatal(err)
+			}
+			_, err := img.WriteTo(&bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), "name too long") {
+				t.Fatalf("error = %v, want name too long", err)
+			}
+		})
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"time"
 
 	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/redact"
 )
 
 // NewOperationLogger creates a timestamped log file within baseDir/logs.
+// Registered secrets are masked in every line written through the logger.
 // The caller is responsible for closing the returned file handle.
 func NewOperationLogger(baseDir, prefix string) (*log.Logger, *os.File, string, error) {
 	if baseDir == "" {
@@ -29,7 +31,7 @@ func NewOperationLogger(baseDir, prefix string) (*log.Logger, *os.File, string,
 	if err != nil {
 		return nil, nil, "", err
 	}
-	logger := log.New(f, "", log.LstdFlags)
+	logger := log.New(redact.Writer(f), "", log.LstdFlags)
 	logger.Printf("starting %s operation", prefix)
 	return logger, f, fullPath, nil
 }
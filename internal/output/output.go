@@ -1,6 +1,12 @@
 package output
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/redact"
+)
 
 var quiet bool
 
@@ -9,12 +15,17 @@ func SetQuiet(v bool) {
 	quiet = v
 }
 
+// Quiet reports whether console output is currently suppressed.
+func Quiet() bool {
+	return quiet
+}
+
 // Println prints a line unless quiet mode is enabled.
 func Println(msg string) {
 	if quiet {
 		return
 	}
-	fmt.Println(msg)
+	fmt.Println(redact.String(msg))
 }
 
 // Printf prints formatted text unless quiet mode is enabled.
@@ -22,5 +33,39 @@ func Printf(format string, args ...interface{}) {
 	if quiet {
 		return
 	}
-	fmt.Printf(format, args...)
+	fmt.Print(redact.String(fmt.Sprintf(format, args...)))
+}
+
+// progressWidth is the length of the line last drawn by Progress, so a
+// shorter update can blank out its tail.
+var progressWidth int
+
+// Progress redraws the current console line with msg unless quiet mode is
+// enabled or stdout is not a terminal. Call EndProgress before printing
+// anything else.
+func Progress(msg string) {
+	if quiet || !stdoutIsTerminal() {
+		return
+	}
+	msg = redact.String(msg)
+	pad := progressWidth - len(msg)
+	if pad < 0 {
+		pad = 0
+	}
+	fmt.Print("\r" + msg + strings.Repeat(" ", pad))
+	progressWidth = len(msg)
+}
+
+// EndProgress finishes the line drawn by Progress, if any.
+func EndProgress() {
+	if progressWidth == 0 {
+		return
+	}
+	progressWidth = 0
+	fmt.Println()
+}
+
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,74 @@
+// Package redact masks registered secret values in log lines, command
+// strings and console messages.
+package redact
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every registered value.
+const Mask = "[REDACTED]"
+
+// minLength keeps very short values from masking unrelated text.
+const minLength = 4
+
+var (
+	mu     sync.RWMutex
+	values []string
+)
+
+// Add registers values that must never appear in logs. Values shorter than
+// four characters are ignored.
+func Add(secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range secrets {
+		if len(s) < minLength || contains(values, s) {
+			continue
+		}
+		values = append(values, s)
+	}
+	// Longest first, so a secret containing another is masked as a whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+}
+
+// String returns s with every registered value replaced by Mask.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, v := range values {
+		if strings.Contains(s, v) {
+			s = strings.ReplaceAll(s, v, Mask)
+		}
+	}
+	return s
+}
+
+// Writer returns a writer that redacts each write before passing it to w.
+// Every write must carry complete values, as log.Logger's line writes do.
+func Writer(w io.Writer) io.Writer {
+	return writer{w: w}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+func (rw writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package redact
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	Add("s3cret-password", "ABCD-EFGH-IJKL-MNOP", "abc", "", "s3cret")
+	Add("s3cret-password")
+	tests := []struct {
+		in, want string
+	}{
+		{"nothing to hide", "nothing to hide"},
+		{"password=s3cret-password", "password=" + Mask},
+		{"short abc values are kept", "short abc values are kept"},
+		{"code ABCD-EFGH-IJKL-MNOP and again ABCD-EFGH-IJKL-MNOP", "code " + Mask + " and again " + Mask},
+		{"prefix s3cret alone", "prefix " + Mask + " alone"},
+		{"s3cret-passwords", Mask + "s"},
+	}
+	for _, tt := range tests {
+		if got := String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	Add("writer-secret-value")
+	var buf bytes.Buffer
+	logger := log.New(Writer(&buf), "", 0)
+	logger.Printf("running genisoimage --activation writer-secret-value")
+	if got, want := buf.String(), "running genisoimage --activation "+Mask+"\n"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+	n, err := Writer(&bytes.Buffer{}).Write([]byte("writer-secret-value"))
+	if err != nil || n != len("writer-secret-value") {
+		t.Errorf("Write() = %d, %v, want the input length", n, err)
+	}
+}
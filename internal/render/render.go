@@ -0,0 +1,270 @@
+// Package render expands seed templates as Go text/template documents.
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/crypt"
+)
+
+// EnvPrefix marks environment variables that become template variables:
+// CLOUDINIT_BUILDER_VAR_hostname=vce1 sets "hostname".
+const EnvPrefix = "CLOUDINIT_BUILDER_VAR_"
+
+// jinjaHeader marks templates that cloud-init renders itself on the guest.
+const jinjaHeader = "## template: jinja"
+
+// Vars holds template variables. Nested maps come from YAML files or from
+// dotted keys such as "wan.address".
+type Vars map[string]interface{}
+
+// LoadFile reads variables from a YAML (or JSON) mapping.
+func LoadFile(path string) (Vars, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vars := Vars{}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// FromEnv returns the variables defined through EnvPrefix in environ.
+func FromEnv(environ []string) Vars {
+	vars := Vars{}
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) || key == EnvPrefix {
+			continue
+		}
+		vars.put(strings.TrimPrefix(key, EnvPrefix), value)
+	}
+	return vars
+}
+
+// Set applies a key=value assignment. Dotted keys create nested maps.
+func (v Vars) Set(assignment string) error {
+	key, value, ok := strings.Cut(assignment, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("invalid variable %q: expected key=value", assignment)
+	}
+	v.put(key, value)
+	return nil
+}
+
+// Merge copies every variable of other into v, recursing into nested maps.
+func (v Vars) Merge(other Vars) {
+	for k, val := range other {
+		src, srcMap := asMap(val)
+		if dst, ok := asMap(v[k]); ok && srcMap {
+			dst.Merge(src)
+			continue
+		}
+		v[k] = val
+	}
+}
+
+// Clone returns a deep copy of v, so per-edge variables do not leak between builds.
+func (v Vars) Clone() Vars {
+	out := make(Vars, len(v))
+	for k, val := range v {
+		if m, ok := asMap(val); ok {
+			val = map[string]interface{}(m.Clone())
+		}
+		out[k] = val
+	}
+	return out
+}
+
+// Keys returns the sorted top-level variable names.
+func (v Vars) Keys() []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// asMap returns val as Vars if it is a nested mapping. yaml.v3 decodes nested
+// mappings of a Vars document as Vars rather than map[string]interface{}.
+func asMap(val interface{}) (Vars, bool) {
+	switch m := val.(type) {
+	case Vars:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	return nil, false
+}
+
+func (v Vars) put(key, value string) {
+	parts := strings.Split(key, ".")
+	m := map[string]interface{}(v)
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := asMap(m[p])
+		if !ok {
+			next = Vars{}
+			m[p] = map[string]interface{}(next)
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
+
+// Renderer expands templates found in a single directory.
+type Renderer struct {
+	dir     string
+	vars    Vars
+	secrets map[string]string
+	// saltSeed, when set, makes sha512crypt derive its salt from it.
+	saltSeed string
+}
+
+// New returns a Renderer for templates in dir. Relative include paths are
+// resolved against dir.
+func New(dir string, vars Vars) *Renderer {
+	if vars == nil {
+		vars = Vars{}
+	}
+	return &Renderer{dir: dir, vars: vars}
+}
+
+// WithSecrets makes secrets available to the secret template function.
+// Secrets are never exposed as variables, so they cannot leak through
+// variable listings.
+func (r *Renderer) WithSecrets(secrets map[string]string) *Renderer {
+	r.secrets = secrets
+	return r
+}
+
+// WithReproducibleSalt makes sha512crypt derive its salt from seed and the
+// password instead of drawing a random one, so that reproducible builds stay
+// byte-identical.
+func (r *Renderer) WithReproducibleSalt(seed string) *Renderer {
+	r.saltSeed = seed
+	return r
+}
+
+// Render expands data, named name in error messages. Referencing a variable
+// that is not defined is an error. Templates starting with the cloud-init
+// "## template: jinja" header are returned unchanged.
+func (r *Renderer) Render(name string, data []byte) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), jinjaHeader) {
+		return data, nil
+	}
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(r.funcs()).
+		Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]interface{}(r.vars)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *Renderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"b64dec": func(s string) (string, error) {
+			out, err := base64.StdEncoding.DecodeString(s)
+			return string(out), err
+		},
+		"indent":  indent,
+		"nindent": func(n int, s string) string { return "\n" + indent(n, s) },
+		"sha512crypt": func(password string) (string, error) {
+			if r.saltSeed != "" {
+				return crypt.SHA512Derived(password, r.saltSeed)
+			}
+			return crypt.SHA512(password)
+		},
+		"include": r.include,
+		"get":     r.get,
+		"secret":  r.secret,
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if v == nil || v == "" {
+				return nil, errors.New(msg)
+			}
+			return v, nil
+		},
+		// default cannot catch a missing variable written as .name, which
+		// fails before default runs; use (get "name") or (index . "name").
+		"default": func(def, v interface{}) interface{} {
+			if v == nil || v == "" {
+				return def
+			}
+			return v
+		},
+		"quote": func(s string) string { return fmt.Sprintf("%q", s) },
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"trim":  strings.TrimSpace,
+	}
+}
+
+// get returns the variable at the dotted path key, or nil when it or one of
+// its parents is not defined, for use with default.
+func (r *Renderer) get(key string) interface{} {
+	var val interface{} = map[string]interface{}(r.vars)
+	for _, part := range strings.Split(key, ".") {
+		m, ok := asMap(val)
+		if !ok {
+			return nil
+		}
+		if val, ok = m[part]; !ok {
+			return nil
+		}
+	}
+	return val
+}
+
+// secret returns the named secret; an undefined secret is an error.
+func (r *Renderer) secret(name string) (string, error) {
+	v, ok := r.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %q is not defined", name)
+	}
+	return v, nil
+}
+
+// include returns the contents of path, relative to the template directory.
+func (r *Renderer) include(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.dir, filepath.FromSlash(path))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("include: %w", err)
+	}
+	return string(data), nil
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = pad + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,205 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "motd.txt"), []byte("line1\nline2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vars := Vars{}
+	for _, kv := range []string{"hostname=edge1", "wan.address=192.0.2.10", "empty="} {
+		if err := vars.Set(kv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := New(dir, vars).WithSecrets(map[string]string{"activation": "AAAA-BBBB"})
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		want    string
+		wantErr string
+	}{
+		{name: "variable", tmpl: "hostname: {{ .hostname }}", want: "hostname: edge1"},
+		{name: "nested variable", tmpl: "{{ .wan.address }}", want: "192.0.2.10"},
+		{name: "missing variable", tmpl: "{{ .nope }}", wantErr: `map has no entry for key "nope"`},
+		{name: "default", tmpl: `{{ default "x" .empty }}/{{ default "x" .hostname }}`, want: "x/edge1"},
+		{name: "default of a missing variable", tmpl: `{{ default "x" (get "nope") }}/{{ default "x" (index . "nope") }}`, want: "x/x"},
+		{name: "default of a missing nested variable", tmpl: `{{ default "x" (get "wan.gateway") }}/{{ default "x" (get "lan.ip") }}`, want: "x/x"},
+		{name: "default cannot catch .name", tmpl: `{{ default "x" .nope }}`, wantErr: `map has no entry for key "nope"`},
+		{name: "get", tmpl: `{{ get "wan.address" }} {{ get "hostname" }}`, want: "192.0.2.10 edge1"},
+		{name: "get below a scalar", tmpl: `{{ default "x" (get "hostname.sub") }}`, want: "x"},
+		{name: "required", tmpl: `{{ required "empty must be set" .empty }}`, wantErr: "empty must be set"},
+		{name: "secret", tmpl: `{{ secret "activation" }}`, want: "AAAA-BBBB"},
+		{name: "undefined secret", tmpl: `{{ secret "other" }}`, wantErr: `secret "other" is not defined`},
+		{name: "secrets are not variables", tmpl: "{{ .activation }}", wantErr: "no entry"},
+		{name: "include and indent", tmpl: "motd: |\n{{ include \"motd.txt\" | indent 2 }}", want: "motd: |\n  line1\n  line2"},
+		{name: "nindent", tmpl: `a:{{ "b: 1" | nindent 2 }}`, want: "a:\n  b: 1"},
+		{name: "include missing", tmpl: `{{ include "none.txt" }}`, wantErr: "include:"},
+		{name: "base64", tmpl: `{{ b64enc "hi" }} {{ b64dec "aGk=" }}`, want: "aGk= hi"},
+		{name: "string helpers", tmpl: `{{ upper "a" }}{{ lower "B" }}{{ trim " c " }}{{ quote "d" }}`, want: `Abc"d"`},
+		{name: "jinja templates are left alone", tmpl: "## template: jinja\nhostname: {{ v1.local_hostname }}", want: "## template: jinja\nhostname: {{ v1.local_hostname }}"},
+		{name: "parse error", tmpl: "{{ .hostname ", wantErr: "unclosed action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Render(tt.name, []byte(tt.tmpl))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSHA512CryptFunc(t *testing.T) {
+	got, err := New("", nil).Render("t", []byte(`{{ sha512crypt "pw" }}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(got), "$6$") {
+		t.Errorf("sha512crypt = %q, want a $6$ hash", got)
+	}
+}
+
+func TestSHA512CryptReproducible(t *testing.T) {
+	render := func(seed string) string {
+		t.Helper()
+		got, err := New("", nil).WithReproducibleSalt(seed).Render("t", []byte(`{{ sha512crypt "pw" }}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+	a, b := render("1700000000"), render("1700000000")
+	if a != b || !strings.HasPrefix(a, "$6$") {
+		t.Errorf("hashes with the same seed = %q, %q, want one $6$ hash", a, b)
+	}
+	if c := render("1700000001"); c == a {
+		t.Errorf("hashes with different seeds are both %q", c)
+	}
+}
+
+func TestVars(t *testing.T) {
+	tests := []struct {
+		name string
+		vars func() (Vars, error)
+		want Vars
+	}{
+		{
+			name: "dotted keys nest",
+			vars: func() (Vars, error) {
+				v := Vars{}
+				err := v.Set("wan.address=192.0.2.1")
+				if err == nil {
+					err = v.Set(" wan.gateway =a=b")
+				}
+				return v, err
+			},
+			want: Vars{"wan": map[string]interface{}{"address": "192.0.2.1", "gateway": "a=b"}},
+		},
+		{
+			name: "environment",
+			vars: func() (Vars, error) {
+				return FromEnv([]string{"CLOUDINIT_BUILDER_VAR_hostname=edge1", "CLOUDINIT_BUILDER_VAR_=x", "HOME=/root", "CLOUDINIT_BUILDER_VAR_lan.ip=10.0.0.1"}), nil
+			},
+			want: Vars{"hostname": "edge1", "lan": map[string]interface{}{"ip": "10.0.0.1"}},
+		},
+		{
+			name: "merge recurses into maps",
+			vars: func() (Vars, error) {
+				v := Vars{"wan": map[string]interface{}{"address": "a", "gateway": "g"}, "keep": "k"}
+				v.Merge(Vars{"wan": map[string]interface{}{"address": "b"}, "new": "n"})
+				return v, nil
+			},
+			want: Vars{"wan": map[string]interface{}{"address": "b", "gateway": "g"}, "keep": "k", "new": "n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.vars()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vars = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVarsSetErrors(t *testing.T) {
+	for _, kv := range []string{"novalue", "=x", " =x"} {
+		if err := (Vars{}).Set(kv); err == nil {
+			t.Errorf("Set(%q) succeeded", kv)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	orig := Vars{"wan": map[string]interface{}{"address": "a"}}
+	c := orig.Clone()
+	c["wan"].(map[string]interface{})["address"] = "b"
+	if orig["wan"].(map[string]interface{})["address"] != "a" {
+		t.Error("Clone shares nested maps with the original")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vars.yaml")
+	if err := os.WriteFile(path, []byte("hostname: edge1\nwan:\n  address: 192.0.2.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := vars.Keys(); !reflect.DeepEqual(got, []string{"hostname", "wan"}) {
+		t.Errorf("Keys() = %q", got)
+	}
+	if err := os.WriteFile(path, []byte("- a\n- b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil || !strings.Contains(err.Error(), "parse") {
+		t.Errorf("LoadFile of a list: error = %v", err)
+	}
+}
+
+func TestLoadFileMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vars.yaml")
+	if err := os.WriteFile(path, []byte("wan:\n  address: 192.0.2.1\n  gateway: 192.0.2.254\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vars.Set("wan.address=192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	c := vars.Clone()
+	c.Merge(Vars{"wan": map[string]interface{}{"gateway": "192.0.2.1"}})
+	got, err := New("", vars).Render("t", []byte("{{ .wan.address }} {{ .wan.gateway }}"))
+	if err != nil || string(got) != "192.0.2.2 192.0.2.254" {
+		t.Errorf("original = %q, %v", got, err)
+	}
+	got, err = New("", c).Render("t", []byte("{{ .wan.address }} {{ .wan.gateway }}"))
+	if err != nil || string(got) != "192.0.2.2 192.0.2.1" {
+		t.Errorf("merged clone = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,231 @@
+// Package secrets stores values such as activation codes and passwords in an
+// encrypted file or reads them from the environment, so they are only
+// resolved while templates are rendered.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/redact"
+)
+
+const (
+	// FileName is the encrypted secrets file inside the templates directory.
+	FileName = "secrets.enc"
+	// EnvPrefix marks environment variables that define secrets:
+	// CLOUDINIT_BUILDER_SECRET_activation_code sets "activation_code".
+	EnvPrefix = "CLOUDINIT_BUILDER_SECRET_"
+	// KeyEnvVar holds the passphrase of the secrets file.
+	KeyEnvVar = "CLOUDINIT_BUILDER_SECRETS_KEY"
+
+	fileVersion   = 1
+	kdfName       = "pbkdf2-sha256"
+	kdfIterations = 600000
+	// maxKDFIterations bounds the count read from a file, so that a corrupted
+	// or crafted file cannot stall the build in PBKDF2.
+	maxKDFIterations = 10 * kdfIterations
+	keyLength        = 32
+	saltLength       = 16
+	minPassphraseLen = 8
+)
+
+// ErrBadPassphrase is returned when the secrets file cannot be decrypted.
+var ErrBadPassphrase = errors.New("secrets: wrong passphrase or corrupted file")
+
+// Store maps secret names to their values.
+type Store map[string]string
+
+// Names returns the sorted secret names.
+func (s Store) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envelope is the on-disk JSON format of an encrypted secrets file.
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Load returns the secrets from the file at path, when it exists, overlaid
+// with those from the environment. passphrase is only called when the file
+// exists and KeyEnvVar is unset. Every value is registered for redaction.
+func Load(path string, passphrase func() (string, error)) (Store, error) {
+	store := Store{}
+	exists, err := fsutil.PathExists(path)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		key := os.Getenv(KeyEnvVar)
+		if key == "" {
+			if key, err = passphrase(); err != nil {
+				return nil, err
+			}
+		}
+		fileStore, err := ReadFile(path, key)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileStore {
+			store[k] = v
+		}
+	}
+	for k, v := range FromEnv(os.Environ()) {
+		store[k] = v
+	}
+	for _, v := range store {
+		redact.Add(v)
+	}
+	return store, nil
+}
+
+// FromEnv returns the secrets defined through EnvPrefix in environ.
+func FromEnv(environ []string) Store {
+	store := Store{}
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) || key == EnvPrefix {
+			continue
+		}
+		store[strings.TrimPrefix(key, EnvPrefix)] = value
+	}
+	return store
+}
+
+// ReadFile decrypts the secrets file at path.
+func ReadFile(path, passphrase string) (Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("secrets: parse %s: %w", path, err)
+	}
+	if env.Version != fileVersion || env.KDF != kdfName || env.Iterations <= 0 {
+		return nil, fmt.Errorf("secrets: unsupported file format in %s", path)
+	}
+	if env.Iterations > maxKDFIterations {
+		return nil, fmt.Errorf("secrets: %s asks for %d PBKDF2 iterations, more than the %d allowed", path, env.Iterations, maxKDFIterations)
+	}
+	salt, err1 := base64.StdEncoding.DecodeString(env.Salt)
+	nonce, err2 := base64.StdEncoding.DecodeString(env.Nonce)
+	ciphertext, err3 := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("secrets: decode %s: %w", path, err)
+	}
+	aead, err := newAEAD(passphrase, salt, env.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	store := Store{}
+	if err := yaml.Unmarshal(plain, &store); err != nil {
+		return nil, fmt.Errorf("secrets: decode contents: %w", err)
+	}
+	return store, nil
+}
+
+// WriteFile encrypts store with passphrase and writes it to path, readable
+// by the owner only.
+func WriteFile(path, passphrase string, store Store) error {
+	if len(passphrase) < minPassphraseLen {
+		return fmt.Errorf("secrets: passphrase must be at least %d characters", minPassphraseLen)
+	}
+	plain, err := yaml.Marshal(store)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(passphrase, salt, kdfIterations)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(envelope{
+		Version:    fileVersion,
+		KDF:        kdfName,
+		Iterations: kdfIterations,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plain, nil)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, keyLength))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key with PBKDF2-HMAC-SHA256 (RFC 8018).
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	out := make([]byte, 0, blocks*hashLen)
+	buf := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		buf[0], buf[1], buf[2], buf[3] = byte(block>>24), byte(block>>16), byte(block>>8), byte(block)
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
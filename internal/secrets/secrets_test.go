@@ -0,0 +1,193 @@
+package secrets
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"velocloud-cloudinit-builder/internal/redact"
+)
+
+func TestWriteReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	store := Store{"password": "Velocloud123", "velocloud.activation_code": "ABCD-EFGH-IJKL-MNOP", "multi": "a\nb: c\n"}
+	if err := WriteFile(path, "correct horse", store); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range store {
+		if strings.Contains(string(data), v) {
+			t.Errorf("%s contains %q in plain text", FileName, v)
+		}
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		mutate     func(*envelope)
+		wantErr    error
+		wantMsg    string
+	}{
+		{name: "round trip", passphrase: "correct horse"},
+		{name: "wrong passphrase", passphrase: "wrong horse", wantErr: ErrBadPassphrase},
+		{
+			name:       "tampered ciphertext",
+			passphrase: "correct horse",
+			mutate:     func(e *envelope) { e.Ciphertext = "AAAA" + e.Ciphertext[4:] },
+			wantErr:    ErrBadPassphrase,
+		},
+		{
+			name:       "short nonce",
+			passphrase: "correct horse",
+			mutate:     func(e *envelope) { e.Nonce = "AAAA" },
+			wantErr:    ErrBadPassphrase,
+		},
+		{
+			name:       "unknown version",
+			passphrase: "correct horse",
+			mutate:     func(e *envelope) { e.Version = 2 },
+			wantMsg:    "unsupported file format",
+		},
+		{
+			name:       "excessive iterations",
+			passphrase: "correct horse",
+			mutate:     func(e *envelope) { e.Iterations = 1 << 40 },
+			wantMsg:    "PBKDF2 iterations",
+		},
+		{
+			name:       "zero iterations",
+			passphrase: "correct horse",
+			mutate:     func(e *envelope) { e.Iterations = 0 },
+			wantMsg:    "unsupported file format",
+		},
+		{
+			name:       "bad base64",
+			passphrase: "correct horse",
+			mutate:     func(e *envelope) { e.Salt = "!" },
+			wantMsg:    "decode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := path
+			if tt.mutate != nil {
+				var env envelope
+				if err := json.Unmarshal(data, &env); err != nil {
+					t.Fatal(err)
+				}
+				tt.mutate(&env)
+				out, _ := json.Marshal(env)
+				file = filepath.Join(t.TempDir(), FileName)
+				if err := os.WriteFile(file, out, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := ReadFile(file, tt.passphrase)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantMsg != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantMsg)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !reflect.DeepEqual(got, store):
+				t.Errorf("ReadFile() = %v, want %v", got, store)
+			}
+		})
+	}
+}
+
+func TestWriteFileShortPassphrase(t *testing.T) {
+	err := WriteFile(filepath.Join(t.TempDir(), FileName), "short", Store{"a": "b"})
+	if err == nil || !strings.Contains(err.Error(), "at least 8") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	t.Setenv(KeyEnvVar, "")
+	t.Setenv(EnvPrefix+"api_token", "token-from-env")
+	t.Setenv(EnvPrefix+"password", "override-password")
+
+	noPrompt := func() (string, error) { return "", errors.New("prompted") }
+	store, err := Load(path, noPrompt)
+	if err != nil {
+		t.Fatalf("Load without a file: %v", err)
+	}
+	if want := (Store{"api_token": "token-from-env", "password": "override-password"}); !reflect.DeepEqual(store, want) {
+		t.Errorf("Load() = %v, want %v", store, want)
+	}
+
+	if err := WriteFile(path, "file passphrase", Store{"password": "file-password", "vco": "vco.example.net"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, noPrompt); err == nil || err.Error() != "prompted" {
+		t.Errorf("Load without a key: error = %v, want the prompt error", err)
+	}
+	store, err = Load(path, func() (string, error) { return "file passphrase", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Store{"api_token": "token-from-env", "password": "override-password", "vco": "vco.example.net"}
+	if !reflect.DeepEqual(store, want) {
+		t.Errorf("Load() = %v, want %v", store, want)
+	}
+	if got := redact.String("vco=vco.example.net token=token-from-env"); got != "vco="+redact.Mask+" token="+redact.Mask {
+		t.Errorf("loaded secrets are not redacted: %q", got)
+	}
+	if got := store.Names(); !reflect.DeepEqual(got, []string{"api_token", "password", "vco"}) {
+		t.Errorf("Names() = %q", got)
+	}
+
+	t.Setenv(KeyEnvVar, "wrong passphrase")
+	if _, err := Load(path, noPrompt); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("Load with a wrong key: error = %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	got := FromEnv([]string{EnvPrefix + "a=1", EnvPrefix + "=x", "OTHER=2", EnvPrefix + "b=x=y", EnvPrefix + "c"})
+	if want := (Store{"a": "1", "b": "x=y"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("FromEnv() = %v, want %v", got, want)
+	}
+}
+
+// TestPBKDF2 checks the key derivation against the RFC 7914 section 11
+// PBKDF2-HMAC-SHA256 vectors.
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
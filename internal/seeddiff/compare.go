@@ -0,0 +1,210 @@
+package seeddiff
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	contextLines = 2
+	maxLineDiff  = 4000
+)
+
+// compare returns the diff lines for one seed file, or nil when both sides
+// are equivalent. YAML documents are compared key by key, so reordering keys
+// or reformatting does not count as a change; anything else gets a line diff.
+func compare(name string, a, b []byte) []string {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	aDoc, aOK := parseYAML(a)
+	bDoc, bOK := parseYAML(b)
+	if aOK && bOK {
+		var out []string
+		if ah, bh := commentHeader(a), commentHeader(b); ah != bh {
+			out = append(out, fmt.Sprintf("~ header: %q -> %q", ah, bh))
+		}
+		return append(out, yamlDiff(flatten(aDoc), flatten(bDoc))...)
+	}
+	return lineDiff(splitLines(a), splitLines(b))
+}
+
+// parseYAML decodes data when it looks like a YAML mapping rather than a
+// script, MIME payload or include list.
+func parseYAML(data []byte) (interface{}, bool) {
+	h := header(data)
+	for _, prefix := range []string{"#!", "#include", "#cloud-boothook", "#part-handler", "Content-Type:", "MIME-Version:"} {
+		if strings.HasPrefix(h, prefix) {
+			return nil, false
+		}
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false
+	}
+	switch doc.(type) {
+	case map[string]interface{}, nil:
+		return doc, true
+	default:
+		return nil, false
+	}
+}
+
+func header(data []byte) string {
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(line)
+}
+
+// commentHeader returns the first line when it is a comment such as
+// #cloud-config, which selects how cloud-init reads the document.
+func commentHeader(data []byte) string {
+	if h := header(data); strings.HasPrefix(h, "#") {
+		return h
+	}
+	return ""
+}
+
+// flatten turns a decoded document into dotted key paths with rendered scalar values.
+func flatten(doc interface{}) map[string]string {
+	out := map[string]string{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			if len(t) == 0 && prefix != "" {
+				out[prefix] = "{}"
+			}
+			for k, child := range t {
+				key := k
+				if prefix != "" {
+					key = prefix + "." + k
+				}
+				walk(key, child)
+			}
+		case []interface{}:
+			if len(t) == 0 {
+				out[prefix] = "[]"
+			}
+			for i, child := range t {
+				walk(fmt.Sprintf("%s[%d]", prefix, i), child)
+			}
+		case string:
+			out[prefix] = strconv.Quote(t)
+		case nil:
+			out[prefix] = "null"
+		default:
+			out[prefix] = fmt.Sprint(t)
+		}
+	}
+	walk("", doc)
+	return out
+}
+
+func yamlDiff(a, b map[string]string) []string {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var out []string
+	for _, k := range sorted {
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			out = append(out, fmt.Sprintf("+ %s: %s", k, bv))
+		case !inB:
+			out = append(out, fmt.Sprintf("- %s: %s", k, av))
+		case av != bv:
+			out = append(out, fmt.Sprintf("~ %s: %s -> %s", k, av, bv))
+		}
+	}
+	return out
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// lineDiff renders an LCS based diff with a few lines of context around changes.
+func lineDiff(a, b []string) []string {
+	if len(a) > maxLineDiff || len(b) > maxLineDiff {
+		return []string{fmt.Sprintf("files differ (%d vs %d lines, too large to diff)", len(a), len(b))}
+	}
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type op struct {
+		mark byte
+		text string
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, op{'+', b[j]})
+			j++
+		default:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		}
+	}
+
+	show := make([]bool, len(ops))
+	for k, o := range ops {
+		if o.mark == ' ' {
+			continue
+		}
+		for n := k - contextLines; n <= k+contextLines; n++ {
+			if n >= 0 && n < len(ops) {
+				show[n] = true
+			}
+		}
+	}
+	var out []string
+	for k, o := range ops {
+		if !show[k] {
+			continue
+		}
+		if k == 0 || !show[k-1] {
+			out = append(out, "@@")
+		}
+		out = append(out, string(o.mark)+" "+o.text)
+	}
+	return out
+}
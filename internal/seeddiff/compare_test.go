@@ -0,0 +1,85 @@
+package seeddiff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{
+			name: "identical",
+			a:    "#cloud-config\nhostname: a\n",
+			b:    "#cloud-config\nhostname: a\n",
+		},
+		{
+			name: "reordered and reformatted keys",
+			a:    "#cloud-config\nhostname: a\npackages: [vim, curl]\n",
+			b:    "#cloud-config\npackages:\n  - vim\n  - curl\nhostname:   a\n",
+		},
+		{
+			name: "changed, added and removed keys",
+			a:    "#cloud-config\nhostname: a\ntimezone: UTC\nusers:\n  - name: x\n",
+			b:    "#cloud-config\nhostname: b\nusers:\n  - name: x\n    shell: /bin/sh\n",
+			want: []string{
+				`~ hostname: "a" -> "b"`,
+				`- timezone: "UTC"`,
+				`+ users[0].shell: "/bin/sh"`,
+			},
+		},
+		{
+			name: "header change",
+			a:    "#cloud-config\nhostname: a\n",
+			b:    "## template: jinja\nhostname: a\n",
+			want: []string{`~ header: "#cloud-config" -> "## template: jinja"`},
+		},
+		{
+			name: "scalar types",
+			a:    "count: 1\nflag: true\nnothing: null\n",
+			b:    "count: \"1\"\nflag: false\nnothing: {}\n",
+			want: []string{
+				`~ count: 1 -> "1"`,
+				`~ flag: true -> false`,
+				`~ nothing: null -> {}`,
+			},
+		},
+		{
+			name: "JSON is compared as YAML",
+			a:    `{"uuid": "x", "name": "a"}`,
+			b:    "{\n  \"name\": \"a\",\n  \"uuid\": \"y\"\n}\n",
+			want: []string{`~ uuid: "x" -> "y"`},
+		},
+		{
+			name: "script gets a line diff",
+			a:    "#!/bin/sh\necho one\necho two\n",
+			b:    "#!/bin/sh\necho one\necho three\n",
+			want: []string{"@@", "  #!/bin/sh", "  echo one", "- echo two", "+ echo three"},
+		},
+		{
+			name: "line endings are ignored by the line diff",
+			a:    "#!/bin/sh\r\necho one\r\n",
+			b:    "#!/bin/sh\necho one\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compare("user-data", []byte(tt.a), []byte(tt.b))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compare() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineDiffContext(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	b := []string{"1", "2", "3", "4", "x", "6", "7", "8", "9"}
+	want := []string{"@@", "  3", "  4", "- 5", "+ x", "  6", "  7"}
+	if got := lineDiff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("lineDiff() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,245 @@
+package seeddiff
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"velocloud-cloudinit-builder/internal/fsutil"
+	"velocloud-cloudinit-builder/internal/inspect"
+	"velocloud-cloudinit-builder/internal/iso9660"
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+// side is one half of a comparison: file path inside the image to contents.
+type side struct {
+	label string
+	files map[string][]byte
+	// volumeID is the label of an ISO.
+	volumeID string
+	// templateDir is set for a templates directory, whose files are
+	// rendered before the comparison.
+	templateDir string
+	// seedsOnly is set for unrendered templates, which hold more than the
+	// ISO would.
+	seedsOnly bool
+}
+
+// Options controls how Run reads templates directories.
+type Options struct {
+	// Render renders the templates directory dir into the files of an image
+	// labelled volumeID, the label of the ISO on the other side, or "" when
+	// both sides are templates. Nil compares the unrendered seed templates.
+	Render func(dir, volumeID string) (map[string][]byte, error)
+}
+
+// Run compares a and b, each an ISO image, a templates directory or a
+// directory extracted from an ISO, and writes a semantic diff of every file
+// to w. Multipart or gzipped user-data is compared part by part. It reports
+// whether any difference was found.
+func Run(a, b string, opts Options, w io.Writer) (bool, error) {
+	left, err := load(a)
+	if err != nil {
+		return false, err
+	}
+	right, err := load(b)
+	if err != nil {
+		return false, err
+	}
+	for _, pair := range [][2]*side{{left, right}, {right, left}} {
+		if err := pair[0].render(opts, pair[1].volumeID); err != nil {
+			return false, err
+		}
+		pair[0].splitUserData()
+	}
+
+	names := map[string]bool{}
+	for _, s := range []*side{left, right} {
+		for name := range s.files {
+			names[name] = true
+		}
+	}
+	if left.seedsOnly || right.seedsOnly {
+		for name := range names {
+			if !isSeed(name) {
+				delete(names, name)
+			}
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	fmt.Fprintf(w, "--- a: %s\n+++ b: %s\n", left.label, right.label)
+	changed := false
+	for _, name := range sorted {
+		aData, inA := left.files[name]
+		bData, inB := right.files[name]
+		switch {
+		case !inA:
+			fmt.Fprintf(w, "\n=== %s: only in b ===\n", name)
+			changed = true
+		case !inB:
+			fmt.Fprintf(w, "\n=== %s: only in a ===\n", name)
+			changed = true
+		default:
+			lines := compare(name, aData, bData)
+			if len(lines) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\n=== %s ===\n", name)
+			for _, l := range lines {
+				fmt.Fprintln(w, l)
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		fmt.Fprintln(w, "\nNo differences.")
+	}
+	return changed, nil
+}
+
+// render replaces the seed templates of a templates directory with the
+// files an image labelled volumeID would hold.
+func (s *side) render(opts Options, volumeID string) error {
+	if s.templateDir == "" || opts.Render == nil {
+		return nil
+	}
+	files, err := opts.Render(s.templateDir, volumeID)
+	if err != nil {
+		return fmt.Errorf("render %s: %w", s.templateDir, err)
+	}
+	s.files = files
+	s.seedsOnly = false
+	return nil
+}
+
+// splitUserData replaces gzipped user-data with its contents, and
+// multipart user-data with one file per part, named after the part.
+func (s *side) splitUserData() {
+	for name, data := range s.files {
+		if !inspect.IsUserData(name) || !(userdata.IsGzip(data) || userdata.IsMultipart(data)) {
+			continue
+		}
+		parts, err := userdata.Split(data)
+		if err != nil {
+			// Left as is, the raw payloads still get a line diff.
+			continue
+		}
+		delete(s.files, name)
+		if !userdata.IsMultipart(data) && len(parts) == 1 {
+			s.files[name] = parts[0].Data
+			continue
+		}
+		for i, p := range parts {
+			partName := filepath.Base(p.Filename)
+			if p.Filename == "" {
+				partName = fmt.Sprintf("part-%02d", i+1)
+			}
+			s.files[fmt.Sprintf("%s [%s]", name, partName)] = p.Data
+		}
+	}
+}
+
+func isSeed(name string) bool {
+	for _, seed := range inspect.SeedFiles {
+		if name == seed {
+			return true
+		}
+	}
+	return false
+}
+
+func load(p string) (*side, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return loadDir(p)
+	}
+	return loadISO(p)
+}
+
+func loadISO(p string) (*side, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd, err := iso9660.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", p, err)
+	}
+	s := &side{label: p, files: map[string][]byte{}, volumeID: rd.VolumeID()}
+	for _, e := range rd.Entries() {
+		if e.IsDir {
+			continue
+		}
+		data, err := rd.ReadFile(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.files[e.Path] = data
+	}
+	return s, nil
+}
+
+// loadDir reads a templates directory (user-data.txt) or a directory
+// extracted from an ISO, whose files are all compared.
+func loadDir(p string) (*side, error) {
+	s := &side{label: p, files: map[string][]byte{}}
+	if exists, err := fsutil.PathExists(filepath.Join(p, "user-data.txt")); err != nil {
+		return nil, err
+	} else if exists {
+		s.templateDir = p
+		s.seedsOnly = true
+		for _, seed := range inspect.SeedFiles {
+			data, err := os.ReadFile(filepath.Join(p, seed+".txt"))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			s.files[seed] = data
+		}
+		return s, nil
+	}
+	err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// inspect --extract splits multipart user-data into <seed>.parts/.
+		if d.IsDir() && strings.HasSuffix(d.Name(), ".parts") {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(p, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		s.files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(s.files) == 0 {
+		return nil, fmt.Errorf("%s holds neither templates (user-data.txt) nor extracted seed files", p)
+	}
+	return s, nil
+}
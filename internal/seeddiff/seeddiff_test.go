@@ -0,0 +1,182 @@
+package seeddiff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/iso9660"
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+func writeISO(t *testing.T, label string, files map[string][]byte) string {
+	t.Helper()
+	img := iso9660.New(iso9660.Options{VolumeID: label, Joliet: true, RockRidge: true, Created: time.Unix(1700000000, 0)})
+	for name, data := range files {
+		if err := img.AddFile(iso9660.File{Path: name, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "seed.iso")
+	if err := img.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	userData := []byte("#cloud-config\nhostname: edge1\n")
+	metaData := []byte("instance-id: edge1\n")
+	multipart := func(script string) []byte {
+		data, err := userdata.Assemble([]userdata.Part{
+			{Filename: "user-data.txt", Data: userData},
+			{Filename: "10-run.sh", Data: []byte(script)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	gzipped, err := userdata.Gzip(userData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := map[string][]byte{"user-data": userData, "meta-data": metaData}
+	configDrive := map[string][]byte{
+		"openstack/latest/user_data":      userData,
+		"openstack/latest/meta_data.json": []byte(`{"uuid": "edge1"}`),
+	}
+	templates := map[string]string{"user-data.txt": "#cloud-config\nhostname: {{ .hostname }}\n", "meta-data.txt": string(metaData)}
+	// render stands in for builder.RenderFiles: it fills in the hostname and
+	// lays the seeds out for the label of the other side.
+	render := func(dir, volumeID string) (map[string][]byte, error) {
+		if volumeID == "config-2" {
+			return configDrive, nil
+		}
+		return seed, nil
+	}
+
+	tests := []struct {
+		name        string
+		a, b        func(t *testing.T) string
+		opts        Options
+		wantChanged bool
+		want        []string
+	}{
+		{
+			name:        "identical isos",
+			a:           func(t *testing.T) string { return writeISO(t, "cidata", seed) },
+			b:           func(t *testing.T) string { return writeISO(t, "cidata", seed) },
+			wantChanged: false,
+			want:        []string{"No differences."},
+		},
+		{
+			name: "gzipped user-data equals plain user-data",
+			a:    func(t *testing.T) string { return writeISO(t, "cidata", seed) },
+			b: func(t *testing.T) string {
+				return writeISO(t, "cidata", map[string][]byte{"user-data": gzipped, "meta-data": metaData})
+			},
+			want: []string{"No differences."},
+		},
+		{
+			name: "multipart user-data is compared part by part",
+			a: func(t *testing.T) string {
+				return writeISO(t, "cidata", map[string][]byte{"user-data": multipart("#!/bin/sh\necho a\n"), "meta-data": metaData})
+			},
+			b: func(t *testing.T) string {
+				return writeISO(t, "cidata", map[string][]byte{"user-data": multipart("#!/bin/sh\necho b\n"), "meta-data": metaData})
+			},
+			wantChanged: true,
+			want:        []string{"=== user-data [10-run.sh] ===", "- echo a", "+ echo b"},
+		},
+		{
+			name:        "file only on one side",
+			a:           func(t *testing.T) string { return writeISO(t, "cidata", seed) },
+			b:           func(t *testing.T) string { return writeISO(t, "cidata", map[string][]byte{"user-data": userData}) },
+			wantChanged: true,
+			want:        []string{"=== meta-data: only in a ==="},
+		},
+		{
+			name:        "unrendered templates keep their placeholders",
+			a:           func(t *testing.T) string { return writeISO(t, "cidata", seed) },
+			b:           func(t *testing.T) string { return writeDir(t, templates) },
+			wantChanged: true,
+			want:        []string{"- hostname: edge1", "+ hostname: {{ .hostname }}"},
+		},
+		{
+			name: "templates are rendered",
+			a:    func(t *testing.T) string { return writeISO(t, "cidata", seed) },
+			b:    func(t *testing.T) string { return writeDir(t, templates) },
+			opts: Options{Render: render},
+			want: []string{"No differences."},
+		},
+		{
+			name: "templates are rendered as a config drive",
+			a:    func(t *testing.T) string { return writeDir(t, templates) },
+			b:    func(t *testing.T) string { return writeISO(t, "config-2", configDrive) },
+			opts: Options{Render: render},
+			want: []string{"No differences."},
+		},
+		{
+			name: "extracted directory",
+			a: func(t *testing.T) string {
+				return writeDir(t, map[string]string{
+					"openstack/latest/user_data":            string(userData),
+					"openstack/latest/meta_data.json":       `{"uuid": "edge2"}`,
+					"openstack/latest/user_data.parts/01-x": "ignored",
+				})
+			},
+			b:           func(t *testing.T) string { return writeISO(t, "config-2", configDrive) },
+			wantChanged: true,
+			want:        []string{"=== openstack/latest/meta_data.json ===", `~ uuid: "edge2" -> "edge1"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			changed, err := Run(tt.a(t), tt.b(t), tt.opts, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("output lacks %q:\n%s", s, out.String())
+				}
+			}
+			if strings.Contains(out.String(), ".parts") {
+				t.Errorf("output compares split parts:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	empty := t.TempDir()
+	if _, err := Run(empty, empty, Options{}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "neither templates") {
+		t.Errorf("empty directory: error = %v", err)
+	}
+	if _, err := Run(filepath.Join(empty, "missing.iso"), empty, Options{}, &bytes.Buffer{}); err == nil {
+		t.Error("missing ISO: no error")
+	}
+}
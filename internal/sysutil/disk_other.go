@@ -0,0 +1,15 @@
+//go:build !windows
+
+package sysutil
+
+import "syscall"
+
+// FreeSpace returns the bytes available to the current user on the file
+// system holding path.
+func FreeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
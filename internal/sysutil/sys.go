@@ -10,6 +10,8 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+
+	"velocloud-cloudinit-builder/internal/redact"
 )
 
 // Logger is the minimal logging interface used by this package.
@@ -101,13 +103,15 @@ func RunCommand(opts RunOptions, name string, args ...string) (*RunResult, error
 	return result, nil
 }
 
+// commandString formats a command for logs and error messages, with
+// registered secrets masked.
 func commandString(name string, args []string) string {
 	quoted := make([]string, 0, 1+len(args))
 	quoted = append(quoted, shellQuote(name))
 	for _, a := range args {
 		quoted = append(quoted, shellQuote(a))
 	}
-	return strings.Join(quoted, " ")
+	return redact.String(strings.Join(quoted, " "))
 }
 
 func shellQuote(s string) string {
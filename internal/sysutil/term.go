@@ -0,0 +1,44 @@
+package sysutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ReadPassword prints prompt and reads one line from r, which must wrap
+// stdin, without echoing it when stdin is a terminal. Echo is restored
+// before the process exits if the prompt is interrupted.
+func ReadPassword(r *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	var once sync.Once
+	disabled := disableEcho()
+	restore := func() { once.Do(disabled) }
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigs:
+			restore()
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	line, err := r.ReadString('\n')
+	signal.Stop(sigs)
+	close(done)
+	restore()
+	fmt.Fprintln(os.Stderr)
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package sysutil
+
+import (
+	"os"
+	"os/exec"
+)
+
+// disableEcho turns off terminal echo for stdin and returns a function that
+// restores it. It is a no-op when stdin is not a terminal.
+func disableEcho() func() {
+	off := exec.Command("stty", "-echo")
+	off.Stdin = os.Stdin
+	if err := off.Run(); err != nil {
+		return func() {}
+	}
+	return func() {
+		on := exec.Command("stty", "echo")
+		on.Stdin = os.Stdin
+		_ = on.Run()
+	}
+}
@@ -0,0 +1,24 @@
+package sysutil
+
+import (
+	"os"
+	"syscall"
+)
+
+const enableEchoInput = 0x0004
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// disableEcho turns off console echo for stdin and returns a function that
+// restores the previous mode. It is a no-op when stdin is not a console.
+func disableEcho() func() {
+	h := syscall.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return func() {}
+	}
+	if r, _, _ := procSetConsoleMode.Call(uintptr(h), uintptr(mode&^enableEchoInput)); r == 0 {
+		return func() {}
+	}
+	return func() { procSetConsoleMode.Call(uintptr(h), uintptr(mode)) }
+}
@@ -0,0 +1,227 @@
+// Package userdata assembles cloud-init user-data parts into a MIME
+// multipart document and splits such documents back into their parts.
+package userdata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// Part is a single user-data part.
+type Part struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// headerTypes maps the first line of a part to its cloud-init content type.
+// Longer headers come first so that #cloud-config-archive is not taken for
+// #cloud-config.
+var headerTypes = []struct {
+	prefix      string
+	contentType string
+}{
+	{"## template: jinja", "text/jinja2"},
+	{"#cloud-config-archive", "text/cloud-config-archive"},
+	{"#cloud-config-jsonp", "text/cloud-config-jsonp"},
+	{"#cloud-config", "text/cloud-config"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#include-once", "text/x-include-once-url"},
+	{"#include", "text/x-include-url"},
+	{"#part-handler", "text/part-handler"},
+	{"#upstart-job", "text/upstart-job"},
+	{"#!", "text/x-shellscript"},
+}
+
+// ContentType infers the cloud-init content type of a part from its first
+// line.
+func ContentType(data []byte) (string, error) {
+	line, _, _ := strings.Cut(string(data), "\n")
+	line = strings.TrimSpace(line)
+	for _, h := range headerTypes {
+		if strings.HasPrefix(line, h.prefix) {
+			return h.contentType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown user-data header %q (expected #cloud-config, #!, #include, #cloud-boothook, ...)", line)
+}
+
+// IsGzip reports whether data starts with the gzip magic number.
+func IsGzip(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// IsMultipart reports whether data is a MIME document.
+func IsMultipart(data []byte) bool {
+	line, _, _ := strings.Cut(string(data), "\n")
+	line = strings.ToLower(strings.TrimSpace(line))
+	return strings.HasPrefix(line, "content-type:") || strings.HasPrefix(line, "mime-version:")
+}
+
+// Assemble returns a multipart/mixed document holding parts, in order. Parts
+// without a content type get one inferred from their header. The boundary is
+// derived from the contents, so identical parts give identical output.
+func Assemble(parts []Part) ([]byte, error) {
+	if len(parts) == 0 {
+		return nil, errors.New("userdata: no parts to assemble")
+	}
+	sum := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(sum, "%s\x00%d\x00", p.Filename, len(p.Data))
+		sum.Write(p.Data)
+	}
+	boundary := "===============" + hex.EncodeToString(sum.Sum(nil))[:20] + "=="
+
+	// Lines end in LF only, as in the output of cloud-init's make-mime.
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: %s\nMIME-Version: 1.0\n",
+		mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": boundary}))
+	for _, p := range parts {
+		contentType := p.ContentType
+		if contentType == "" {
+			var err error
+			if contentType, err = ContentType(p.Data); err != nil {
+				return nil, fmt.Errorf("%s: %w", p.Filename, err)
+			}
+		}
+		charset, encoding := "us-ascii", "7bit"
+		if !isASCII(p.Data) {
+			if !utf8.Valid(p.Data) {
+				return nil, fmt.Errorf("%s: user-data parts must be text", p.Filename)
+			}
+			charset, encoding = "utf-8", "8bit"
+		}
+		fmt.Fprintf(&buf, "\n--%s\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s\n", mime.FormatMediaType(contentType, map[string]string{"charset": charset}))
+		buf.WriteString("MIME-Version: 1.0\n")
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: %s\n", encoding)
+		if p.Filename != "" {
+			fmt.Fprintf(&buf, "Content-Disposition: %s\n", mime.FormatMediaType("attachment", map[string]string{"filename": p.Filename}))
+		}
+		buf.WriteString("\n")
+		buf.Write(p.Data)
+		if len(p.Data) > 0 && p.Data[len(p.Data)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(&buf, "\n--%s--\n", boundary)
+	return buf.Bytes(), nil
+}
+
+// Split returns the parts of a user-data document, decompressing gzip data
+// first. A document that is not MIME multipart is returned as a single part.
+func Split(data []byte) ([]Part, error) {
+	if IsGzip(data) {
+		var err error
+		if data, err = Gunzip(data); err != nil {
+			return nil, err
+		}
+	}
+	if !IsMultipart(data) {
+		contentType, _ := ContentType(data)
+		return []Part{{ContentType: contentType, Data: data}}, nil
+	}
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	header, err := tr.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("userdata: read MIME header: %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("userdata: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := decodeBody(tr.R, header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return nil, err
+		}
+		return []Part{{ContentType: mediaType, Data: body}}, nil
+	}
+	mr := multipart.NewReader(tr.R, params["boundary"])
+	var parts []Part
+	for {
+		p, err := mr.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("userdata: read part %d: %w", len(parts)+1, err)
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		body, err := decodeBody(p, p.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return nil, fmt.Errorf("userdata: part %d: %w", len(parts)+1, err)
+		}
+		if IsGzip(body) {
+			if body, err = Gunzip(body); err != nil {
+				return nil, fmt.Errorf("userdata: part %d: %w", len(parts)+1, err)
+			}
+		}
+		parts = append(parts, Part{Filename: p.FileName(), ContentType: partType, Data: body})
+	}
+	return parts, nil
+}
+
+// Gzip compresses data without a file name or modification time, so the
+// output only depends on data.
+func Gzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Gunzip decompresses gzip data.
+func Gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("userdata: gunzip: %w", err)
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("userdata: gunzip: %w", err)
+	}
+	return out, nil
+}
+
+func decodeBody(r io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, r))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(r))
+	default:
+		return io.ReadAll(r)
+	}
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
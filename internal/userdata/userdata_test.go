@@ -0,0 +1,214 @@
+package userdata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"#cloud-config\nhostname: a\n", "text/cloud-config"},
+		{"#cloud-config-archive\n- type: x\n", "text/cloud-config-archive"},
+		{"#cloud-config-jsonp\n[]", "text/cloud-config-jsonp"},
+		{"## template: jinja\n#cloud-config\n", "text/jinja2"},
+		{"#!/bin/sh\necho hi\n", "text/x-shellscript"},
+		{"  #!/bin/bash\r\n", "text/x-shellscript"},
+		{"#include\nhttp://example.com/a\n", "text/x-include-url"},
+		{"#include-once\nhttp://example.com/a\n", "text/x-include-once-url"},
+		{"#cloud-boothook\n#!/bin/sh\n", "text/cloud-boothook"},
+		{"#part-handler\n", "text/part-handler"},
+		{"#upstart-job\n", "text/upstart-job"},
+	}
+	for _, tt := range tests {
+		got, err := ContentType([]byte(tt.data))
+		if err != nil || got != tt.want {
+			t.Errorf("ContentType(%q) = %q, %v, want %q", tt.data, got, err, tt.want)
+		}
+	}
+	if _, err := ContentType([]byte("hostname: a\n")); err == nil || !strings.Contains(err.Error(), "unknown user-data header") {
+		t.Errorf("ContentType without a header: error = %v", err)
+	}
+}
+
+func TestAssembleSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []Part
+		// want is the split result; nil means the input parts with their
+		// inferred content types.
+		want []Part
+	}{
+		{
+			name: "cloud-config and script",
+			parts: []Part{
+				{Filename: "user-data.txt", Data: []byte("#cloud-config\nhostname: edge1\n")},
+				{Filename: "10-run.sh", Data: []byte("#!/bin/sh\necho hi\n")},
+			},
+		},
+		{
+			name:  "explicit content type",
+			parts: []Part{{Filename: "x", ContentType: "text/x-shellscript", Data: []byte("echo no shebang\n")}},
+		},
+		{
+			name:  "utf-8 text",
+			parts: []Part{{Filename: "motd.yaml", Data: []byte("#cloud-config\nfinal_message: \"prêt ✓\"\n")}},
+		},
+		{
+			name:  "no file name",
+			parts: []Part{{Data: []byte("#include\nhttp://example.com/a\n")}},
+		},
+		{
+			name:  "missing final newline is added",
+			parts: []Part{{Filename: "a.sh", Data: []byte("#!/bin/sh\ntrue")}},
+			want:  []Part{{Filename: "a.sh", ContentType: "text/x-shellscript", Data: []byte("#!/bin/sh\ntrue\n")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Assemble(tt.parts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsMultipart(doc) {
+				t.Fatalf("Assemble() output is not multipart:\n%s", doc)
+			}
+			if bytes.Contains(doc, []byte("\r\n")) {
+				t.Error("Assemble() output contains CRLF line endings")
+			}
+			want := tt.want
+			if want == nil {
+				for _, p := range tt.parts {
+					if p.ContentType == "" {
+						p.ContentType, _ = ContentType(p.Data)
+					}
+					want = append(want, p)
+				}
+			}
+			for _, data := range [][]byte{doc, mustGzip(t, doc)} {
+				got, err := Split(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Split() =\n%+v\nwant\n%+v", got, want)
+				}
+			}
+			again, err := Assemble(tt.parts)
+			if err != nil || !bytes.Equal(again, doc) {
+				t.Error("Assemble() is not deterministic")
+			}
+		})
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []Part
+		want  string
+	}{
+		{name: "no parts", want: "no parts to assemble"},
+		{name: "unknown header", parts: []Part{{Filename: "a.txt", Data: []byte("hello\n")}}, want: "a.txt: unknown user-data header"},
+		{name: "binary", parts: []Part{{Filename: "b.sh", Data: []byte("#!/bin/sh\n\xff\xfe\n")}}, want: "b.sh: user-data parts must be text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.parts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	gzipped := mustGzip(t, []byte("#cloud-config\nhostname: a\n"))
+	tests := []struct {
+		name    string
+		data    string
+		want    []Part
+		wantErr string
+	}{
+		{
+			name: "plain cloud-config",
+			data: "#cloud-config\nhostname: a\n",
+			want: []Part{{ContentType: "text/cloud-config", Data: []byte("#cloud-config\nhostname: a\n")}},
+		},
+		{
+			name: "gzip",
+			data: string(gzipped),
+			want: []Part{{ContentType: "text/cloud-config", Data: []byte("#cloud-config\nhostname: a\n")}},
+		},
+		{
+			name: "single non-multipart MIME part",
+			data: "Content-Type: text/x-shellscript\nContent-Transfer-Encoding: base64\n\nIyEvYmluL3NoCg==\n",
+			want: []Part{{ContentType: "text/x-shellscript", Data: []byte("#!/bin/sh\n")}},
+		},
+		{
+			name: "CRLF multipart with encoded and gzipped parts",
+			data: "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"XX\"\r\n\r\n" +
+				"--XX\r\nContent-Type: text/x-shellscript\r\nContent-Transfer-Encoding: quoted-printable\r\n" +
+				"Content-Disposition: attachment; filename=\"a.sh\"\r\n\r\n#!/bin/sh\r\necho caf=C3=A9\r\n" +
+				"--XX\r\nContent-Type: text/cloud-config\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+				b64(gzipped) + "\r\n--XX--\r\n",
+			want: []Part{
+				{Filename: "a.sh", ContentType: "text/x-shellscript", Data: []byte("#!/bin/sh\r\necho café")},
+				{ContentType: "text/cloud-config", Data: []byte("#cloud-config\nhostname: a\n")},
+			},
+		},
+		{name: "bad gzip", data: "\x1f\x8bnot gzip", wantErr: "gunzip"},
+		{name: "bad content type", data: "Content-Type: ;;;\n\nx", wantErr: "userdata:"},
+		{
+			name:    "truncated multipart",
+			data:    "Content-Type: multipart/mixed; boundary=XX\n\n--XX\nContent-Type: text/plain\n\nabc",
+			wantErr: "part 1: unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Split([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGzipDeterministic(t *testing.T) {
+	a, b := mustGzip(t, []byte("data")), mustGzip(t, []byte("data"))
+	if !bytes.Equal(a, b) || !IsGzip(a) {
+		t.Error("Gzip() output is not deterministic")
+	}
+	out, err := Gunzip(a)
+	if err != nil || string(out) != "data" {
+		t.Errorf("Gunzip() = %q, %v", out, err)
+	}
+}
+
+func mustGzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	out, err := Gzip(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func b64(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
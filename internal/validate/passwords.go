@@ -0,0 +1,119 @@
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// passwordHashPattern matches the crypt(3) formats cloud-init passes to
+// chpasswd -e instead of treating them as plaintext.
+var passwordHashPattern = regexp.MustCompile(`^\$(1|2a|2y|5|6|y)(\$[^$:\s]+){2,3}$`)
+
+// IsPasswordHash reports whether s is a crypt(3) password hash.
+func IsPasswordHash(s string) bool {
+	return passwordHashPattern.MatchString(s)
+}
+
+// lintPasswords warns about every plaintext password in a cloud-config
+// document rooted at root.
+func (c *checker) lintPasswords(root *yaml.Node) {
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+	plain := func(n *yaml.Node, path string) {
+		if n != nil && n.Kind == yaml.ScalarNode && n.Value != "" && !IsPasswordHash(n.Value) {
+			c.add(n, true, "%s: plaintext password; use a SHA-512 hash (build --password-prompt or sha512crypt)", path)
+		}
+	}
+	plain(lookup(root, "password"), "password")
+
+	if chpasswd := lookup(root, "chpasswd"); chpasswd != nil && chpasswd.Kind == yaml.MappingNode {
+		if list := lookup(chpasswd, "list"); list != nil {
+			var lines []string
+			switch list.Kind {
+			case yaml.ScalarNode:
+				lines = strings.Split(list.Value, "\n")
+			case yaml.SequenceNode:
+				for _, item := range list.Content {
+					lines = append(lines, item.Value)
+				}
+			}
+			for _, line := range lines {
+				user, pw, ok := strings.Cut(strings.TrimSpace(line), ":")
+				if ok && pw != "" && pw != "RANDOM" && pw != "R" && !IsPasswordHash(pw) {
+					c.add(list, true, "chpasswd.list: plaintext password for %s; use a SHA-512 hash", user)
+				}
+			}
+		}
+		if users := lookup(chpasswd, "users"); users != nil && users.Kind == yaml.SequenceNode {
+			for i, u := range users.Content {
+				if t := lookup(u, "type"); t != nil && (t.Value == "hash" || t.Value == "RANDOM") {
+					continue
+				}
+				plain(lookup(u, "password"), fmt.Sprintf("chpasswd.users[%d].password", i))
+			}
+		}
+	}
+
+	if users := lookup(root, "users"); users != nil && users.Kind == yaml.SequenceNode {
+		for i, u := range users.Content {
+			if n := lookup(u, "plain_text_passwd"); n != nil {
+				c.add(n, true, "users[%d].plain_text_passwd: plaintext password; use hashed_passwd", i)
+			}
+			plain(lookup(u, "passwd"), fmt.Sprintf("users[%d].passwd", i))
+		}
+	}
+
+	if n := lookup(root, "ssh_pwauth"); n != nil && isTrue(n.Value) && !hasCredentials(root) {
+		c.add(n, true, "ssh_pwauth: password logins are enabled but no password or SSH key is set (build --password-prompt or --ssh-key)")
+	}
+}
+
+// hasCredentials reports whether a cloud-config document sets any password
+// or SSH key that would let someone log in.
+func hasCredentials(root *yaml.Node) bool {
+	set := func(n *yaml.Node) bool {
+		return n != nil && (n.Kind != yaml.ScalarNode || n.Value != "")
+	}
+	if set(lookup(root, "password")) || set(lookup(root, "ssh_authorized_keys")) {
+		return true
+	}
+	if chpasswd := lookup(root, "chpasswd"); set(lookup(chpasswd, "list")) || set(lookup(chpasswd, "users")) {
+		return true
+	}
+	if users := lookup(root, "users"); users != nil && users.Kind == yaml.SequenceNode {
+		for _, u := range users.Content {
+			for _, key := range []string{"passwd", "hashed_passwd", "plain_text_passwd", "ssh_authorized_keys"} {
+				if set(lookup(u, key)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isTrue reports whether a YAML scalar is one of the true values cloud-init
+// accepts for boolean options.
+func isTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+func lookup(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsPasswordHash(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", true},
+		{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", true},
+		{"$5$salt$hash", true},
+		{"$1$salt$hash", true},
+		{"$2y$10$abcdefghijklmnopqrstuv", true},
+		{"$y$j9T$salt$hash", true},
+		{"Velocloud123", false},
+		{"$6$", false},
+		{"$6$salt", false},
+		{"$7$salt$hash", false},
+		{"$6$salt$has h", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsPasswordHash(tt.s); got != tt.want {
+			t.Errorf("IsPasswordHash(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestLintPasswords(t *testing.T) {
+	const hash = "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			name: "hashed passwords",
+			doc:  "#cloud-config\npassword: " + hash + "\nusers:\n  - name: a\n    passwd: " + hash + "\n",
+		},
+		{
+			name: "top-level plaintext",
+			doc:  "#cloud-config\npassword: Velocloud123\n",
+			want: []string{"user-data.txt:2:11: warning: password: plaintext password; use a SHA-512 hash (build --password-prompt or sha512crypt)"},
+		},
+		{
+			name: "chpasswd list",
+			doc:  "#cloud-config\nchpasswd:\n  list: |\n    root:secret\n    admin:" + hash + "\n    ops:RANDOM\n",
+			want: []string{"user-data.txt:3:9: warning: chpasswd.list: plaintext password for root; use a SHA-512 hash"},
+		},
+		{
+			name: "chpasswd list as sequence",
+			doc:  "#cloud-config\nchpasswd:\n  list:\n    - root:secret\n    - ops:R\n",
+			want: []string{"user-data.txt:4:5: warning: chpasswd.list: plaintext password for root; use a SHA-512 hash"},
+		},
+		{
+			name: "chpasswd users",
+			doc:  "#cloud-config\nchpasswd:\n  users:\n    - {name: a, password: secret}\n    - {name: b, password: secret, type: text}\n    - {name: c, password: " + hash + ", type: hash}\n    - {name: d, type: RANDOM}\n",
+			want: []string{
+				"user-data.txt:4:27: warning: chpasswd.users[0].password: plaintext password; use a SHA-512 hash (build --password-prompt or sha512crypt)",
+				"user-data.txt:5:27: warning: chpasswd.users[1].password: plaintext password; use a SHA-512 hash (build --password-prompt or sha512crypt)",
+			},
+		},
+		{
+			name: "users entries",
+			doc:  "#cloud-config\nusers:\n  - name: a\n    plain_text_passwd: x\n  - name: b\n    passwd: secret\n",
+			want: []string{
+				"user-data.txt:4:24: warning: users[0].plain_text_passwd: plaintext password; use hashed_passwd",
+				"user-data.txt:6:13: warning: users[1].passwd: plaintext password; use a SHA-512 hash (build --password-prompt or sha512crypt)",
+			},
+		},
+		{
+			name: "ssh_pwauth without credentials",
+			doc:  "#cloud-config\nchpasswd: {expire: False}\nssh_pwauth: True\n",
+			want: []string{"user-data.txt:3:13: warning: ssh_pwauth: password logins are enabled but no password or SSH key is set (build --password-prompt or --ssh-key)"},
+		},
+		{
+			name: "ssh_pwauth with a password",
+			doc:  "#cloud-config\npassword: " + hash + "\nssh_pwauth: true\n",
+		},
+		{
+			name: "ssh_pwauth with a user key",
+			doc:  "#cloud-config\nssh_pwauth: yes\nusers:\n  - name: a\n    ssh_authorized_keys: [ssh-ed25519 AAAA a@b]\n",
+		},
+		{
+			name: "ssh_pwauth disabled",
+			doc:  "#cloud-config\nssh_pwauth: false\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, issue := range Check("user-data.txt", "user-data", []byte(tt.doc)) {
+				got = append(got, issue.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("issues =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package validate
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed schema/cloud-config.json
+var cloudConfigSchemaJSON []byte
+
+// cloudConfigSchema is the parsed embedded schema.
+var cloudConfigSchema = mustParseSchema(cloudConfigSchemaJSON)
+
+// schema is the subset of JSON Schema understood by the checker: type,
+// properties, additionalProperties (boolean only), required, items, enum,
+// anyOf and local $ref pointers into $defs.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 typeList           `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	AnyOf                []*schema          `json:"anyOf"`
+	Defs                 map[string]*schema `json:"$defs"`
+}
+
+// typeList accepts both "type": "string" and "type": ["string", "array"].
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = typeList{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*t = many
+	return nil
+}
+
+func mustParseSchema(data []byte) *schema {
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		panic(fmt.Sprintf("validate: embedded schema: %v", err))
+	}
+	return &s
+}
+
+// checker walks a YAML document against a schema and collects issues.
+type checker struct {
+	root   *schema
+	file   string
+	issues []Issue
+}
+
+func (c *checker) add(n *yaml.Node, warning bool, format string, args ...interface{}) {
+	c.issues = append(c.issues, Issue{
+		File:    c.file,
+		Line:    n.Line,
+		Column:  n.Column,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+func (c *checker) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/$defs/")
+		s = c.root.Defs[name]
+	}
+	return s
+}
+
+func (c *checker) check(s *schema, n *yaml.Node, path string) {
+	s = c.resolve(s)
+	if s == nil {
+		return
+	}
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	if len(s.AnyOf) > 0 {
+		var expected []string
+		for _, branch := range s.AnyOf {
+			branch = c.resolve(branch)
+			if c.accepts(branch, n) {
+				c.check(branch, n, path)
+				return
+			}
+			expected = append(expected, describe(branch))
+		}
+		c.add(n, false, "%s: expected %s, got %s", label(path), strings.Join(expected, " or "), nodeType(n))
+		return
+	}
+	if !c.accepts(s, n) {
+		if len(s.Type) == 0 {
+			c.add(n, false, "%s: expected %s, got %q", label(path), describe(s), n.Value)
+		} else {
+			c.add(n, false, "%s: expected %s, got %s", label(path), describe(s), nodeType(n))
+		}
+		return
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			seen[key.Value] = true
+			child := join(path, key.Value)
+			if prop, ok := s.Properties[key.Value]; ok {
+				c.check(prop, value, child)
+				continue
+			}
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				msg := fmt.Sprintf("%s: unknown key", label(child))
+				if hint := closest(key.Value, s.Properties); hint != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", hint)
+				}
+				c.add(key, true, "%s", msg)
+			}
+		}
+		for _, req := range s.Required {
+			if !seen[req] {
+				c.add(n, false, "%s: missing required key %q", label(path), req)
+			}
+		}
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range n.Content {
+				c.check(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+// accepts reports whether n matches the type and enum constraints of s,
+// without looking at nested values.
+func (c *checker) accepts(s *schema, n *yaml.Node) bool {
+	if len(s.Type) > 0 {
+		ok := false
+		for _, t := range s.Type {
+			if typeMatches(t, n) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(s.Enum) > 0 {
+		if n.Kind != yaml.ScalarNode {
+			return false
+		}
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == n.Value {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func typeMatches(t string, n *yaml.Node) bool {
+	actual := nodeType(n)
+	switch {
+	case t == actual:
+		return true
+	case t == "number" && actual == "integer":
+		return true
+	case t == "boolean" && actual == "string":
+		// cloud-init parses YAML 1.1, where yes/no/on/off are booleans.
+		return yaml11Bools[strings.ToLower(n.Value)]
+	}
+	return false
+}
+
+var yaml11Bools = map[string]bool{"yes": true, "no": true, "on": true, "off": true, "y": true, "n": true}
+
+func nodeType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch n.ShortTag() {
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!null":
+		return "null"
+	default:
+		return "string"
+	}
+}
+
+func describe(s *schema) string {
+	if len(s.Enum) > 0 {
+		values := make([]string, 0, len(s.Enum))
+		for _, e := range s.Enum {
+			values = append(values, fmt.Sprintf("%q", fmt.Sprint(e)))
+		}
+		return "one of " + strings.Join(values, ", ")
+	}
+	if len(s.Type) == 0 {
+		return "any value"
+	}
+	return strings.Join(s.Type, " or ")
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func label(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return path
+}
+
+// closest returns the known key nearest to key, when it is a likely typo.
+func closest(key string, props map[string]*schema) string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	best, bestDist := "", 3
+	for _, name := range names {
+		if d := levenshtein(strings.ToLower(key), name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
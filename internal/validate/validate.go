@@ -0,0 +1,402 @@
+// Package validate checks NoCloud seed files before they are packed into an image.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"velocloud-cloudinit-builder/internal/userdata"
+)
+
+const (
+	cloudConfigHeader = "#cloud-config"
+	jinjaHeader       = "## template: jinja"
+)
+
+// Issue is a single problem found in a seed file.
+type Issue struct {
+	File string
+	// Line and Column are 1-based; zero when the position is unknown.
+	Line    int
+	Column  int
+	Message string
+	// Warning issues are reported but do not fail a build.
+	Warning bool
+}
+
+func (i Issue) String() string {
+	severity := "error"
+	if i.Warning {
+		severity = "warning"
+	}
+	pos := i.File
+	if i.Line > 0 {
+		pos += fmt.Sprintf(":%d:%d", i.Line, i.Column)
+	}
+	return fmt.Sprintf("%s: %s: %s", pos, severity, i.Message)
+}
+
+// Errors returns the non-warning issues joined into one error, or nil.
+func Errors(issues []Issue) error {
+	var errs []error
+	for _, i := range issues {
+		if !i.Warning {
+			errs = append(errs, errors.New(i.String()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SeedName maps a file name such as "user-data.txt" to its NoCloud seed
+// name. Unknown names are treated as user-data.
+func SeedName(file string) string {
+	base := strings.TrimSuffix(filepath.Base(file), ".txt")
+	switch base {
+	case "meta-data", "network-config", "vendor-data":
+		return base
+	default:
+		return "user-data"
+	}
+}
+
+// Check validates the contents of the named NoCloud seed file and returns
+// every issue found, reported against file.
+func Check(file, name string, data []byte) []Issue {
+	var err error
+	switch name {
+	case "user-data", "vendor-data":
+		return checkUserData(file, data)
+	case "meta-data":
+		err = MetaData(data)
+	case "network-config":
+		err = NetworkConfig(data)
+	}
+	if err != nil {
+		return []Issue{errorIssue(file, err)}
+	}
+	return nil
+}
+
+// scriptHeaders are user-data formats that cloud-init accepts but that are
+// not cloud-config documents.
+var scriptHeaders = []string{"#!", "#include", "#cloud-boothook", "#part-handler", "#upstart-job", "#cloud-config-archive", "Content-Type:", "MIME-Version:"}
+
+func checkUserData(file string, data []byte) []Issue {
+	header := firstLine(data)
+	switch {
+	case strings.TrimSpace(string(data)) == "":
+		return nil
+	case userdata.IsGzip(data) || userdata.IsMultipart(data):
+		return checkParts(file, data)
+	case strings.HasPrefix(header, jinjaHeader):
+		return []Issue{{File: file, Line: 1, Column: 1, Warning: true, Message: "jinja template is rendered by cloud-init on the guest; schema not checked"}}
+	case header == cloudConfigHeader:
+	default:
+		for _, prefix := range scriptHeaders {
+			if strings.HasPrefix(header, prefix) {
+				return nil
+			}
+		}
+		return []Issue{{File: file, Line: 1, Column: 1, Message: fmt.Sprintf("missing %s header, cloud-init would ignore this file (first line is %q)", cloudConfigHeader, header)}}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []Issue{errorIssue(file, err)}
+	}
+	var decoded interface{}
+	if err := root.Decode(&decoded); err != nil {
+		return []Issue{errorIssue(file, err)}
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	c := &checker{root: cloudConfigSchema, file: file}
+	c.check(cloudConfigSchema, root.Content[0], "")
+	c.lintPasswords(root.Content[0])
+	return c.issues
+}
+
+// checkParts validates every cloud-config part of a gzipped or MIME
+// multipart user-data, reporting issues against file[part].
+func checkParts(file string, data []byte) []Issue {
+	parts, err := userdata.Split(data)
+	if err != nil {
+		return []Issue{errorIssue(file, err)}
+	}
+	var issues []Issue
+	for i, p := range parts {
+		name := p.Filename
+		if name == "" {
+			name = fmt.Sprintf("part %d", i+1)
+		}
+		switch p.ContentType {
+		case "", "text/cloud-config", "text/jinja2":
+			issues = append(issues, checkUserData(fmt.Sprintf("%s[%s]", file, name), p.Data)...)
+		}
+	}
+	return issues
+}
+
+var (
+	yamlLine       = regexp.MustCompile(`line (\d+)`)
+	yamlLinePrefix = regexp.MustCompile(`^(yaml: )?line \d+: `)
+)
+
+// errorIssue converts err into an Issue, recovering the line number from
+// YAML parser messages when present.
+func errorIssue(file string, err error) Issue {
+	msg := err.Error()
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = typeErr.Errors[0]
+	}
+	issue := Issue{File: file, Message: msg}
+	if m := yamlLine.FindStringSubmatch(msg); m != nil {
+		issue.Line, _ = strconv.Atoi(m[1])
+		issue.Column = 1
+		issue.Message = strings.TrimPrefix(yamlLinePrefix.ReplaceAllString(msg, ""), "yaml: ")
+	}
+	return issue
+}
+
+// MetaData checks that data is a YAML mapping.
+func MetaData(data []byte) error {
+	_, err := decodeMapping(data)
+	return err
+}
+
+// NetworkConfig checks a NoCloud network-config document in either version 1
+// or version 2 format, optionally wrapped in a top-level "network" key.
+func NetworkConfig(data []byte) error {
+	doc, err := decodeMapping(data)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return errors.New("network-config is empty")
+	}
+	if wrapped, ok := doc["network"]; ok {
+		inner, ok := wrapped.(map[string]interface{})
+		if !ok {
+			return errors.New("network: expected a mapping")
+		}
+		doc = inner
+	}
+	switch version(doc["version"]) {
+	case 1:
+		return networkV1(doc)
+	case 2:
+		return networkV2(doc)
+	default:
+		return fmt.Errorf("version: expected 1 or 2, got %v", doc["version"])
+	}
+}
+
+var v1Types = map[string]bool{
+	"physical":   true,
+	"bond":       true,
+	"bridge":     true,
+	"vlan":       true,
+	"nameserver": true,
+	"route":      true,
+}
+
+func networkV1(doc map[string]interface{}) error {
+	items, ok := doc["config"].([]interface{})
+	if !ok {
+		return errors.New("config: expected a list of network entries")
+	}
+	for i, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("config[%d]: expected a mapping", i)
+		}
+		typ, _ := item["type"].(string)
+		if !v1Types[typ] {
+			return fmt.Errorf("config[%d].type: unsupported type %q", i, typ)
+		}
+		if typ != "nameserver" && typ != "route" {
+			if name, _ := item["name"].(string); name == "" {
+				return fmt.Errorf("config[%d].name: required for %s entries", i, typ)
+			}
+		}
+		subnets, _ := item["subnets"].([]interface{})
+		for j, rawSubnet := range subnets {
+			subnet, ok := rawSubnet.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("config[%d].subnets[%d]: expected a mapping", i, j)
+			}
+			if subnet["type"] != "static" && subnet["type"] != "static6" {
+				continue
+			}
+			addr, _ := subnet["address"].(string)
+			if err := checkAddress(addr, subnet["netmask"] != nil); err != nil {
+				return fmt.Errorf("config[%d].subnets[%d].address: %w", i, j, err)
+			}
+			if gw, ok := subnet["gateway"].(string); ok {
+				if _, err := netip.ParseAddr(gw); err != nil {
+					return fmt.Errorf("config[%d].subnets[%d].gateway: %w", i, j, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// v2Sections lists the top-level keys of netplan's network version 2.
+var v2Sections = map[string]bool{
+	"version":           true,
+	"renderer":          true,
+	"ethernets":         true,
+	"bonds":             true,
+	"bridges":           true,
+	"vlans":             true,
+	"wifis":             true,
+	"tunnels":           true,
+	"vrfs":              true,
+	"modems":            true,
+	"nm-devices":        true,
+	"dummy-devices":     true,
+	"virtual-ethernets": true,
+}
+
+func networkV2(doc map[string]interface{}) error {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, section := range keys {
+		if !v2Sections[section] {
+			return fmt.Errorf("%s: unknown network version 2 key", section)
+		}
+		if section == "version" || section == "renderer" {
+			continue
+		}
+		devices, ok := doc[section].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected a mapping of device names", section)
+		}
+		for name, raw := range devices {
+			dev, ok := raw.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s.%s: expected a mapping", section, name)
+			}
+			if err := checkV2Device(dev); err != nil {
+				return fmt.Errorf("%s.%s.%w", section, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkV2Device(dev map[string]interface{}) error {
+	if raw, ok := dev["addresses"]; ok {
+		addrs, ok := raw.([]interface{})
+		if !ok {
+			return errors.New("addresses: expected a list")
+		}
+		for i, a := range addrs {
+			s, _ := a.(string)
+			if err := checkAddress(s, false); err != nil {
+				return fmt.Errorf("addresses[%d]: %w", i, err)
+			}
+		}
+	}
+	for _, key := range []string{"gateway4", "gateway6"} {
+		if raw, ok := dev[key]; ok {
+			s, _ := raw.(string)
+			if _, err := netip.ParseAddr(s); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAddress accepts an address in CIDR notation, or a bare address when a
+// separate netmask is given.
+func checkAddress(s string, bareAllowed bool) error {
+	if s == "" {
+		return errors.New("missing address")
+	}
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return nil
+	}
+	if bareAllowed {
+		if _, err := netip.ParseAddr(s); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not a valid address in CIDR notation", s)
+}
+
+func version(v interface{}) int {
+	switch t := v.(type) {
+	case int:
+		return t
+	case string:
+		switch strings.TrimSpace(t) {
+		case "1":
+			return 1
+		case "2":
+			return 2
+		}
+	}
+	return 0
+}
+
+// decodeMapping parses data as YAML and requires the top level to be a
+// mapping. An empty document decodes to nil.
+func decodeMapping(data []byte) (map[string]interface{}, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a YAML mapping at the top level, got %T", doc)
+	}
+	return m, nil
+}
+
+func firstLine(data []byte) string {
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(line)
+}
+
+// Report writes every issue to w followed by a summary line, and returns an
+// error when at least one issue is not a warning.
+func Report(w io.Writer, issues []Issue) error {
+	errCount := 0
+	for _, i := range issues {
+		fmt.Fprintln(w, i)
+		if !i.Warning {
+			errCount++
+		}
+	}
+	warnCount := len(issues) - errCount
+	if errCount > 0 {
+		fmt.Fprintf(w, "%d error(s), %d warning(s)\n", errCount, warnCount)
+		return fmt.Errorf("validation failed with %d error(s)", errCount)
+	}
+	if warnCount > 0 {
+		fmt.Fprintf(w, "No errors, %d warning(s)\n", warnCount)
+		return nil
+	}
+	fmt.Fprintln(w, "No problems found.")
+	return nil
+}
@@ -0,0 +1,41 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetworkConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr string
+	}{
+		{name: "v1", doc: "version: 1\nconfig:\n  - type: physical\n    name: eth0\n    subnets:\n      - type: static\n        address: 192.0.2.10/24\n        gateway: 192.0.2.1\n"},
+		{name: "v2 ethernets", doc: "network:\n  version: 2\n  ethernets:\n    eth0:\n      addresses: [192.0.2.10/24]\n"},
+		{name: "v2 tunnels", doc: "version: 2\ntunnels:\n  gre1:\n    mode: gre\n    local: 192.0.2.1\n    remote: 198.51.100.1\n    addresses: [10.0.0.1/30]\n"},
+		{name: "v2 vrfs", doc: "version: 2\nvrfs:\n  vrf1:\n    table: 10\n    interfaces: [eth1]\n"},
+		{name: "v2 modems", doc: "version: 2\nmodems:\n  cdc-wdm1:\n    apn: internet\n"},
+		{name: "v2 nm-devices", doc: "version: 2\nnm-devices:\n  nm1:\n    renderer: NetworkManager\n"},
+		{name: "v2 dummy-devices", doc: "version: 2\ndummy-devices:\n  dm0:\n    addresses: [192.0.2.5/32]\n"},
+		{name: "v2 virtual-ethernets", doc: "version: 2\nvirtual-ethernets:\n  veth0:\n    peer: veth1\n"},
+		{name: "v2 unknown section", doc: "version: 2\nethernet:\n  eth0: {}\n", wantErr: "ethernet: unknown network version 2 key"},
+		{name: "v2 bad address", doc: "version: 2\ntunnels:\n  gre1:\n    addresses: [10.0.0.300/30]\n", wantErr: "tunnels.gre1.addresses"},
+		{name: "bad version", doc: "version: 3\n", wantErr: "expected 1 or 2"},
+		{name: "empty", doc: "", wantErr: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NetworkConfig([]byte(tt.doc))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
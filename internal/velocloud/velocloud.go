@@ -0,0 +1,169 @@
+// Package velocloud generates the VeloCloud Edge activation settings that the
+// VCE image reads from the "velocloud: vce:" block of user-data.
+package velocloud
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VarsKey is the template variable holding the activation parameters, e.g.
+// velocloud.vco and velocloud.activation_code.
+const VarsKey = "velocloud"
+
+// activationCodePattern matches codes issued by the VCO: three or four
+// groups of four letters or digits separated by dashes.
+var activationCodePattern = regexp.MustCompile(`^[A-Z0-9]{4}(-[A-Z0-9]{4}){2,3}$`)
+
+var hostLabelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// Activation holds the parameters of the velocloud: vce: block.
+type Activation struct {
+	VCO              string `yaml:"vco"`
+	ActivationCode   string `yaml:"activation_code"`
+	IgnoreCertErrors bool   `yaml:"vco_ignore_cert_errors"`
+	// VCOUpdate is only written when set explicitly.
+	VCOUpdate *bool `yaml:"vco_update,omitempty"`
+}
+
+// FromVars reads the activation parameters from the velocloud template
+// variable. Values may be strings, as supplied by --set, CSV inventories and
+// the environment, or native YAML types.
+func FromVars(vars map[string]interface{}) (Activation, error) {
+	var a Activation
+	raw, ok := vars[VarsKey]
+	if !ok || raw == nil {
+		return a, nil
+	}
+	m, ok := asMapping(raw)
+	if !ok {
+		return a, fmt.Errorf("%s: expected a mapping, got %T", VarsKey, raw)
+	}
+	a.VCO = strings.TrimSpace(stringValue(m["vco"]))
+	a.ActivationCode = strings.TrimSpace(stringValue(m["activation_code"]))
+	var err error
+	if v, ok := m["vco_ignore_cert_errors"]; ok {
+		if a.IgnoreCertErrors, err = boolValue(v); err != nil {
+			return a, fmt.Errorf("%s.vco_ignore_cert_errors: %w", VarsKey, err)
+		}
+	}
+	if v, ok := m["vco_update"]; ok {
+		b, err := boolValue(v)
+		if err != nil {
+			return a, fmt.Errorf("%s.vco_update: %w", VarsKey, err)
+		}
+		a.VCOUpdate = &b
+	}
+	return a, nil
+}
+
+// Validate reports every missing or malformed parameter and normalises the
+// activation code to upper case.
+func (a *Activation) Validate() error {
+	var errs []error
+	if a.VCO == "" {
+		errs = append(errs, fmt.Errorf("%s.vco is required (--vco or %s.vco in vars)", VarsKey, VarsKey))
+	} else if err := checkHost(a.VCO); err != nil {
+		errs = append(errs, fmt.Errorf("%s.vco: %w", VarsKey, err))
+	}
+	a.ActivationCode = strings.ToUpper(a.ActivationCode)
+	switch {
+	case a.ActivationCode == "":
+		errs = append(errs, fmt.Errorf("%s.activation_code is required (--activation-code or %s.activation_code in vars)", VarsKey, VarsKey))
+	case !activationCodePattern.MatchString(a.ActivationCode):
+		errs = append(errs, fmt.Errorf("%s.activation_code: %q is not a valid activation code (expected XXXX-XXXX-XXXX or XXXX-XXXX-XXXX-XXXX)", VarsKey, a.ActivationCode))
+	}
+	return errors.Join(errs...)
+}
+
+// Block returns the YAML for the velocloud: vce: block.
+func (a Activation) Block() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string]map[string]Activation{VarsKey: {"vce": a}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkHost accepts an IP address or DNS name, optionally with a port, but
+// no URL scheme or path.
+func checkHost(host string) error {
+	if strings.Contains(host, "://") || strings.Contains(host, "/") {
+		return fmt.Errorf("%q must be a host name or IP address, without scheme or path", host)
+	}
+	if strings.Count(host, ":") == 1 || strings.HasPrefix(host, "[") {
+		h, port, err := net.SplitHostPort(host)
+		if err != nil {
+			return fmt.Errorf("%q: %w", host, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%q has an invalid port", host)
+		}
+		host = h
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+	if len(host) > 253 {
+		return fmt.Errorf("%q is too long for a host name", host)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if !hostLabelPattern.MatchString(label) {
+			return fmt.Errorf("%q is not a valid host name", host)
+		}
+	}
+	return nil
+}
+
+var mappingType = reflect.TypeOf(map[string]interface{}(nil))
+
+// asMapping returns v as a plain map. Variables decoded from YAML files arrive
+// as named map types such as render.Vars.
+func asMapping(v interface{}) (map[string]interface{}, bool) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || !rv.CanConvert(mappingType) {
+		return nil, false
+	}
+	return rv.Convert(mappingType).Interface().(map[string]interface{}), true
+}
+
+func stringValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func boolValue(v interface{}) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(t)) {
+		case "yes", "on", "y":
+			return true, nil
+		case "no", "off", "n", "":
+			return false, nil
+		}
+		return strconv.ParseBool(strings.TrimSpace(t))
+	default:
+		return false, fmt.Errorf("expected a boolean, got %v", v)
+	}
+}
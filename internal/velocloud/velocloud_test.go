@@ -0,0 +1,127 @@
+package velocloud
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// namedVars stands in for render.Vars, which YAML vars files decode into.
+type namedVars map[string]interface{}
+
+func TestFromVars(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name    string
+		vars    map[string]interface{}
+		want    Activation
+		wantErr string
+	}{
+		{name: "no velocloud variable", vars: map[string]interface{}{"hostname": "edge1"}},
+		{
+			name: "strings",
+			vars: map[string]interface{}{VarsKey: map[string]interface{}{"vco": " vco.example.com ", "activation_code": "abcd-efgh-ijkl", "vco_ignore_cert_errors": "yes", "vco_update": "true"}},
+			want: Activation{VCO: "vco.example.com", ActivationCode: "abcd-efgh-ijkl", IgnoreCertErrors: true, VCOUpdate: &yes},
+		},
+		{
+			name: "vars file mapping",
+			vars: map[string]interface{}{VarsKey: namedVars{"vco": "192.0.2.1", "activation_code": "ABCD-EFGH-IJKL-MNOP", "vco_ignore_cert_errors": false}},
+			want: Activation{VCO: "192.0.2.1", ActivationCode: "ABCD-EFGH-IJKL-MNOP"},
+		},
+		{name: "not a mapping", vars: map[string]interface{}{VarsKey: "vco.example.com"}, wantErr: "expected a mapping"},
+		{name: "bad boolean", vars: map[string]interface{}{VarsKey: map[string]interface{}{"vco_ignore_cert_errors": "maybe"}}, wantErr: "velocloud.vco_ignore_cert_errors"},
+		{name: "bad vco_update", vars: map[string]interface{}{VarsKey: map[string]interface{}{"vco_update": 1}}, wantErr: "velocloud.vco_update"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromVars(tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromVars() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        Activation
+		wantCode string
+		wantErr  []string
+	}{
+		{name: "four groups", a: Activation{VCO: "vco.example.com", ActivationCode: "ABCD-EFGH-IJKL-MNOP"}, wantCode: "ABCD-EFGH-IJKL-MNOP"},
+		{name: "three groups", a: Activation{VCO: "vco.example.com", ActivationCode: "ABCD-1234-EFGH"}, wantCode: "ABCD-1234-EFGH"},
+		{name: "lower case code", a: Activation{VCO: "vco.example.com", ActivationCode: "abcd-efgh-ijkl-mnop"}, wantCode: "ABCD-EFGH-IJKL-MNOP"},
+		{name: "ipv4 with port", a: Activation{VCO: "192.0.2.1:8443", ActivationCode: "ABCD-EFGH-IJKL"}, wantCode: "ABCD-EFGH-IJKL"},
+		{name: "bracketed ipv6 with port", a: Activation{VCO: "[2001:db8::1]:443", ActivationCode: "ABCD-EFGH-IJKL"}, wantCode: "ABCD-EFGH-IJKL"},
+		{name: "bare ipv6", a: Activation{VCO: "2001:db8::1", ActivationCode: "ABCD-EFGH-IJKL"}, wantCode: "ABCD-EFGH-IJKL"},
+		{name: "missing vco", a: Activation{ActivationCode: "ABCD-EFGH-IJKL"}, wantErr: []string{"velocloud.vco is required"}},
+		{name: "url", a: Activation{VCO: "https://vco.example.com", ActivationCode: "ABCD-EFGH-IJKL"}, wantErr: []string{"without scheme or path"}},
+		{name: "bad port", a: Activation{VCO: "vco.example.com:99999", ActivationCode: "ABCD-EFGH-IJKL"}, wantErr: []string{"invalid port"}},
+		{name: "bad host label", a: Activation{VCO: "vco_1.example.com", ActivationCode: "ABCD-EFGH-IJKL"}, wantErr: []string{"not a valid host name"}},
+		{name: "two groups", a: Activation{VCO: "vco.example.com", ActivationCode: "ABCD-EFGH"}, wantErr: []string{"expected XXXX-XXXX-XXXX or XXXX-XXXX-XXXX-XXXX"}},
+		{name: "five groups", a: Activation{VCO: "vco.example.com", ActivationCode: "ABCD-EFGH-IJKL-MNOP-QRST"}, wantErr: []string{"not a valid activation code"}},
+		{name: "short group", a: Activation{VCO: "vco.example.com", ActivationCode: "ABCD-EFG-IJKL"}, wantErr: []string{"not a valid activation code"}},
+		{name: "everything missing", a: Activation{}, wantErr: []string{"velocloud.vco is required", "velocloud.activation_code is required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.a
+			err := a.Validate()
+			if len(tt.wantErr) > 0 {
+				for _, want := range tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("error = %v, want it to contain %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.ActivationCode != tt.wantCode {
+				t.Errorf("ActivationCode = %q, want %q", a.ActivationCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestBlock(t *testing.T) {
+	no := false
+	tests := []struct {
+		name string
+		a    Activation
+		want string
+	}{
+		{
+			name: "defaults",
+			a:    Activation{VCO: "vco.example.com", ActivationCode: "ABCD-EFGH-IJKL-MNOP"},
+			want: "velocloud:\n  vce:\n    vco: vco.example.com\n    activation_code: ABCD-EFGH-IJKL-MNOP\n    vco_ignore_cert_errors: false\n",
+		},
+		{
+			name: "vco_update",
+			a:    Activation{VCO: "192.0.2.1:8443", ActivationCode: "ABCD-EFGH-IJKL", IgnoreCertErrors: true, VCOUpdate: &no},
+			want: "velocloud:\n  vce:\n    vco: 192.0.2.1:8443\n    activation_code: ABCD-EFGH-IJKL\n    vco_ignore_cert_errors: true\n    vco_update: false\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Block()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Block() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package vmtest
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"velocloud-cloudinit-builder/internal/config"
 	"velocloud-cloudinit-builder/internal/deps"
 	"velocloud-cloudinit-builder/internal/fsutil"
 	"velocloud-cloudinit-builder/internal/logutil"
@@ -14,15 +18,33 @@ import (
 	"velocloud-cloudinit-builder/internal/sysutil"
 )
 
-const (
-	testLogPrefix   = "test"
-	vmRunTimeout    = 30 * time.Minute
-	isoRelativePath = "images/cloud-init.iso"
-	qcowRelative    = "images/velocloud.qcow2"
-)
+const testLogPrefix = "test"
+
+// Options selects the VM and the images it boots.
+type Options struct {
+	// VMPath is a portable VM executable. Empty uses the QEMU of
+	// deps.EnsureQEMU.
+	VMPath string
+	// ISOPath is the cloud-init seed image. ISO images are attached as a
+	// CD-ROM and vfat images as a read-only disk.
+	ISOPath string
+	// DiskPath is the base qcow2 disk. The VM boots a temporary clone.
+	DiskPath string
+	// Config supplies the QEMU path, memory, CPUs, MAC address, accelerator
+	// and timeout of the VM. Nil uses config.Default().
+	Config *config.Config
+}
 
-// Run starts a VM with the generated ISO for validation. When vmPath is empty, a bundled QEMU build is used.
-func Run(baseDir, vmPath string, passthroughArgs []string) error {
+// Run starts a VM with the ISO and a clone of the disk in opts for validation.
+func Run(baseDir string, opts Options, passthroughArgs []string) error {
+	if opts.ISOPath == "" || opts.DiskPath == "" {
+		return errors.New("vm test needs both an ISO and a disk path")
+	}
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	settings := cfg.Test
 	logger, logFile, logPath, err := logutil.NewOperationLogger(baseDir, testLogPrefix)
 	if err != nil {
 		return err
@@ -31,17 +53,31 @@ func Run(baseDir, vmPath string, passthroughArgs []string) error {
 
 	output.Printf("[*] Logging test output to %s\n", relPath(baseDir, logPath))
 
+	// The seed is checked first, so a wrong image fails before QEMU is
+	// downloaded.
+	isoPath, err := filepath.Abs(opts.ISOPath)
+	if err != nil {
+		return fmt.Errorf("resolve iso path: %w", err)
+	}
+	if err := ensureFileExists(isoPath, "cloud-init seed image"); err != nil {
+		return err
+	}
+	media, err := seedMedia(isoPath)
+	if err != nil {
+		return err
+	}
+
 	var absVM string
-	usingBundledQEMU := false
-	if vmPath == "" {
-		output.Println("[*] Preparing bundled QEMU runtime...")
-		absVM, err = deps.EnsureQEMU(baseDir, logger)
+	usingManagedQEMU := false
+	if opts.VMPath == "" {
+		output.Println("[*] Preparing QEMU runtime...")
+		absVM, err = deps.EnsureQEMU(baseDir, cfg.Deps, logger)
 		if err != nil {
 			return fmt.Errorf("ensure qemu: %w", err)
 		}
-		usingBundledQEMU = true
+		usingManagedQEMU = true
 	} else {
-		absVM, err = filepath.Abs(vmPath)
+		absVM, err = filepath.Abs(opts.VMPath)
 		if err != nil {
 			return fmt.Errorf("resolve vm path: %w", err)
 		}
@@ -50,11 +86,10 @@ func Run(baseDir, vmPath string, passthroughArgs []string) error {
 		}
 	}
 
-	isoPath := filepath.Join(baseDir, filepath.FromSlash(isoRelativePath))
-	if err := ensureFileExists(isoPath, "cloud-init ISO"); err != nil {
-		return err
+	qcowPath, err := filepath.Abs(opts.DiskPath)
+	if err != nil {
+		return fmt.Errorf("resolve disk path: %w", err)
 	}
-	qcowPath := filepath.Join(baseDir, filepath.FromSlash(qcowRelative))
 	if err := ensureFileExists(qcowPath, "velocloud qcow2 image"); err != nil {
 		return err
 	}
@@ -78,10 +113,13 @@ func Run(baseDir, vmPath string, passthroughArgs []string) error {
 	}()
 
 	var args []string
-	if usingBundledQEMU || looksLikeQEMU(absVM) {
-		output.Println("[*] Launching QEMU with qcow2 + ISO...")
-		args = defaultQEMUArgs(clonePath, isoPath)
+	if usingManagedQEMU || looksLikeQEMU(absVM) {
+		output.Printf("[*] Launching QEMU with qcow2 + %s seed...\n", media)
+		args = defaultQEMUArgs(deps.TestVMName(baseDir), clonePath, isoPath, media, settings)
 	} else {
+		if media != mediaISO {
+			return fmt.Errorf("%s is a %s image; a custom VM executable can only attach ISO seeds", relPath(baseDir, isoPath), media)
+		}
 		output.Println("[*] Launching provided VM executable...")
 		args = []string{"--disk", clonePath, "--cdrom", isoPath}
 	}
@@ -90,7 +128,7 @@ func Run(baseDir, vmPath string, passthroughArgs []string) error {
 	}
 
 	if _, err := sysutil.RunCommand(sysutil.RunOptions{
-		Timeout: vmRunTimeout,
+		Timeout: settings.Timeout,
 		Dir:     baseDir,
 		Logger:  logger,
 		Stdout:  logFile,
@@ -103,28 +141,66 @@ func Run(baseDir, vmPath string, passthroughArgs []string) error {
 	return nil
 }
 
-func defaultQEMUArgs(diskPath, isoPath string) []string {
-	return []string{
-		"-name", "cloudinit-builder-test,process=cloudinit-builder-test",
-		"-m", "4096",
-		"-smp", "2",
+func defaultQEMUArgs(vmName, diskPath, seedPath, media string, settings config.Test) []string {
+	seed := []string{"-cdrom", seedPath}
+	if media == mediaVFAT {
+		seed = []string{"-drive", fmt.Sprintf("if=virtio,format=raw,readonly=on,file=%s", seedPath)}
+	}
+	args := []string{
+		"-name", vmName + ",process=" + vmName,
+		"-m", strconv.Itoa(settings.MemoryMB),
+		"-smp", strconv.Itoa(settings.CPUs),
 		"-drive", fmt.Sprintf("if=virtio,format=qcow2,file=%s", diskPath),
-		"-cdrom", isoPath,
+	}
+	args = append(args, seed...)
+	return append(args,
 		"-boot", "d",
-		"-accel", defaultAccel(),
+		"-accel", Accel(settings.Accel),
 		"-netdev", "user,id=wan,ipv6=off",
-		"-device", "virtio-net-pci,netdev=wan,mac=52:54:00:00:00:01",
+		"-device", "virtio-net-pci,netdev=wan,mac="+strings.ToLower(settings.MAC),
 		"-vga", "std",
 		"-display", "sdl",
 		"-serial", "stdio",
+	)
+}
+
+// Seed media kinds recognised by seedMedia.
+const (
+	mediaISO  = "ISO"
+	mediaVFAT = "vfat"
+)
+
+// seedMedia tells ISO9660 seed images from FAT ones by their on-disk
+// signatures. Anything else, such as a guestinfo .vmx snippet, cannot be
+// attached to the VM.
+func seedMedia(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 16*2048+6)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", fmt.Errorf("read seed image: %w", err)
+	}
+	head = head[:n]
+	switch {
+	case len(head) >= 16*2048+6 && string(head[16*2048+1:16*2048+6]) == "CD001":
+		return mediaISO, nil
+	case len(head) >= 512 && head[510] == 0x55 && head[511] == 0xAA && string(head[54:57]) == "FAT":
+		return mediaVFAT, nil
+	default:
+		return "", fmt.Errorf("%s is neither an ISO nor a vfat seed image (guestinfo output cannot be attached to a VM; build with --format iso or vfat)", path)
 	}
 }
 
-func defaultAccel() string {
+// Accel returns CLOUDINIT_BUILDER_QEMU_ACCEL, falling back to configured.
+func Accel(configured string) string {
 	if v := strings.TrimSpace(os.Getenv("CLOUDINIT_BUILDER_QEMU_ACCEL")); v != "" {
 		return v
 	}
-	return "tcg"
+	return configured
 }
 
 func looksLikeQEMU(path string) bool {
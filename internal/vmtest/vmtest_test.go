@@ -0,0 +1,88 @@
+package vmtest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocloud-cloudinit-builder/internal/config"
+	"velocloud-cloudinit-builder/internal/fat"
+	"velocloud-cloudinit-builder/internal/iso9660"
+)
+
+func TestSeedMedia(t *testing.T) {
+	dir := t.TempDir()
+	iso := iso9660.New(iso9660.Options{VolumeID: "cidata", Created: time.Unix(0, 0)})
+	vfat, err := fat.New(fat.Options{Label: "cidata", Created: time.Unix(0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, add := range []func() error{
+		func() error { return iso.AddFile(iso9660.File{Path: "user-data", Data: []byte("#cloud-config\n")}) },
+		func() error { return vfat.AddFile(fat.File{Path: "user-data", Data: []byte("#cloud-config\n")}) },
+	} {
+		if err := add(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	var isoData, fatData bytes.Buffer
+	if _, err := iso.WriteTo(&isoData); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vfat.WriteTo(&fatData); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{name: "iso", path: write("cloud-init.iso", isoData.Bytes()), want: mediaISO},
+		{name: "vfat", path: write("cloud-init.img", fatData.Bytes()), want: mediaVFAT},
+		{name: "guestinfo", path: write("cloud-init.vmx", []byte("guestinfo.userdata = \"H4sI\"\n")), wantErr: "guestinfo output cannot be attached"},
+		{name: "empty", path: write("empty.iso", nil), wantErr: "neither an ISO nor a vfat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := seedMedia(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("seedMedia() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultQEMUArgs(t *testing.T) {
+	settings := config.Default().Test
+	tests := []struct {
+		media string
+		want  string
+	}{
+		{media: mediaISO, want: "-cdrom seed"},
+		{media: mediaVFAT, want: "-drive if=virtio,format=raw,readonly=on,file=seed"},
+	}
+	for _, tt := range tests {
+		args := strings.Join(defaultQEMUArgs("vm", "disk.qcow2", "seed", tt.media, settings), " ")
+		if !strings.Contains(args, tt.want) || !strings.Contains(args, "-drive if=virtio,format=qcow2,file=disk.qcow2") {
+			t.Errorf("%s: args = %s, want them to contain %q", tt.media, args, tt.want)
+		}
+	}
+}